@@ -0,0 +1,282 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+
+	"github.com/thinkfluent/pubsubc/pkg/pubsubc"
+)
+
+var adminListen = flag.String("listen", "", "Keep running and serve an HTTP API for applying more configs on this address, such as localhost:8085")
+
+// adminConfig is a config applied to one emulator host, as listed by
+// GET /configs.
+type adminConfig struct {
+	Project string       `json:"project"`
+	Source  string       `json:"source"`
+	Host    string       `json:"host,omitempty"`
+	Topics  []adminTopic `json:"topics"`
+	Applied bool         `json:"applied"`
+	Errors  []string     `json:"errors,omitempty"`
+	config  *projectConfig
+}
+
+type adminTopic struct {
+	ID            string   `json:"id"`
+	Subscriptions []string `json:"subscriptions"`
+}
+
+var (
+	// adminServer is serving the admin API while -listen is set.
+	adminServer *http.Server
+	// adminReady is set once the discovered configs have been applied, and
+	// the admin API accepts configs of its own.
+	adminReady atomic.Bool
+	// adminConfigs are the configs applied so far, guarded by adminMu.
+	adminConfigs []adminConfig
+	adminMu      sync.Mutex
+	// adminApplying serializes the configs posted to the admin API, as
+	// applying uses the pending configs and totals of the whole run.
+	adminApplying sync.Mutex
+	adminRequests int
+)
+
+// listenAdmin starts listening for the admin API, so that GET /healthz can be
+// polled while the discovered configs are applied. Configs posted before then
+// are refused.
+func listenAdmin() {
+	if *adminListen == "" {
+		return
+	}
+	listener, err := net.Listen("tcp", *adminListen)
+	if err != nil {
+		fatalf("Unable to listen on %s: %s", *adminListen, err)
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", serveAdminHealth)
+	mux.HandleFunc("/configs", serveAdminConfigs)
+	adminServer = &http.Server{Handler: mux}
+	go func() {
+		if err := adminServer.Serve(listener); err != nil && err != http.ErrServerClosed {
+			fatalf("Unable to serve the admin API on %s: %s", *adminListen, err)
+		}
+	}()
+}
+
+// serveAdmin marks the admin API ready and keeps serving it until
+// interrupted.
+func serveAdmin() {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	adminReady.Store(true)
+	fmt.Printf("Serving the admin API on %s\n", *adminListen)
+	<-ctx.Done()
+	sdNotify("STOPPING=1")
+	adminServer.Close()
+	fmt.Println("Stopped serving the admin API")
+}
+
+// recordAdminTargets records what became of every target applied, for
+// GET /configs.
+func recordAdminTargets(hosts []string, byHost map[string][]*applyTarget) {
+	if adminServer == nil {
+		return
+	}
+	adminMu.Lock()
+	defer adminMu.Unlock()
+	for _, host := range hosts {
+		for _, target := range byHost[host] {
+			config := adminConfig{
+				Project: target.config.projectID,
+				Source:  target.config.sourceHint,
+				Host:    host,
+				Topics:  []adminTopic{},
+				Applied: !target.failed,
+				config:  target.config,
+			}
+			for _, entry := range target.config.topics {
+				topic := adminTopic{ID: entry.ID, Subscriptions: []string{}}
+				for _, subscription := range target.config.withAutoSub(entry) {
+					topic.Subscriptions = append(topic.Subscriptions, subscription.ID)
+				}
+				config.Topics = append(config.Topics, topic)
+			}
+			for _, err := range target.errs {
+				config.Errors = append(config.Errors, err.Error())
+			}
+			adminConfigs = append(adminConfigs, config)
+		}
+	}
+}
+
+func serveAdminHealth(w http.ResponseWriter, r *http.Request) {
+	if !adminReady.Load() {
+		http.Error(w, "Applying the discovered configs", http.StatusServiceUnavailable)
+		return
+	}
+	fmt.Fprintln(w, "ok")
+}
+
+// serveAdminConfigs lists the configs applied so far, or applies a posted
+// config string or YAML or JSON config file.
+func serveAdminConfigs(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		adminMu.Lock()
+		configs := append([]adminConfig{}, adminConfigs...)
+		adminMu.Unlock()
+		writeAdminJSON(w, http.StatusOK, configs)
+	case http.MethodPost:
+		postAdminConfig(w, r)
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		http.Error(w, fmt.Sprintf("Method %s not allowed", r.Method), http.StatusMethodNotAllowed)
+	}
+}
+
+// postAdminConfig applies the config in the body of a request, answering with
+// what became of it on every host, or 422 and the errors if it failed.
+func postAdminConfig(w http.ResponseWriter, r *http.Request) {
+	if !adminReady.Load() {
+		http.Error(w, "Still applying the discovered configs, try again later", http.StatusServiceUnavailable)
+		return
+	}
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, 1<<20))
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Unable to read the config: %s", err), http.StatusBadRequest)
+		return
+	}
+
+	adminApplying.Lock()
+	defer adminApplying.Unlock()
+	adminRequests++
+	source := fmt.Sprintf("admin request %d", adminRequests)
+
+	contentType := r.Header.Get("Content-Type")
+	asFile := strings.Contains(contentType, "json") || strings.Contains(contentType, "yaml")
+	configs, err := parseAdminConfig(string(body), source, asFile)
+	if err != nil {
+		logContext{"source", source}.warnf("%s, skipping the config", err)
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+
+	configCount += len(configs)
+	pendingConfigs = append(pendingConfigs, configs...)
+	applyConfigs()
+	pushRunMetrics()
+	flushAudit()
+	reportProbes()
+
+	var results []adminConfig
+	var failures []string
+	adminMu.Lock()
+	for _, applied := range adminConfigs {
+		for _, config := range configs {
+			if applied.config != config {
+				continue
+			}
+			results = append(results, applied)
+			for _, err := range applied.Errors {
+				if applied.Host != "" {
+					err = fmt.Sprintf("on %s: %s", applied.Host, err)
+				}
+				failures = append(failures, fmt.Sprintf("%s: %s", applied.Source, err))
+			}
+		}
+	}
+	adminMu.Unlock()
+
+	if len(failures) > 0 {
+		http.Error(w, strings.Join(failures, "\n"), http.StatusUnprocessableEntity)
+		return
+	}
+	fmt.Printf("Applied %d Pub/Sub configurations from %s\n", len(configs), source)
+	writeAdminJSON(w, http.StatusCreated, results)
+}
+
+// parseAdminConfig parses a posted config: a YAML or JSON config file, if
+// asFile or it is a mapping, or else a config string. Configs without a
+// project get the default one. Posted config files can't set a host or
+// schemas.
+func parseAdminConfig(body, source string, asFile bool) ([]*projectConfig, error) {
+	body = strings.TrimSpace(body)
+	if body == "" {
+		return nil, fmt.Errorf("%s: Expected a config string or a YAML or JSON config", source)
+	}
+	if !asFile && !strings.HasPrefix(body, "{") && !strings.HasPrefix(body, "projects:") {
+		parsed, err := pubsubc.ParseConfig(body)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s", source, err)
+		}
+		config := &projectConfig{
+			projectID:         parsed.ProjectID,
+			topics:            parsed.Topics,
+			sourceHint:        source,
+			subscriptionsOnly: parsed.SubscriptionsOnly,
+			autoSub:           *autoSub,
+		}
+		if err := resolveConfigProject(config); err != nil {
+			return nil, fmt.Errorf("%s: %s", source, err)
+		}
+		return []*projectConfig{config}, nil
+	}
+
+	file, err := parseConfigFile([]byte(body), source)
+	if err != nil {
+		return nil, err
+	}
+	// Overlays may set hosts too.
+	if len(file.Overlays) > 0 {
+		return nil, fmt.Errorf("%s: Posted configs can't define overlays", source)
+	}
+	var configs []*projectConfig
+	for _, project := range file.Projects {
+		// Anyone who can reach the API can post a config, so it mustn't be
+		// able to read pubsubc's files or point it at another host.
+		if project.Host != "" {
+			return nil, fmt.Errorf("%s:%d: Posted configs can't set host", source, project.line)
+		}
+		for _, topic := range project.Topics {
+			if topic.Schema != nil {
+				return nil, fmt.Errorf("%s:%d: Posted configs can't set a schema, as its definitionFile would be read from pubsubc's filesystem", source, topic.Schema.line)
+			}
+		}
+		config, err := fileProjectConfig(source, ".", project)
+		if errors.Is(err, errNoTopics) {
+			return nil, fmt.Errorf("%s:%d: %s", source, project.line, err)
+		}
+		if err != nil {
+			return nil, err
+		}
+		if err := resolveConfigProject(config); err != nil {
+			return nil, fmt.Errorf("%s: %s", config.sourceHint, err)
+		}
+		configs = append(configs, config)
+	}
+	return configs, nil
+}
+
+func writeAdminJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(v); err != nil {
+		debugf("Unable to write admin API response: %s", err)
+	}
+}
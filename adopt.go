@@ -0,0 +1,183 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"path"
+	"time"
+
+	"cloud.google.com/go/pubsub"
+	"google.golang.org/api/iterator"
+)
+
+// Resources managed by pubsubc carry this label.
+const (
+	managedByLabel = "managed-by"
+	managedByValue = "pubsubc"
+)
+
+// adoptCounts tallies the outcome of an adopt.
+type adoptCounts struct {
+	adopted, managed, conflicts, unmatched, failed int
+}
+
+// runAdopt labels the existing topics and subscriptions of a project that
+// match the discovered configs, or a glob, as managed by pubsubc.
+func runAdopt(args []string) {
+	flags := flag.NewFlagSet("adopt", flag.ExitOnError)
+	projectID := flags.String("project", "", "Project ID to adopt resources in")
+	match := flags.String("match", "", "Adopt every topic and subscription whose ID matches this glob, instead of those in the discovered configs")
+	flags.Parse(args)
+
+	if *projectID == "" {
+		flags.Usage()
+		fatalf("-project is required")
+	}
+	if _, err := path.Match(*match, ""); err != nil {
+		fatalf("Invalid -match %q: %s", *match, err)
+	}
+
+	// What the configs declare, in the same form as what the emulator has.
+	var want topologySnapshot
+	if *match == "" {
+		discoverConfigs()
+		var configs []*projectConfig
+		for _, config := range pendingConfigs {
+			if config.projectID == *projectID {
+				configs = append(configs, config)
+			}
+		}
+		want = declaredTopology(configs)
+		pendingConfigs = nil
+		if len(want.topics) == 0 && len(want.subscriptions) == 0 {
+			fatalf("No Pub/Sub configurations found for project %q", *projectID)
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	client, err := newClient(ctx, *projectID, "")
+	if err != nil {
+		fatalf("Unable to create client to project %q: %s", *projectID, err)
+	}
+
+	unmatched := "isn't in the config"
+	if *match != "" {
+		unmatched = fmt.Sprintf("doesn't match %q", *match)
+	}
+	var counts adoptCounts
+	failed := func(format string, args ...interface{}) {
+		warnf(format, args...)
+		counts.failed++
+	}
+
+	topics := client.Topics(ctx)
+	for {
+		topic, err := topics.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			fatalf("Unable to list topics: %s", err)
+		}
+		topicID := topic.ID()
+		if !adoptMatches(*match, want.topics[topicID], topicID) {
+			fmt.Printf("? topic %s %s\n", topicID, unmatched)
+			counts.unmatched++
+			continue
+		}
+		config, err := topic.Config(ctx)
+		if err != nil {
+			failed("Unable to fetch topic %q: %s", topicID, err)
+			continue
+		}
+		if config.Labels[managedByLabel] == managedByValue {
+			counts.managed++
+			continue
+		}
+		fmt.Printf("+ topic %s\n", topicID)
+		labels := withManagedByLabel(config.Labels)
+		if _, err := topic.Update(ctx, pubsub.TopicConfigToUpdate{Labels: labels}); err != nil {
+			failed("Unable to label topic %q: %s", topicID, err)
+			continue
+		}
+		audit(auditEvent{Action: "updated", Kind: "topic", Project: *projectID, Name: topicID, Source: "adopt"})
+		counts.adopted++
+	}
+
+	subscriptions := client.Subscriptions(ctx)
+	for {
+		config, err := subscriptions.NextConfig()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			fatalf("Unable to list subscriptions: %s", err)
+		}
+		subscriptionID := config.ID()
+		state, declared := want.subscriptions[subscriptionID]
+		if !adoptMatches(*match, declared, subscriptionID) {
+			fmt.Printf("? subscription %s %s\n", subscriptionID, unmatched)
+			counts.unmatched++
+			continue
+		}
+		topicID := subscriptionTopic(config.Topic)
+		if *match == "" {
+			conflict := ""
+			switch {
+			case topicID != state.topic:
+				conflict = fmt.Sprintf("is on topic %q instead of %q", topicID, state.topic)
+			case config.PushConfig.Endpoint != state.pushEndpoint:
+				conflict = fmt.Sprintf("has push endpoint %q instead of %q", config.PushConfig.Endpoint, state.pushEndpoint)
+			}
+			if conflict != "" {
+				fmt.Printf("! subscription %s %s, resolve it manually\n", subscriptionID, conflict)
+				counts.conflicts++
+				continue
+			}
+		}
+		if config.Labels[managedByLabel] == managedByValue {
+			counts.managed++
+			continue
+		}
+		fmt.Printf("+ subscription %s\n", subscriptionID)
+		labels := withManagedByLabel(config.Labels)
+		if _, err := client.Subscription(subscriptionID).Update(ctx, pubsub.SubscriptionConfigToUpdate{Labels: labels}); err != nil {
+			failed("Unable to label subscription %q: %s", subscriptionID, err)
+			continue
+		}
+		audit(auditEvent{Action: "updated", Kind: "subscription", Project: *projectID, Name: subscriptionID, Source: "adopt"})
+		counts.adopted++
+	}
+
+	fmt.Printf("Adopt complete: %d adopted, %d already managed, %d conflicts, %d unmatched, %d failed\n",
+		counts.adopted, counts.managed, counts.conflicts, counts.unmatched, counts.failed)
+	if counts.conflicts > 0 || counts.failed > 0 {
+		flushAudit()
+		os.Exit(1)
+	}
+}
+
+// adoptMatches reports whether a resource should be adopted: when match is
+// set, whether its ID matches the glob, otherwise whether it is declared.
+func adoptMatches(match string, declared bool, id string) bool {
+	if match == "" {
+		return declared
+	}
+	matched, _ := path.Match(match, id)
+	return matched
+}
+
+// withManagedByLabel returns a copy of labels with the managed-by label added.
+func withManagedByLabel(labels map[string]string) map[string]string {
+	result := map[string]string{managedByLabel: managedByValue}
+	for key, value := range labels {
+		if key != managedByLabel {
+			result[key] = value
+		}
+	}
+	return result
+}
@@ -0,0 +1,242 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"cloud.google.com/go/pubsub"
+	"github.com/thinkfluent/pubsubc/pkg/pubsubc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// applyTarget is a config being applied to a single emulator host. client is
+// only set when using the gRPC transport. spent is the time its phases have
+// taken so far, which -per-config-timeout bounds. applier creates its topics
+// and subscriptions, and knows which topics it created or failed. errs are
+// its failures, as reported.
+type applyTarget struct {
+	config   *projectConfig
+	host     string
+	client   *pubsub.Client
+	topology topologyClient
+	spent    time.Duration
+	failed   bool
+	timedOut bool
+	applier  *pubsubc.Applier
+	errs     []error
+	// What was done with the target's resources, added to the run's totals
+	// once it has been applied.
+	topics                resourceCounts
+	subscriptions         resourceCounts
+	seeds                 resourceCounts
+	pushSubscriptions     atomic.Int64
+	bigQuerySubscriptions atomic.Int64
+	autoSubscriptions     atomic.Int64
+}
+
+// applyConfigs creates the resources of every pending config in two phases:
+// every topic in every project first, then every subscription. A subscription
+// can therefore refer to a topic declared by any config, whatever order the
+// configs were discovered in. Seed messages are published last, so every
+// subscription receives them.
+func applyConfigs() {
+	hosts, byHost := pendingTargets()
+	recordDeclared(hosts, byHost)
+	requestSlots = make(chan struct{}, *concurrency)
+	checkLimits(hosts, byHost)
+	awaitEmulators(hosts, byHost)
+	ensureFresh(hosts, byHost)
+
+	debugf("Phase 1: creating topics")
+	runPhase(hosts, byHost, "creating topics", func(ctx context.Context, target *applyTarget) error {
+		if err := connectTopology(ctx, target); err != nil {
+			return err
+		}
+		target.topology = retryingTopology{target.topology, target}
+		target.applier = newApplier(target)
+		return createTopics(ctx, target)
+	})
+
+	debugf("Phase 2: creating subscriptions")
+	runPhase(hosts, byHost, "creating subscriptions", func(ctx context.Context, target *applyTarget) error {
+		return createSubscriptions(ctx, target)
+	})
+
+	debugf("Phase 3: binding IAM roles")
+	runPhase(hosts, byHost, "binding IAM roles", func(ctx context.Context, target *applyTarget) error {
+		return applyIAMPolicies(ctx, target)
+	})
+
+	if !*noSeed {
+		debugf("Phase 4: publishing seed messages")
+		runPhase(hosts, byHost, "publishing seed messages", func(ctx context.Context, target *applyTarget) error {
+			return seedTopics(ctx, target)
+		})
+	}
+
+	// A pass of -reconcile-interval only checks and hooks the configs it
+	// created something for, rather than every config again.
+	checked := byHost
+	if reconciling {
+		checked = createdTargets(hosts, byHost)
+	}
+	verifyDeliveries(hosts, checked)
+	smokeTestPushes(hosts, checked)
+	runPostHooks(hosts, checked)
+
+	audit(auditEvent{Action: "apply-completed", Source: "apply"})
+	summarizeTargets(hosts, byHost)
+	recordAdminTargets(hosts, byHost)
+
+	unapplied := make(map[*projectConfig]bool)
+	for _, host := range hosts {
+		for _, target := range byHost[host] {
+			if target.failed {
+				unapplied[target.config] = true
+			}
+		}
+	}
+	unappliedConfigs += len(unapplied)
+
+	for _, host := range hosts {
+		result, ok := replicaResults[host]
+		if !ok {
+			continue
+		}
+		for _, target := range byHost[host] {
+			if target.timedOut {
+				result.timedOut++
+			} else if target.failed {
+				result.failed++
+			} else {
+				result.applied++
+			}
+		}
+	}
+}
+
+// reportFailures prints how many configs couldn't be applied, whether
+// malformed, failed or timed out, and reports whether they all were. With
+// -best-effort the failures are only a warning.
+func reportFailures() bool {
+	failed := skippedConfigs + unappliedConfigs
+	if failed == 0 {
+		return true
+	}
+	if *bestEffort {
+		warnf("%d of %d configs failed to apply", failed, configCount)
+		return true
+	}
+	fmt.Fprintf(os.Stderr, "%s: %d of %d configs failed to apply\n", os.Args[0], failed, configCount)
+	return false
+}
+
+// pendingTargets takes the pending configs and groups them by the emulator
+// hosts they are to be applied to, with the hosts in discovery order.
+func pendingTargets() ([]string, map[string][]*applyTarget) {
+	byHost := make(map[string][]*applyTarget)
+	var hosts []string
+	for _, config := range pendingConfigs {
+		configHosts := []string{config.host}
+		if config.host == "" && len(replicaHosts) > 0 {
+			configHosts = replicaHosts
+		}
+		for _, host := range configHosts {
+			if _, ok := byHost[host]; !ok {
+				hosts = append(hosts, host)
+			}
+			byHost[host] = append(byHost[host], &applyTarget{config: config, host: host})
+		}
+	}
+	pendingConfigs = nil
+	return hosts, byHost
+}
+
+// runPhase runs step for every target, each in its own goroutine, except
+// those that timed out or couldn't connect. A target whose phases take longer
+// than -per-config-timeout in total is abandoned, so it can't hold up the
+// rest. Each of a target's failures is reported, together. The phase
+// completes for every target before runPhase returns.
+func runPhase(hosts []string, byHost map[string][]*applyTarget, what string, step func(context.Context, *applyTarget) error) {
+	var wg sync.WaitGroup
+	for _, host := range hosts {
+		for _, target := range byHost[host] {
+			if target.timedOut || (target.failed && target.topology == nil) {
+				continue
+			}
+			wg.Add(1)
+			go func(target *applyTarget) {
+				defer wg.Done()
+				started := time.Now()
+				ctx, cancel := context.WithTimeout(context.Background(), *perConfigTimeout-target.spent)
+				err := step(ctx, target)
+				timedOut := ctx.Err() == context.DeadlineExceeded
+				cancel()
+				target.spent += time.Since(started)
+				if timedOut {
+					if target.failed {
+						failedConfigs.Add(-1)
+					}
+					target.failed = true
+					target.timedOut = true
+					timedOutConfigs.Add(1)
+					target.errs = append(target.errs, fmt.Errorf("Timed out after %s when %s", *perConfigTimeout, what))
+					log := target.log()
+					if target.host != "" {
+						log.warnf("%s: Timed out after %s when %s on %s", target.config.sourceHint, *perConfigTimeout, what, target.host)
+					} else {
+						log.warnf("%s: Timed out after %s when %s", target.config.sourceHint, *perConfigTimeout, what)
+					}
+				} else if err != nil {
+					if !target.failed {
+						failedConfigs.Add(1)
+					}
+					target.failed = true
+					for _, err := range unwrapErrors(err) {
+						target.errs = append(target.errs, fmt.Errorf("When %s: %w", what, err))
+						log := target.log(errorFields(err)...)
+						if target.host != "" {
+							log.warnf("%s: When %s on %s: %s", target.config.sourceHint, what, target.host, err.Error())
+						} else {
+							log.warnf("%s: When %s: %s", target.config.sourceHint, what, err.Error())
+						}
+					}
+				}
+			}(target)
+		}
+	}
+	wg.Wait()
+}
+
+// requestSlots bounds how many topics and subscriptions are created at once,
+// across every config, to -concurrency.
+var requestSlots chan struct{}
+
+// unwrapErrors returns the errors joined in err, however deeply, or just err.
+func unwrapErrors(err error) []error {
+	joined, ok := err.(interface{ Unwrap() []error })
+	if !ok {
+		return []error{err}
+	}
+	var errs []error
+	for _, err := range joined.Unwrap() {
+		errs = append(errs, unwrapErrors(err)...)
+	}
+	return errs
+}
+
+// isAlreadyExists reports whether err is from creating a resource that
+// already exists.
+func isAlreadyExists(err error) bool {
+	return status.Code(err) == codes.AlreadyExists
+}
+
+// audit publishes an event for a change made while applying the target.
+func (t *applyTarget) audit(action, kind, name string) {
+	audit(auditEvent{Action: action, Kind: kind, Project: t.config.projectID, Name: name, Host: t.host, Source: t.config.sourceHint})
+}
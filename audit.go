@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"sync"
+	"time"
+
+	"cloud.google.com/go/pubsub"
+)
+
+// auditEvent is the payload of a message published to -audit-topic.
+type auditEvent struct {
+	Action    string    `json:"action"`
+	Kind      string    `json:"kind,omitempty"`
+	Project   string    `json:"project,omitempty"`
+	Name      string    `json:"name,omitempty"`
+	Host      string    `json:"host,omitempty"`
+	Source    string    `json:"source"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+var (
+	auditTopic   *pubsub.Topic
+	auditMu      sync.Mutex
+	auditResults []*pubsub.PublishResult
+)
+
+// configureAudit connects to -audit-topic, creating it if needed. Any failure
+// only disables auditing, so it can't affect the outcome of a run.
+func configureAudit() {
+	if *auditTopicName == "" {
+		return
+	}
+	parts := strings.Split(*auditTopicName, "/")
+	if len(parts) != 4 || parts[0] != "projects" || parts[2] != "topics" || parts[1] == "" || parts[3] == "" {
+		fatalf("-audit-topic must be of the form projects/<project>/topics/<topic>, not %q", *auditTopicName)
+	}
+	if *dryRun {
+		// A dry run makes no changes to report.
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	client, err := newClient(ctx, parts[1], "")
+	if err != nil {
+		warnf("Unable to create client for audit topic %s, not auditing: %s", *auditTopicName, err)
+		return
+	}
+	topic := client.Topic(parts[3])
+	exists, err := topic.Exists(ctx)
+	if err == nil && !exists {
+		debugf("Creating audit topic %s", *auditTopicName)
+		topic, err = client.CreateTopic(ctx, parts[3])
+	}
+	if err != nil {
+		warnf("Unable to create audit topic %s, not auditing: %s", *auditTopicName, err)
+		return
+	}
+	auditTopic = topic
+}
+
+// audit publishes an event to the audit topic, if there is one. The action
+// and kind are also set as attributes, for subscription filters.
+func audit(event auditEvent) {
+	if auditTopic == nil {
+		return
+	}
+	event.Timestamp = time.Now().UTC()
+	data, _ := json.Marshal(event)
+	attributes := map[string]string{"action": event.Action}
+	if event.Kind != "" {
+		attributes["kind"] = event.Kind
+	}
+	result := auditTopic.Publish(context.Background(), &pubsub.Message{Data: data, Attributes: attributes})
+	auditMu.Lock()
+	auditResults = append(auditResults, result)
+	auditMu.Unlock()
+}
+
+// flushAudit waits for every audit event to be published, warning about any
+// that failed.
+func flushAudit() {
+	if auditTopic == nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	auditMu.Lock()
+	results := auditResults
+	auditResults = nil
+	auditMu.Unlock()
+
+	failed := 0
+	var lastErr error
+	for _, result := range results {
+		if _, err := result.Get(ctx); err != nil {
+			failed++
+			lastErr = err
+		}
+	}
+	if failed > 0 {
+		warnf("Unable to publish %d of %d audit events to %s: %s", failed, len(results), *auditTopicName, lastErr)
+	}
+}
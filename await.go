@@ -0,0 +1,114 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"time"
+
+	"google.golang.org/api/iterator"
+)
+
+// runAwaitEmpty waits until subscriptions have no undelivered messages, so
+// tests can tell when everything they produced has been consumed.
+func runAwaitEmpty(args []string) {
+	var subscriptionIDs stringList
+	flags := flag.NewFlagSet("await-empty", flag.ExitOnError)
+	projectID := flags.String("project", "", "Project ID of the subscriptions")
+	flags.Var(&subscriptionIDs, "subscription", "Subscription to wait for (may be repeated, default all in the project)")
+	timeout := flags.Duration("timeout", 60*time.Second, "How long to wait before giving up")
+	interval := flags.Duration("interval", time.Second, "How often to check the subscriptions")
+	flags.Parse(args)
+
+	if *projectID == "" {
+		flags.Usage()
+		fatalf("-project is required")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	defer cancel()
+
+	if len(subscriptionIDs) == 0 {
+		client, err := newClient(ctx, *projectID, "")
+		if err != nil {
+			fatalf("Unable to create client to project %q: %s", *projectID, err)
+		}
+		it := client.Subscriptions(ctx)
+		for {
+			subscription, err := it.Next()
+			if err == iterator.Done {
+				break
+			}
+			if err != nil {
+				fatalf("Unable to list subscriptions for project %q: %s", *projectID, err)
+			}
+			subscriptionIDs = append(subscriptionIDs, subscription.ID())
+		}
+		debugf("Waiting for all %d subscriptions in project %q", len(subscriptionIDs), *projectID)
+	}
+
+	subscriber, err := newSubscriberClient(ctx, "")
+	if err != nil {
+		fatalf("Unable to create subscriber client: %s", err)
+	}
+	defer subscriber.Close()
+
+	remaining := make(map[string]int)
+	for _, subscriptionID := range subscriptionIDs {
+		remaining[subscriptionID] = -1
+	}
+
+	ticker := time.NewTicker(*interval)
+	defer ticker.Stop()
+	for {
+		for subscriptionID := range remaining {
+			name := fmt.Sprintf("projects/%s/subscriptions/%s", *projectID, subscriptionID)
+			sample, err := sampleBacklog(ctx, subscriber, name)
+			if err != nil {
+				if ctx.Err() != nil {
+					break
+				}
+				fatalf("Unable to check subscription %q for project %q: %s", subscriptionID, *projectID, err)
+			}
+			debugf("  Subscription %q has %d undelivered messages", subscriptionID, sample.messages)
+			if sample.messages == 0 {
+				delete(remaining, subscriptionID)
+			} else {
+				remaining[subscriptionID] = sample.messages
+			}
+		}
+
+		if len(remaining) == 0 {
+			fmt.Printf("All %d subscriptions in project %q are empty\n", len(subscriptionIDs), *projectID)
+			return
+		}
+
+		select {
+		case <-ctx.Done():
+			reportRemaining(*projectID, remaining)
+			os.Exit(1)
+		case <-ticker.C:
+		}
+	}
+}
+
+// reportRemaining lists subscriptions that still had messages when waiting
+// timed out.
+func reportRemaining(projectID string, remaining map[string]int) {
+	subscriptionIDs := make([]string, 0, len(remaining))
+	for subscriptionID := range remaining {
+		subscriptionIDs = append(subscriptionIDs, subscriptionID)
+	}
+	sort.Strings(subscriptionIDs)
+
+	fmt.Fprintf(os.Stderr, "%s: Timed out waiting for %d subscriptions in project %q:\n", os.Args[0], len(remaining), projectID)
+	for _, subscriptionID := range subscriptionIDs {
+		if remaining[subscriptionID] < 0 {
+			fmt.Fprintf(os.Stderr, "  %s: not checked\n", subscriptionID)
+		} else {
+			fmt.Fprintf(os.Stderr, "  %s: %d messages remaining\n", subscriptionID, remaining[subscriptionID])
+		}
+	}
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	vkit "cloud.google.com/go/pubsub/apiv1"
+	"cloud.google.com/go/pubsub/apiv1/pubsubpb"
+)
+
+// backlogSampleLimit caps how many messages are pulled from one subscription
+// when sampling its backlog.
+const backlogSampleLimit = 10000
+
+// backlogSample is an approximation of a subscription's undelivered messages.
+type backlogSample struct {
+	messages int
+	oldest   time.Time
+}
+
+// sampleBacklog counts the messages currently available on a subscription by
+// pulling without waiting, then immediately releases them for redelivery.
+// Nothing is acked, although each sample counts as a delivery attempt.
+// Messages already leased to other consumers aren't counted.
+func sampleBacklog(ctx context.Context, subscriber *vkit.SubscriberClient, subscriptionName string) (backlogSample, error) {
+	var sample backlogSample
+	var ackIDs []string
+	defer func() {
+		if len(ackIDs) > 0 {
+			subscriber.ModifyAckDeadline(context.Background(), &pubsubpb.ModifyAckDeadlineRequest{
+				Subscription:       subscriptionName,
+				AckIds:             ackIDs,
+				AckDeadlineSeconds: 0,
+			})
+		}
+	}()
+
+	for sample.messages < backlogSampleLimit {
+		resp, err := subscriber.Pull(ctx, &pubsubpb.PullRequest{
+			Subscription:      subscriptionName,
+			ReturnImmediately: true,
+			MaxMessages:       1000,
+		})
+		if err != nil {
+			return sample, err
+		}
+		if len(resp.ReceivedMessages) == 0 {
+			break
+		}
+
+		for _, received := range resp.ReceivedMessages {
+			ackIDs = append(ackIDs, received.AckId)
+			sample.messages++
+			if published := received.Message.GetPublishTime().AsTime(); sample.oldest.IsZero() || published.Before(sample.oldest) {
+				sample.oldest = published
+			}
+		}
+	}
+	return sample, nil
+}
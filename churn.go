@@ -0,0 +1,222 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"math/rand"
+	"os"
+	"os/signal"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+
+	"cloud.google.com/go/pubsub"
+	"google.golang.org/api/iterator"
+)
+
+// churnActions are the actions churn picks from. Each breaks a managed
+// subscription and, but for purge, restores it after -hold.
+var churnActions = []string{"recreate-sub", "detach", "purge", "flip-push"}
+
+// runChurn periodically performs a random destructive action on a managed
+// subscription of a project, to check that consumers survive it.
+func runChurn(args []string) {
+	flags := flag.NewFlagSet("churn", flag.ExitOnError)
+	projectID := flags.String("project", "", "Project ID to churn")
+	interval := flags.Duration("interval", 30*time.Second, "How often to perform an action")
+	actionList := flags.String("actions", strings.Join(churnActions, ","), "Comma-separated actions to pick from: "+strings.Join(churnActions, ", "))
+	hold := flags.Duration("hold", 5*time.Second, "How long a subscription stays broken before it is restored")
+	seed := flags.Int64("seed", 0, "Seed of the random choices, to repeat a run; by default one is picked and printed")
+	count := flags.Int("count", 0, "Stop after this many actions, 0 to run until interrupted")
+	flags.Parse(args)
+
+	if *projectID == "" {
+		flags.Usage()
+		fatalf("-project is required")
+	}
+	if *interval <= 0 {
+		fatalf("-interval must be positive")
+	}
+	if *hold < 0 {
+		fatalf("-hold can't be negative")
+	}
+	actions, err := parseChurnActions(*actionList)
+	if err != nil {
+		fatalf("%s", err)
+	}
+	requireEmulator("churn")
+	if *seed == 0 {
+		*seed = time.Now().UnixNano()
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	client, err := newClient(ctx, *projectID, "")
+	if err != nil {
+		fatalf("Unable to create client to project %q: %s", *projectID, err)
+	}
+
+	fmt.Printf("Churning project %q every %s with -seed %d\n", *projectID, *interval, *seed)
+	rng := rand.New(rand.NewSource(*seed))
+	for n := 0; *count == 0 || n < *count; n++ {
+		select {
+		case <-ctx.Done():
+			flushAudit()
+			return
+		case <-time.After(*interval):
+		}
+
+		subscriptions, err := listManagedSubscriptions(ctx, client)
+		if err != nil {
+			warnf("Unable to list subscriptions for project %q: %s", *projectID, err)
+			continue
+		}
+		action := actions[rng.Intn(len(actions))]
+		candidates := churnCandidates(subscriptions, action)
+		if len(candidates) == 0 {
+			fmt.Printf("%s %s: no managed subscription to act on, skipping\n", time.Now().Format(time.RFC3339), action)
+			continue
+		}
+		config := candidates[rng.Intn(len(candidates))]
+		if err := churnSubscription(ctx, client, *projectID, action, config, *hold); err != nil {
+			warnf("%s", err)
+		}
+	}
+	flushAudit()
+}
+
+// parseChurnActions parses a comma-separated list of churn actions.
+func parseChurnActions(value string) ([]string, error) {
+	var actions []string
+	for _, action := range strings.Split(value, ",") {
+		action = strings.TrimSpace(action)
+		known := false
+		for _, churnAction := range churnActions {
+			known = known || action == churnAction
+		}
+		if !known {
+			return nil, fmt.Errorf("Unknown -actions %q, expected some of %s", action, strings.Join(churnActions, ", "))
+		}
+		actions = append(actions, action)
+	}
+	return actions, nil
+}
+
+// listManagedSubscriptions lists the subscriptions of a project that carry
+// the managed-by label, by ID. Orphaned subscriptions are left out, as they
+// can't be recreated.
+func listManagedSubscriptions(ctx context.Context, client *pubsub.Client) ([]*pubsub.SubscriptionConfig, error) {
+	var managed []*pubsub.SubscriptionConfig
+	subscriptions := client.Subscriptions(ctx)
+	for {
+		config, err := subscriptions.NextConfig()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if config.Labels[managedByLabel] == managedByValue && subscriptionTopic(config.Topic) != deletedTopic {
+			managed = append(managed, config)
+		}
+	}
+	sort.Slice(managed, func(i, j int) bool { return managed[i].ID() < managed[j].ID() })
+	return managed, nil
+}
+
+// churnCandidates returns the subscriptions action can be performed on: only
+// push subscriptions can be flipped to pull, and detached ones are left alone.
+func churnCandidates(subscriptions []*pubsub.SubscriptionConfig, action string) []*pubsub.SubscriptionConfig {
+	var candidates []*pubsub.SubscriptionConfig
+	for _, config := range subscriptions {
+		if config.Detached || (action == "flip-push" && config.PushConfig.Endpoint == "") {
+			continue
+		}
+		candidates = append(candidates, config)
+	}
+	return candidates
+}
+
+// churnSubscription performs action on a subscription, logging each step,
+// and restores it after hold, or straight away if ctx is cancelled, so an
+// interrupted run doesn't leave it broken.
+func churnSubscription(ctx context.Context, client *pubsub.Client, projectID, action string, config *pubsub.SubscriptionConfig, hold time.Duration) error {
+	opCtx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	id := config.ID()
+	subscription := client.Subscription(id)
+	logStep := func(format string, args ...interface{}) {
+		fmt.Printf("%s %s subscription %s: %s\n", time.Now().Format(time.RFC3339), action, id, fmt.Sprintf(format, args...))
+	}
+	auditStep := func(auditAction string) {
+		audit(auditEvent{Action: auditAction, Kind: "subscription", Project: projectID, Name: id, Source: "churn"})
+	}
+	waitHold := func() {
+		select {
+		case <-ctx.Done():
+		case <-time.After(hold):
+		}
+	}
+	recreate := func() error {
+		restored := *config
+		restored.Detached = false
+		if _, err := client.CreateSubscription(opCtx, id, restored); err != nil {
+			return fmt.Errorf("Unable to recreate subscription %q for project %q: %s", id, projectID, err)
+		}
+		auditStep("created")
+		logStep("recreated")
+		return nil
+	}
+
+	switch action {
+	case "recreate-sub":
+		if err := subscription.Delete(opCtx); err != nil {
+			return fmt.Errorf("Unable to delete subscription %q for project %q: %s", id, projectID, err)
+		}
+		auditStep("deleted")
+		logStep("deleted, recreating it in %s", hold)
+		waitHold()
+		return recreate()
+
+	case "detach":
+		if _, err := client.DetachSubscription(opCtx, subscription.String()); err != nil {
+			return fmt.Errorf("Unable to detach subscription %q for project %q: %s", id, projectID, err)
+		}
+		auditStep("detached")
+		logStep("detached from topic %s, recreating it in %s", config.Topic.ID(), hold)
+		waitHold()
+		// A detached subscription can't be attached again.
+		if err := subscription.Delete(opCtx); err != nil {
+			return fmt.Errorf("Unable to delete detached subscription %q for project %q: %s", id, projectID, err)
+		}
+		auditStep("deleted")
+		return recreate()
+
+	case "purge":
+		if err := subscription.SeekToTime(opCtx, time.Now()); err != nil {
+			return fmt.Errorf("Unable to purge subscription %q for project %q: %s", id, projectID, err)
+		}
+		auditStep("purged")
+		logStep("purged its backlog")
+		return nil
+
+	case "flip-push":
+		if _, err := subscription.Update(opCtx, pubsub.SubscriptionConfigToUpdate{PushConfig: &pubsub.PushConfig{}}); err != nil {
+			return fmt.Errorf("Unable to switch subscription %q for project %q to pull: %s", id, projectID, err)
+		}
+		auditStep("updated")
+		logStep("switched to pull, restoring push endpoint %s in %s", config.PushConfig.Endpoint, hold)
+		waitHold()
+		if _, err := subscription.Update(opCtx, pubsub.SubscriptionConfigToUpdate{PushConfig: &config.PushConfig}); err != nil {
+			return fmt.Errorf("Unable to restore the push endpoint of subscription %q for project %q: %s", id, projectID, err)
+		}
+		auditStep("updated")
+		logStep("restored push endpoint %s", config.PushConfig.Endpoint)
+		return nil
+	}
+	return nil
+}
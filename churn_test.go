@@ -0,0 +1,33 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"cloud.google.com/go/pubsub"
+)
+
+func TestParseChurnActions(t *testing.T) {
+	actions, err := parseChurnActions("recreate-sub, purge")
+	if err != nil || !reflect.DeepEqual(actions, []string{"recreate-sub", "purge"}) {
+		t.Errorf("parseChurnActions() = %v, %v, want [recreate-sub purge]", actions, err)
+	}
+	if _, err := parseChurnActions("purge,delete-topic"); err == nil || !strings.Contains(err.Error(), `"delete-topic"`) {
+		t.Errorf("parseChurnActions() with an unknown action = %v, want an error naming it", err)
+	}
+}
+
+func TestChurnCandidates(t *testing.T) {
+	pull := &pubsub.SubscriptionConfig{}
+	push := &pubsub.SubscriptionConfig{PushConfig: pubsub.PushConfig{Endpoint: "http://worker:8080/push"}}
+	detached := &pubsub.SubscriptionConfig{Detached: true}
+	subscriptions := []*pubsub.SubscriptionConfig{pull, push, detached}
+
+	if got := churnCandidates(subscriptions, "purge"); !reflect.DeepEqual(got, []*pubsub.SubscriptionConfig{pull, push}) {
+		t.Errorf("churnCandidates(purge) = %v, want the pull and push subscriptions", got)
+	}
+	if got := churnCandidates(subscriptions, "flip-push"); !reflect.DeepEqual(got, []*pubsub.SubscriptionConfig{push}) {
+		t.Errorf("churnCandidates(flip-push) = %v, want the push subscription", got)
+	}
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// processConfigDir queues a config for every file in the -config-dir
+// directory, or the one named by PUBSUBC_CONFIG_DIR, such as a mounted
+// Kubernetes ConfigMap. Each file holds a config string, like a
+// PUBSUB_PROJECT variable. Files are read in lexical order, skipping hidden
+// files, which include the bookkeeping entries of a ConfigMap volume, and
+// subdirectories.
+func processConfigDir() {
+	dir := *configDir
+	if dir == "" {
+		dir = os.Getenv("PUBSUBC_CONFIG_DIR")
+	}
+	if dir == "" {
+		return
+	}
+	debugf("Reading configs from directory %s", dir)
+
+	// os.ReadDir returns the entries sorted by name.
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		fatalf("Unable to read config directory: %s", err)
+	}
+	for _, entry := range entries {
+		if strings.HasPrefix(entry.Name(), ".") {
+			continue
+		}
+		path := filepath.Join(dir, entry.Name())
+		// ConfigMap keys are symlinks, so the entry's own type isn't enough.
+		info, err := os.Stat(path)
+		if err != nil {
+			warnf("%s: %s, skipping the config", path, err)
+			configCount++
+			skippedConfigs++
+			continue
+		}
+		if info.IsDir() {
+			continue
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			warnf("%s: %s, skipping the config", path, err)
+			configCount++
+			skippedConfigs++
+			continue
+		}
+		processConfigString(strings.TrimSpace(string(data)), path, "")
+	}
+}
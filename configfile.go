@@ -0,0 +1,662 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/pubsub"
+	"github.com/thinkfluent/pubsubc/pkg/pubsubc"
+	"gopkg.in/yaml.v3"
+)
+
+// fileConfig is a YAML or JSON config file. Every project is one config:
+//
+//	projects:
+//	  - id: my-project
+//	    autoSub: true
+//	    topics:
+//	      - id: orders
+//	        labels:
+//	          team: payments
+//	        messageRetention: 24h
+//	        schema:
+//	          id: order
+//	          type: avro
+//	          definitionFile: schemas/order.avsc
+//	          encoding: json
+//	        subscriptions:
+//	          - orders-worker
+//	          - id: orders-push
+//	            pushEndpoint: http://worker:8080/push
+//	            pushServiceAccount: push@my-project.iam.gserviceaccount.com
+//	            pushAudience: orders-push
+//	            ackDeadlineSeconds: 60
+//	            messageRetention: 24h
+//	            retainAckedMessages: true
+//	            deadLetterTopic: orders-dead
+//	            maxDeliveryAttempts: 10
+//	            enableMessageOrdering: true
+//	            filter: attributes.type = "order"
+//	            minimumBackoff: 10s
+//	            maximumBackoff: 300s
+//	            labels:
+//	              env: test
+//	          - id: orders-bigquery
+//	            bigQueryTable: my-project.analytics.orders
+//	            bigQueryUseTopicSchema: true
+//	            bigQueryWriteMetadata: true
+//	        seed:
+//	          - '{"id": "fixture-1"}'
+//	          - base64: eyJpZCI6ICJmaXh0dXJlLTIifQ==
+//	            attributes:
+//	              type: order
+//	    hooks:
+//	      - ./seed.sh
+//	      - command: ./load-fixtures.sh
+//	        timeout: 5m
+//
+// overlays, selected with -overlay, are described with fileOverlay, and
+// templates, expanded before the file is decoded, with expandTemplates.
+type fileConfig struct {
+	Projects []fileProject          `yaml:"projects"`
+	Overlays map[string]fileOverlay `yaml:"overlays,omitempty"`
+}
+
+type fileProject struct {
+	ID                string      `yaml:"id,omitempty"`
+	Host              string      `yaml:"host,omitempty"`
+	SubscriptionsOnly bool        `yaml:"subscriptionsOnly,omitempty"`
+	AutoSub           *bool       `yaml:"autoSub,omitempty"`
+	Topics            []fileTopic `yaml:"topics,omitempty"`
+	Hooks             []fileHook  `yaml:"hooks,omitempty"`
+	line              int
+}
+
+type fileTopic struct {
+	ID               string              `yaml:"id,omitempty"`
+	Subscriptions    []fileSubscription  `yaml:"subscriptions,omitempty"`
+	Labels           map[string]string   `yaml:"labels,omitempty"`
+	Seed             []fileSeed          `yaml:"seed,omitempty"`
+	MessageRetention string              `yaml:"messageRetention,omitempty"`
+	Schema           *fileSchema         `yaml:"schema,omitempty"`
+	IAM              map[string][]string `yaml:"iam,omitempty"`
+	retention        time.Duration
+	line             int
+}
+
+// fileSchema is the schema a topic in a config file validates messages
+// against. definitionFile is relative to the config file.
+type fileSchema struct {
+	ID             string `yaml:"id,omitempty"`
+	Type           string `yaml:"type,omitempty"`
+	DefinitionFile string `yaml:"definitionFile,omitempty"`
+	Encoding       string `yaml:"encoding,omitempty"`
+	schemaType     pubsub.SchemaType
+	line           int
+}
+
+// fileSubscription is a subscription in a config file, given as a mapping or
+// just its ID.
+type fileSubscription struct {
+	ID                     string              `yaml:"id,omitempty"`
+	PushEndpoint           string              `yaml:"pushEndpoint,omitempty"`
+	PushServiceAccount     string              `yaml:"pushServiceAccount,omitempty"`
+	PushAudience           string              `yaml:"pushAudience,omitempty"`
+	BigQueryTable          string              `yaml:"bigQueryTable,omitempty"`
+	BigQueryUseTopicSchema bool                `yaml:"bigQueryUseTopicSchema,omitempty"`
+	BigQueryWriteMetadata  bool                `yaml:"bigQueryWriteMetadata,omitempty"`
+	AckDeadlineSeconds     int                 `yaml:"ackDeadlineSeconds,omitempty"`
+	MessageRetention       string              `yaml:"messageRetention,omitempty"`
+	RetainAckedMessages    bool                `yaml:"retainAckedMessages,omitempty"`
+	DeadLetterTopic        string              `yaml:"deadLetterTopic,omitempty"`
+	MaxDeliveryAttempts    int                 `yaml:"maxDeliveryAttempts,omitempty"`
+	EnableMessageOrdering  bool                `yaml:"enableMessageOrdering,omitempty"`
+	Filter                 string              `yaml:"filter,omitempty"`
+	MinimumBackoff         string              `yaml:"minimumBackoff,omitempty"`
+	MaximumBackoff         string              `yaml:"maximumBackoff,omitempty"`
+	Labels                 map[string]string   `yaml:"labels,omitempty"`
+	IAM                    map[string][]string `yaml:"iam,omitempty"`
+	minBackoff             time.Duration
+	maxBackoff             time.Duration
+	retention              time.Duration
+	line                   int
+	// auto is set on the subscriptions autoSub creates, when printing them.
+	auto bool
+}
+
+// fileHook is a command to run after a project applies, given as a mapping
+// or just its command.
+type fileHook struct {
+	Command string `yaml:"command,omitempty"`
+	Timeout string `yaml:"timeout,omitempty"`
+	timeout time.Duration
+}
+
+// fileSeed is a message to publish to a topic once its subscriptions exist,
+// given as a mapping or just its data.
+type fileSeed struct {
+	Data       string            `yaml:"data,omitempty"`
+	Base64     string            `yaml:"base64,omitempty"`
+	Attributes map[string]string `yaml:"attributes,omitempty"`
+	line       int
+}
+
+func (c *fileConfig) UnmarshalYAML(node *yaml.Node) error {
+	type plain fileConfig
+	return decodeMapping(node, "config file", (*plain)(c), "projects", "overlays", "templates")
+}
+
+func (p *fileProject) UnmarshalYAML(node *yaml.Node) error {
+	type plain fileProject
+	if err := decodeMapping(node, "project", (*plain)(p), "id", "host", "subscriptionsOnly", "autoSub", "topics", "hooks"); err != nil {
+		return err
+	}
+	p.line = node.Line
+	return nil
+}
+
+func (t *fileTopic) UnmarshalYAML(node *yaml.Node) error {
+	type plain fileTopic
+	if err := decodeMapping(node, "topic", (*plain)(t), "id", "subscriptions", "labels", "seed", "messageRetention", "schema", "iam"); err != nil {
+		return err
+	}
+	if t.ID == "" {
+		return fmt.Errorf("line %d: topic has no id", node.Line)
+	}
+	if err := pubsubc.CheckLabels(t.Labels); err != nil {
+		return fmt.Errorf("line %d: topic %q: %s", node.Line, t.ID, err)
+	}
+	if err := checkIAM(t.IAM); err != nil {
+		return fmt.Errorf("line %d: topic %q: %s", node.Line, t.ID, err)
+	}
+	if t.MessageRetention != "" {
+		var err error
+		if t.retention, err = time.ParseDuration(t.MessageRetention); err != nil {
+			return fmt.Errorf("line %d: topic %q: invalid messageRetention %q: %s", node.Line, t.ID, t.MessageRetention, err)
+		}
+		if err := pubsubc.CheckTopicRetention(t.retention); err != nil {
+			return fmt.Errorf("line %d: topic %q: %s", node.Line, t.ID, err)
+		}
+	}
+	t.line = node.Line
+	return nil
+}
+
+func (s *fileSchema) UnmarshalYAML(node *yaml.Node) error {
+	type plain fileSchema
+	if err := decodeMapping(node, "schema", (*plain)(s), "id", "type", "definitionFile", "encoding"); err != nil {
+		return err
+	}
+	s.line = node.Line
+	if s.ID == "" {
+		return fmt.Errorf("line %d: schema has no id", node.Line)
+	}
+	var err error
+	if s.schemaType, err = parseSchemaType(s.Type); err != nil {
+		return fmt.Errorf("line %d: schema %q: %s", node.Line, s.ID, err)
+	}
+	if s.DefinitionFile == "" {
+		return fmt.Errorf("line %d: schema %q has no definitionFile", node.Line, s.ID)
+	}
+	if s.Encoding != "" && s.Encoding != "json" && s.Encoding != "binary" {
+		return fmt.Errorf("line %d: schema %q: unknown encoding %q, expected json or binary", node.Line, s.ID, s.Encoding)
+	}
+	return nil
+}
+
+func (s *fileSubscription) UnmarshalYAML(node *yaml.Node) error {
+	s.line = node.Line
+	if node.Kind == yaml.ScalarNode {
+		s.ID = node.Value
+		return nil
+	}
+	type plain fileSubscription
+	if err := decodeMapping(node, "subscription", (*plain)(s), "id", "pushEndpoint", "pushServiceAccount", "pushAudience", "bigQueryTable", "bigQueryUseTopicSchema", "bigQueryWriteMetadata", "ackDeadlineSeconds", "messageRetention", "retainAckedMessages", "deadLetterTopic", "maxDeliveryAttempts", "enableMessageOrdering", "filter", "minimumBackoff", "maximumBackoff", "labels", "iam"); err != nil {
+		return err
+	}
+	s.line = node.Line
+	if s.ID == "" {
+		return fmt.Errorf("line %d: subscription has no id", node.Line)
+	}
+	if err := checkIAM(s.IAM); err != nil {
+		return fmt.Errorf("line %d: subscription %q: %s", node.Line, s.ID, err)
+	}
+	if s.PushServiceAccount != "" && s.PushEndpoint == "" {
+		return fmt.Errorf("line %d: subscription %q: pushServiceAccount needs a pushEndpoint", node.Line, s.ID)
+	}
+	if s.PushAudience != "" && s.PushServiceAccount == "" {
+		return fmt.Errorf("line %d: subscription %q: pushAudience needs a pushServiceAccount", node.Line, s.ID)
+	}
+	if s.BigQueryTable != "" && s.PushEndpoint != "" {
+		return fmt.Errorf("line %d: subscription %q: a subscription can't have both a pushEndpoint and a bigQueryTable", node.Line, s.ID)
+	}
+	if (s.BigQueryUseTopicSchema || s.BigQueryWriteMetadata) && s.BigQueryTable == "" {
+		return fmt.Errorf("line %d: subscription %q: bigQueryUseTopicSchema and bigQueryWriteMetadata need a bigQueryTable", node.Line, s.ID)
+	}
+	// Pub/Sub only accepts ack deadlines from 10 seconds to 10 minutes.
+	if s.AckDeadlineSeconds != 0 && (s.AckDeadlineSeconds < 10 || s.AckDeadlineSeconds > 600) {
+		return fmt.Errorf("line %d: subscription %q: ackDeadlineSeconds must be between 10 and 600, not %d", node.Line, s.ID, s.AckDeadlineSeconds)
+	}
+	if s.MessageRetention != "" {
+		var err error
+		if s.retention, err = time.ParseDuration(s.MessageRetention); err != nil {
+			return fmt.Errorf("line %d: subscription %q: invalid messageRetention %q: %s", node.Line, s.ID, s.MessageRetention, err)
+		}
+		// Pub/Sub only keeps a subscription's messages for 10 minutes to 7 days.
+		if s.retention < 10*time.Minute || s.retention > 7*24*time.Hour {
+			return fmt.Errorf("line %d: subscription %q: messageRetention must be between 10m and 168h, not %s", node.Line, s.ID, s.retention)
+		}
+	}
+	if s.MaxDeliveryAttempts != 0 && s.DeadLetterTopic == "" {
+		return fmt.Errorf("line %d: subscription %q: maxDeliveryAttempts needs a deadLetterTopic", node.Line, s.ID)
+	}
+	if s.MaxDeliveryAttempts != 0 && (s.MaxDeliveryAttempts < 5 || s.MaxDeliveryAttempts > 100) {
+		return fmt.Errorf("line %d: subscription %q: maxDeliveryAttempts must be between 5 and 100, not %d", node.Line, s.ID, s.MaxDeliveryAttempts)
+	}
+	var err error
+	if s.MinimumBackoff != "" {
+		if s.minBackoff, err = time.ParseDuration(s.MinimumBackoff); err != nil {
+			return fmt.Errorf("line %d: subscription %q: invalid minimumBackoff %q: %s", node.Line, s.ID, s.MinimumBackoff, err)
+		}
+	}
+	if s.MaximumBackoff != "" {
+		if s.maxBackoff, err = time.ParseDuration(s.MaximumBackoff); err != nil {
+			return fmt.Errorf("line %d: subscription %q: invalid maximumBackoff %q: %s", node.Line, s.ID, s.MaximumBackoff, err)
+		}
+	}
+	if err := pubsubc.CheckRetryPolicy(s.minBackoff, s.maxBackoff); err != nil {
+		return fmt.Errorf("line %d: subscription %q: %s", node.Line, s.ID, err)
+	}
+	if err := pubsubc.CheckLabels(s.Labels); err != nil {
+		return fmt.Errorf("line %d: subscription %q: %s", node.Line, s.ID, err)
+	}
+	return nil
+}
+
+func (h *fileHook) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind == yaml.ScalarNode {
+		h.Command = node.Value
+	} else {
+		type plain fileHook
+		if err := decodeMapping(node, "hook", (*plain)(h), "command", "timeout"); err != nil {
+			return err
+		}
+	}
+	if strings.TrimSpace(h.Command) == "" {
+		return fmt.Errorf("line %d: hook has no command", node.Line)
+	}
+	if h.Timeout != "" {
+		var err error
+		if h.timeout, err = time.ParseDuration(h.Timeout); err != nil {
+			return fmt.Errorf("line %d: hook %q: invalid timeout %q: %s", node.Line, h.Command, h.Timeout, err)
+		}
+		if h.timeout <= 0 {
+			return fmt.Errorf("line %d: hook %q: timeout must be positive", node.Line, h.Command)
+		}
+	}
+	return nil
+}
+
+func (s *fileSeed) UnmarshalYAML(node *yaml.Node) error {
+	s.line = node.Line
+	if node.Kind == yaml.ScalarNode {
+		s.Data = node.Value
+		return nil
+	}
+	type plain fileSeed
+	if err := decodeMapping(node, "seed message", (*plain)(s), "data", "base64", "attributes"); err != nil {
+		return err
+	}
+	s.line = node.Line
+	if s.Data != "" && s.Base64 != "" {
+		return fmt.Errorf("line %d: seed message has both data and base64", node.Line)
+	}
+	if s.Base64 != "" {
+		if _, err := base64.StdEncoding.DecodeString(s.Base64); err != nil {
+			return fmt.Errorf("line %d: seed message base64 is invalid: %s", node.Line, err)
+		}
+	}
+	if s.Data == "" && s.Base64 == "" && len(s.Attributes) == 0 {
+		return fmt.Errorf("line %d: seed message needs data, base64 or attributes", node.Line)
+	}
+	return nil
+}
+
+// decodeMapping decodes a mapping node into out, rejecting any key not in
+// known so that typos aren't silently ignored.
+func decodeMapping(node *yaml.Node, what string, out interface{}, known ...string) error {
+	if node.Kind != yaml.MappingNode {
+		return fmt.Errorf("line %d: expected a %s mapping", node.Line, what)
+	}
+	for i := 0; i < len(node.Content); i += 2 {
+		key := node.Content[i]
+		found := false
+		for _, name := range known {
+			if key.Value == name {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return fmt.Errorf("line %d: unknown %s field %q, expected one of %s", key.Line, what, key.Value, strings.Join(known, ", "))
+		}
+	}
+	return node.Decode(out)
+}
+
+// configLinePattern finds the line number in a YAML error.
+var configLinePattern = regexp.MustCompile(`line (\d+)`)
+
+// readConfigFile parses a YAML or JSON config file. Errors quote the line of
+// the file they refer to.
+func readConfigFile(path string) (fileConfig, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fileConfig{}, err
+	}
+	return parseConfigFile(data, path)
+}
+
+// parseConfigFile parses the data of a YAML or JSON config file, naming it
+// path in errors.
+func parseConfigFile(data []byte, path string) (fileConfig, error) {
+	var config fileConfig
+	var doc yaml.Node
+	err := yaml.NewDecoder(bytes.NewReader(data)).Decode(&doc)
+	if errors.Is(err, io.EOF) {
+		return config, fmt.Errorf("%s is empty", path)
+	}
+	if err == nil {
+		err = expandTemplates(&doc)
+	}
+	if err == nil {
+		err = doc.Decode(&config)
+	}
+	if err != nil {
+		message := strings.TrimPrefix(err.Error(), "yaml: ")
+		if match := configLinePattern.FindStringSubmatch(message); match != nil {
+			n, _ := strconv.Atoi(match[1])
+			if lines := strings.Split(string(data), "\n"); n >= 1 && n <= len(lines) {
+				message += fmt.Sprintf("\n  %d | %s", n, lines[n-1])
+			}
+		}
+		return config, fmt.Errorf("%s: %s", path, message)
+	}
+	if len(config.Projects) == 0 {
+		return config, fmt.Errorf("%s: no projects defined", path)
+	}
+	return config, nil
+}
+
+// processConfigFile queues a config for every project in the -config file,
+// or the file named by PUBSUBC_CONFIG_FILE.
+func processConfigFile() {
+	path := *configFile
+	if path == "" {
+		path = os.Getenv("PUBSUBC_CONFIG_FILE")
+	}
+	if path == "" {
+		if *overlayName != "" {
+			fatalf("-overlay needs a config file, given with -config or PUBSUBC_CONFIG_FILE")
+		}
+		return
+	}
+	debugf("Reading configs from %s", path)
+
+	config, err := readConfigFile(path)
+	if err != nil {
+		fatalf("Invalid config file %s", err)
+	}
+	absPath, err := filepath.Abs(path)
+	if err != nil {
+		fatalf("Invalid config file %s: %s", path, err)
+	}
+	if *overlayName != "" {
+		if err := config.applyOverlay(*overlayName); err != nil {
+			fatalf("Invalid config file %s: %s", path, err)
+		}
+	}
+
+	for _, project := range config.Projects {
+		configCount++
+		parsed, err := fileProjectConfig(path, filepath.Dir(path), project)
+		if errors.Is(err, errNoTopics) {
+			source := fmt.Sprintf("%s:%d", path, project.line)
+			logContext{"source", source}.warnf("%s: Expected at least 1 topic to be defined", source)
+			skippedConfigs++
+			continue
+		}
+		if err != nil {
+			fatalf("Invalid config file %s", err)
+		}
+		parsed.configFile = absPath
+		queueConfig(parsed)
+	}
+}
+
+// jsonLabelName is the name of the label holding a JSON document in the
+// config file schema, such as pubsubc.json.
+const jsonLabelName = "json"
+
+// processJSONLabel queues a config for every project of a JSON document in
+// the config file schema held by a label, returning how many it queued.
+// Projects without a host of their own are applied to host.
+func processJSONLabel(value, sourceHint, host string) int {
+	log := logContext{"source", sourceHint}
+	var syntax any
+	if err := json.Unmarshal([]byte(value), &syntax); err != nil {
+		configCount++
+		skippedConfigs++
+		var syntaxErr *json.SyntaxError
+		if errors.As(err, &syntaxErr) {
+			line, column := textPosition(value, syntaxErr.Offset)
+			log.warnf("%s: Invalid JSON at line %d, column %d: %s, skipping the config", sourceHint, line, column, err)
+		} else {
+			log.warnf("%s: Invalid JSON: %s, skipping the config", sourceHint, err)
+		}
+		return 0
+	}
+
+	config, err := parseConfigFile([]byte(value), sourceHint)
+	if err == nil && len(config.Overlays) > 0 {
+		err = fmt.Errorf("%s: labels can't define overlays", sourceHint)
+	}
+	if err != nil {
+		configCount++
+		skippedConfigs++
+		log.warnf("Skipping invalid config label %s", err)
+		return 0
+	}
+
+	queued := 0
+	for _, project := range config.Projects {
+		configCount++
+		if project.Host == "" {
+			project.Host = host
+		}
+		parsed, err := labelProjectConfig(sourceHint, project)
+		if errors.Is(err, errNoTopics) {
+			source := fmt.Sprintf("%s:%d", sourceHint, project.line)
+			logContext{"source", source}.warnf("%s: Expected at least 1 topic to be defined", source)
+			skippedConfigs++
+			continue
+		}
+		if err != nil {
+			log.warnf("Skipping invalid config label %s", err)
+			skippedConfigs++
+			continue
+		}
+		queueConfig(parsed)
+		queued++
+	}
+	return queued
+}
+
+// labelProjectConfig turns a project of a JSON label into a config. The
+// definitionFile of a schema would be read from pubsubc's filesystem rather
+// than the container's, so schemas are refused.
+func labelProjectConfig(sourceHint string, project fileProject) (*projectConfig, error) {
+	for _, topic := range project.Topics {
+		if topic.Schema != nil {
+			return nil, fmt.Errorf("%s:%d: labels can't set a schema, as its definitionFile would be read from pubsubc's filesystem", sourceHint, topic.Schema.line)
+		}
+	}
+	return fileProjectConfig(sourceHint, ".", project)
+}
+
+// textPosition returns the line and column, counting from 1, of a byte
+// offset into text.
+func textPosition(text string, offset int64) (int, int) {
+	if offset > int64(len(text)) {
+		offset = int64(len(text))
+	}
+	before := text[:offset]
+	line := strings.Count(before, "\n") + 1
+	column := len(before) - strings.LastIndex(before, "\n")
+	return line, column
+}
+
+// errNoTopics is returned for a project of a config file that declares no
+// topics.
+var errNoTopics = errors.New("Expected at least 1 topic to be defined")
+
+// fileProjectConfig turns a project of the config file at path into a config.
+// Schema definition files are relative to dir.
+func fileProjectConfig(path, dir string, project fileProject) (*projectConfig, error) {
+	var topics Topics
+	var seeds []seedMessage
+	var schemas []schemaDefinition
+	var policies []iamPolicy
+	for _, topic := range project.Topics {
+		if len(topic.IAM) > 0 {
+			policies = append(policies, iamPolicy{kind: "topic", id: topic.ID, bindings: topic.IAM, sourceHint: fmt.Sprintf("%s:%d", path, topic.line)})
+		}
+		var subscriptions []subscriptionSpec
+		for _, subscription := range topic.Subscriptions {
+			if len(subscription.IAM) > 0 {
+				policies = append(policies, iamPolicy{kind: "subscription", id: subscription.ID, bindings: subscription.IAM, sourceHint: fmt.Sprintf("%s:%d", path, subscription.line)})
+			}
+			spec := subscriptionSpec{
+				ID:                     subscription.ID,
+				PushServiceAccount:     subscription.PushServiceAccount,
+				PushAudience:           subscription.PushAudience,
+				BigQueryTable:          subscription.BigQueryTable,
+				BigQueryUseTopicSchema: subscription.BigQueryUseTopicSchema,
+				BigQueryWriteMetadata:  subscription.BigQueryWriteMetadata,
+				AckDeadline:            time.Duration(subscription.AckDeadlineSeconds) * time.Second,
+				Retention:              subscription.retention,
+				RetainAcked:            subscription.RetainAckedMessages,
+				DeadLetterTopic:        subscription.DeadLetterTopic,
+				MaxDeliveryAttempts:    subscription.MaxDeliveryAttempts,
+				Ordered:                subscription.EnableMessageOrdering,
+				Filter:                 subscription.Filter,
+				MinBackoff:             subscription.minBackoff,
+				MaxBackoff:             subscription.maxBackoff,
+				Labels:                 subscription.Labels,
+			}
+			if endpoint := subscription.PushEndpoint; endpoint != "" {
+				if !strings.HasPrefix(endpoint, "http") {
+					endpoint = "http://" + endpoint
+				}
+				spec.PushEndpoint = endpoint
+			}
+			subscriptions = append(subscriptions, spec)
+		}
+		topics.Add(topic.ID, subscriptions...)
+		if len(topic.Labels) > 0 {
+			topics.SetLabels(topic.ID, topic.Labels)
+		}
+		if topic.retention > 0 {
+			topics.SetRetention(topic.ID, topic.retention)
+		}
+		if schema := topic.Schema; schema != nil {
+			definition, err := readSchema(path, dir, schema, schemas)
+			if err != nil {
+				return nil, fmt.Errorf("%s:%d: schema %q: %s", path, schema.line, schema.ID, err)
+			}
+			if definition != nil {
+				schemas = append(schemas, *definition)
+			}
+			topics.SetSchema(topic.ID, schema.ID, schema.Encoding)
+		}
+		for _, seed := range topic.Seed {
+			data := []byte(seed.Data)
+			if seed.Base64 != "" {
+				data, _ = base64.StdEncoding.DecodeString(seed.Base64)
+			}
+			seeds = append(seeds, seedMessage{
+				topicID:    topic.ID,
+				data:       data,
+				attributes: seed.Attributes,
+				sourceHint: fmt.Sprintf("%s:%d", path, seed.line),
+			})
+		}
+	}
+	topics.AddDeadLetterTopics()
+	if len(topics) == 0 {
+		return nil, errNoTopics
+	}
+	auto := *autoSub
+	if project.AutoSub != nil {
+		auto = *project.AutoSub
+	}
+	var hooks []postHook
+	for _, hook := range project.Hooks {
+		hooks = append(hooks, postHook{command: hook.Command, timeout: hook.timeout})
+	}
+	return &projectConfig{
+		projectID:         project.ID,
+		host:              project.Host,
+		topics:            topics,
+		sourceHint:        fmt.Sprintf("%s:%d", path, project.line),
+		subscriptionsOnly: project.SubscriptionsOnly,
+		autoSub:           auto,
+		seeds:             seeds,
+		schemas:           schemas,
+		hooks:             hooks,
+		iam:               policies,
+	}, nil
+}
+
+// readSchema reads the definition of a topic's schema in the config file at
+// path, relative to dir. It returns nil if the project already declares the
+// schema, and an error if it does so with another definition.
+func readSchema(path, dir string, schema *fileSchema, declared []schemaDefinition) (*schemaDefinition, error) {
+	definitionPath := schema.DefinitionFile
+	if !filepath.IsAbs(definitionPath) {
+		definitionPath = filepath.Join(dir, definitionPath)
+	}
+	data, err := os.ReadFile(definitionPath)
+	if err != nil {
+		return nil, err
+	}
+	for _, other := range declared {
+		if other.id != schema.ID {
+			continue
+		}
+		if other.schemaType != schema.schemaType || other.definition != string(data) {
+			return nil, fmt.Errorf("declared again with a different definition than at %s", other.sourceHint)
+		}
+		return nil, nil
+	}
+	if absolute, err := filepath.Abs(definitionPath); err == nil {
+		definitionPath = absolute
+	}
+	return &schemaDefinition{
+		path:       definitionPath,
+		id:         schema.ID,
+		schemaType: schema.schemaType,
+		definition: string(data),
+		sourceHint: fmt.Sprintf("%s:%d", path, schema.line),
+	}, nil
+}
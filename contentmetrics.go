@@ -0,0 +1,212 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// projectKey identifies a project on an emulator host.
+type projectKey struct {
+	host, projectID string
+}
+
+// projectContent is what the last listing of a project found.
+type projectContent struct {
+	listed                                    bool
+	listedAt                                  time.Time
+	stale                                     bool
+	topics, subscriptions                     int
+	missingTopics, missingSubscriptions       int
+	undeclaredTopics, undeclaredSubscriptions int
+}
+
+var (
+	contentMu sync.Mutex
+	// declaredConfigs are the configs applied to each project since pubsubc
+	// started.
+	declaredConfigs = make(map[projectKey]map[*projectConfig]bool)
+	contents        = make(map[projectKey]*projectContent)
+)
+
+// recordDeclared records the configs of targets as declared, for the
+// content metrics.
+func recordDeclared(hosts []string, byHost map[string][]*applyTarget) {
+	contentMu.Lock()
+	defer contentMu.Unlock()
+	for _, host := range hosts {
+		for _, target := range byHost[host] {
+			key := projectKey{host, target.config.projectID}
+			if declaredConfigs[key] == nil {
+				declaredConfigs[key] = make(map[*projectConfig]bool)
+			}
+			declaredConfigs[key][target.config] = true
+		}
+	}
+}
+
+// startContentMetrics lists the topics and subscriptions of every project
+// configs were applied to, every -content-metrics-interval, pushing them to
+// -pushgateway-url with how they differ from the configs, for as long as
+// pubsubc runs.
+func startContentMetrics() {
+	if *pushgatewayURL == "" || *contentMetricsEvery <= 0 {
+		return
+	}
+	go func() {
+		clients := make(planClients)
+		for {
+			listContents(clients)
+			pushMetrics()
+			time.Sleep(*contentMetricsEvery)
+		}
+	}()
+}
+
+// listContents lists every declared project. A project that can't be listed
+// keeps the figures of its last listing, and is marked stale.
+func listContents(clients planClients) {
+	contentMu.Lock()
+	declared := make(map[projectKey][]*projectConfig, len(declaredConfigs))
+	for key, configs := range declaredConfigs {
+		for config := range configs {
+			declared[key] = append(declared[key], config)
+		}
+	}
+	contentMu.Unlock()
+
+	for key, configs := range declared {
+		// A listing that hangs, as it does once an emulator is gone, is
+		// stale by the time the next one is due.
+		ctx, cancel := context.WithTimeout(context.Background(), *contentMetricsEvery)
+		content, err := listContent(ctx, clients, key, configs)
+		cancel()
+		contentMu.Lock()
+		if err != nil {
+			warnf("Unable to list project %q on %s for the content metrics: %s", key.projectID, emulatorName(key.host), err)
+			if contents[key] == nil {
+				contents[key] = &projectContent{}
+			}
+			contents[key].stale = true
+		} else {
+			contents[key] = &content
+		}
+		contentMu.Unlock()
+	}
+}
+
+// listContent lists a project and compares it to the configs declaring it.
+func listContent(ctx context.Context, clients planClients, key projectKey, configs []*projectConfig) (projectContent, error) {
+	client, err := clients.get(ctx, key.host, key.projectID)
+	if err != nil {
+		return projectContent{}, err
+	}
+	have, err := listTopology(ctx, client)
+	if err != nil {
+		return projectContent{}, err
+	}
+	want := declaredTopology(configs)
+	content := projectContent{listed: true, listedAt: time.Now(), topics: len(have.topics), subscriptions: len(have.subscriptions)}
+	for topicID := range want.topics {
+		if !have.topics[topicID] {
+			content.missingTopics++
+		}
+	}
+	for subscriptionID := range want.subscriptions {
+		if _, ok := have.subscriptions[subscriptionID]; !ok {
+			content.missingSubscriptions++
+		}
+	}
+	// The topics of subscriptions-only configs belong to another config, so
+	// aren't undeclared either.
+	owned := make(map[string]bool)
+	for _, config := range configs {
+		for _, topicID := range config.topics.IDs() {
+			owned[topicID] = true
+		}
+	}
+	for topicID := range have.topics {
+		if !owned[topicID] {
+			content.undeclaredTopics++
+		}
+	}
+	for subscriptionID := range have.subscriptions {
+		if _, ok := want.subscriptions[subscriptionID]; !ok {
+			content.undeclaredSubscriptions++
+		}
+	}
+	return content, nil
+}
+
+// declaredTopology returns the topics and subscriptions configs declare. The
+// topics of subscriptions-only configs belong to another, so aren't included.
+func declaredTopology(configs []*projectConfig) topologySnapshot {
+	want := topologySnapshot{topics: make(map[string]bool), subscriptions: make(map[string]subscriptionState)}
+	for _, config := range configs {
+		for _, entry := range config.topics {
+			if !config.subscriptionsOnly {
+				want.topics[entry.ID] = true
+			}
+			for _, subscription := range config.withAutoSub(entry) {
+				want.subscriptions[subscription.ID] = subscriptionState{topic: entry.ID, pushEndpoint: subscription.PushEndpoint}
+			}
+		}
+	}
+	return want
+}
+
+// writeContentMetrics writes the content metrics in the Prometheus text
+// format.
+func writeContentMetrics(body *bytes.Buffer) {
+	contentMu.Lock()
+	defer contentMu.Unlock()
+	if len(contents) == 0 {
+		return
+	}
+	keys := make([]projectKey, 0, len(contents))
+	for key := range contents {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].projectID != keys[j].projectID {
+			return keys[i].projectID < keys[j].projectID
+		}
+		return keys[i].host < keys[j].host
+	})
+	labels := func(key projectKey) string {
+		return fmt.Sprintf("emulator=%q,project=%q", emulatorName(key.host), key.projectID)
+	}
+	byType := func(name string, value func(*projectContent) (topics, subscriptions int)) {
+		fmt.Fprintf(body, "# TYPE %s gauge\n", name)
+		for _, key := range keys {
+			content := contents[key]
+			if !content.listed {
+				continue
+			}
+			topics, subscriptions := value(content)
+			fmt.Fprintf(body, "%s{%s,type=\"topic\"} %d\n", name, labels(key), topics)
+			fmt.Fprintf(body, "%s{%s,type=\"subscription\"} %d\n", name, labels(key), subscriptions)
+		}
+	}
+
+	byType("pubsubc_emulator_resources", func(c *projectContent) (int, int) { return c.topics, c.subscriptions })
+	byType("pubsubc_resources_missing", func(c *projectContent) (int, int) { return c.missingTopics, c.missingSubscriptions })
+	byType("pubsubc_resources_undeclared", func(c *projectContent) (int, int) { return c.undeclaredTopics, c.undeclaredSubscriptions })
+	fmt.Fprintln(body, "# TYPE pubsubc_content_listed_timestamp_seconds gauge")
+	for _, key := range keys {
+		if content := contents[key]; content.listed {
+			fmt.Fprintf(body, "pubsubc_content_listed_timestamp_seconds{%s} %d\n", labels(key), content.listedAt.Unix())
+		}
+	}
+	fmt.Fprintln(body, "# TYPE pubsubc_content_stale gauge")
+	for _, key := range keys {
+		stale := 0
+		if contents[key].stale {
+			stale = 1
+		}
+		fmt.Fprintf(body, "pubsubc_content_stale{%s} %d\n", labels(key), stale)
+	}
+}
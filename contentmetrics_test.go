@@ -0,0 +1,35 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestWriteContentMetrics(t *testing.T) {
+	saved := contents
+	t.Cleanup(func() { contents = saved })
+	t.Setenv("PUBSUB_EMULATOR_HOST", "localhost:8681")
+	contents = map[projectKey]*projectContent{
+		{"", "listed"}:     {listed: true, topics: 2, subscriptions: 3, missingSubscriptions: 1, undeclaredTopics: 1},
+		{"", "unlistable"}: {stale: true},
+	}
+
+	var body bytes.Buffer
+	writeContentMetrics(&body)
+	for _, want := range []string{
+		`pubsubc_emulator_resources{emulator="localhost:8681",project="listed",type="topic"} 2`,
+		`pubsubc_emulator_resources{emulator="localhost:8681",project="listed",type="subscription"} 3`,
+		`pubsubc_resources_missing{emulator="localhost:8681",project="listed",type="subscription"} 1`,
+		`pubsubc_resources_undeclared{emulator="localhost:8681",project="listed",type="topic"} 1`,
+		`pubsubc_content_stale{emulator="localhost:8681",project="listed"} 0`,
+		`pubsubc_content_stale{emulator="localhost:8681",project="unlistable"} 1`,
+	} {
+		if !strings.Contains(body.String(), want+"\n") {
+			t.Errorf("writeContentMetrics() doesn't write %s, got:\n%s", want, body.String())
+		}
+	}
+	if strings.Contains(body.String(), `project="unlistable",type=`) {
+		t.Errorf("writeContentMetrics() writes figures for a project never listed, got:\n%s", body.String())
+	}
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"unicode"
+
+	"github.com/thinkfluent/pubsubc/pkg/pubsubc"
+)
+
+// subscriptionDefaults are the settings a config gives each of its
+// subscriptions, from PUBSUB_PROJECT<n>_DEFAULTS.
+type subscriptionDefaults = pubsubc.SubscriptionDefaults
+
+// envDefaults reads the subscription defaults for the config in the
+// environment variable env, from env_DEFAULTS.
+func envDefaults(env string) (subscriptionDefaults, error) {
+	value := os.Getenv(env + "_DEFAULTS")
+	if value == "" {
+		return subscriptionDefaults{}, nil
+	}
+	defaults, err := pubsubc.ParseSubscriptionDefaults(value)
+	if err != nil {
+		return defaults, fmt.Errorf("%s_DEFAULTS: %s", env, err)
+	}
+	return defaults, nil
+}
+
+// applyEnvSettings gives the config in the environment variable env the
+// settings of its companion variables: the subscription defaults in
+// env_DEFAULTS, the filter of each subscription in env_FILTER_<id>, and the
+// labels of each topic in env_LABELS_<id> and of each subscription in
+// env_SUBSCRIPTION_LABELS_<id>.
+func applyEnvSettings(env string, config *projectConfig) error {
+	defaults, err := envDefaults(env)
+	if err != nil {
+		return err
+	}
+	defaults.ApplyTo(config.topics)
+	config.defaults = defaults
+
+	for i := range config.topics {
+		entry := &config.topics[i]
+		if value, ok := envCompanion(env, "_LABELS_", entry.ID); ok {
+			labels, err := pubsubc.ParseLabels(value)
+			if err != nil {
+				return fmt.Errorf("Labels of topic %q: %s", entry.ID, err)
+			}
+			debugf("Using labels %s for topic %q from %s", pubsubc.DescribeLabels(labels), entry.ID, env)
+			entry.Labels = labels
+		}
+		for j := range entry.Subscriptions {
+			subscription := &entry.Subscriptions[j]
+			if filter, ok := envCompanion(env, "_FILTER_", subscription.ID); ok {
+				debugf("Using filter %q for subscription %q from %s", filter, subscription.ID, env)
+				subscription.Filter = filter
+			}
+			if value, ok := envCompanion(env, "_SUBSCRIPTION_LABELS_", subscription.ID); ok {
+				labels, err := pubsubc.ParseLabels(value)
+				if err != nil {
+					return fmt.Errorf("Labels of subscription %q: %s", subscription.ID, err)
+				}
+				debugf("Using labels %s for subscription %q from %s", pubsubc.DescribeLabels(labels), subscription.ID, env)
+				subscription.Labels = labels
+			}
+		}
+	}
+	return nil
+}
+
+// envCompanion looks up the companion variable env<infix><id> of the config
+// in env, for a topic or subscription ID. As the ID may not be a valid
+// variable name, any character but a letter, digit or underscore may also be
+// given as an underscore.
+func envCompanion(env, infix, id string) (string, bool) {
+	if value, ok := os.LookupEnv(env + infix + id); ok {
+		return value, true
+	}
+	name := strings.Map(func(r rune) rune {
+		if r == '_' || unicode.IsLetter(r) || unicode.IsDigit(r) {
+			return r
+		}
+		return '_'
+	}, id)
+	return os.LookupEnv(env + infix + name)
+}
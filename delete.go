@@ -0,0 +1,188 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/pubsub"
+	"google.golang.org/api/iterator"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// stdinIsTerminal reports whether stdin is a terminal someone can answer a
+// confirmation on. /dev/null is a character device too, but not one. Tests
+// replace it.
+var stdinIsTerminal = func() bool {
+	info, err := os.Stdin.Stat()
+	if err != nil || info.Mode()&os.ModeCharDevice == 0 {
+		return false
+	}
+	null, err := os.Stat(os.DevNull)
+	return err != nil || !os.SameFile(info, null)
+}
+
+// Confirmations are read from confirmInput and asked on confirmOutput.
+var (
+	confirmInput  io.Reader = os.Stdin
+	confirmOutput io.Writer = os.Stderr
+)
+
+// confirmDeletion shows where command is about to delete resources from, and
+// which, then waits for yes to be typed. Without a terminal to ask on, the
+// deletion only goes ahead with -yes. It returns why not when it can't.
+func confirmDeletion(command string, yes bool, target string, resources []string) error {
+	if yes {
+		return nil
+	}
+	if !stdinIsTerminal() {
+		return fmt.Errorf("Refusing to run %s without a terminal to confirm on; use -yes to delete without asking", command)
+	}
+	fmt.Fprintf(confirmOutput, "\n  Target: %s\n\n%s will delete %d resources:\n", target, command, len(resources))
+	for _, resource := range resources {
+		fmt.Fprintf(confirmOutput, "  %s\n", resource)
+	}
+	fmt.Fprintf(confirmOutput, "Type yes to delete them from %s: ", target)
+	answer, _ := bufio.NewReader(confirmInput).ReadString('\n')
+	if strings.TrimSpace(answer) != "yes" {
+		return fmt.Errorf("Aborted, nothing was deleted")
+	}
+	return nil
+}
+
+// describeEmulators names the emulators at hosts, for a confirmation.
+func describeEmulators(hosts []string) string {
+	var names []string
+	for _, host := range hosts {
+		names = append(names, emulatorName(host))
+	}
+	if len(names) == 1 {
+		return "the emulator at " + names[0]
+	}
+	return "the emulators at " + strings.Join(names, ", ")
+}
+
+// requireEmulator refuses to continue unless calls will go to an emulator,
+// so destructive commands can't touch the real Pub/Sub service.
+func requireEmulator(command string) {
+	host := os.Getenv("PUBSUB_EMULATOR_HOST")
+	if host == "" {
+		fatalf("Refusing to run %s without PUBSUB_EMULATOR_HOST: it would act on the real Pub/Sub service", command)
+	}
+	if isProductionHost(host) {
+		fatalf("Refusing to run %s against %s: PUBSUB_EMULATOR_HOST must be an emulator", command, host)
+	}
+}
+
+// runDeleteResource deletes exactly one named topic or subscription.
+func runDeleteResource(args []string) {
+	flags := flag.NewFlagSet("delete-resource", flag.ExitOnError)
+	projectID := flags.String("project", "", "Project ID of the resource")
+	topicID := flags.String("topic", "", "Topic to delete")
+	subscriptionID := flags.String("subscription", "", "Subscription to delete")
+	cascade := flags.Bool("cascade", false, "Also delete the subscriptions of a deleted topic")
+	yes := flags.Bool("yes", false, "Don't ask for confirmation")
+	strict := flags.Bool("strict", false, "Exit non-zero if the resource doesn't exist")
+	flags.Parse(args)
+
+	if *projectID == "" || (*topicID == "") == (*subscriptionID == "") {
+		flags.Usage()
+		fatalf("-project and exactly one of -topic or -subscription are required")
+	}
+	if *cascade && *topicID == "" {
+		fatalf("-cascade only applies to -topic")
+	}
+	requireEmulator("delete-resource")
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	client, err := newClient(ctx, *projectID, "")
+	if err != nil {
+		fatalf("Unable to create client to project %q: %s", *projectID, err)
+	}
+
+	notFound := func(kind, id string) {
+		fmt.Printf("%s %q doesn't exist in project %q, nothing to delete\n", kind, id, *projectID)
+		if *strict {
+			os.Exit(1)
+		}
+	}
+
+	if *subscriptionID != "" {
+		resource := "projects/" + *projectID + "/subscriptions/" + *subscriptionID
+		if err := confirmDeletion("delete-resource", *yes, describeEmulators([]string{""}), []string{resource}); err != nil {
+			fatalf("%s", err)
+		}
+		err := client.Subscription(*subscriptionID).Delete(ctx)
+		if status.Code(err) == codes.NotFound {
+			notFound("Subscription", *subscriptionID)
+			return
+		}
+		if err != nil {
+			fatalf("Unable to delete subscription %q for project %q: %s", *subscriptionID, *projectID, err)
+		}
+		fmt.Printf("Deleted subscription %q\n", *subscriptionID)
+		audit(auditEvent{Action: "deleted", Kind: "subscription", Project: *projectID, Name: *subscriptionID, Source: "delete-resource"})
+		return
+	}
+
+	topic := client.Topic(*topicID)
+	var subscriptions []*pubsub.Subscription
+	it := topic.Subscriptions(ctx)
+	for {
+		subscription, err := it.Next()
+		if err == iterator.Done {
+			break
+		}
+		if status.Code(err) == codes.NotFound {
+			notFound("Topic", *topicID)
+			return
+		}
+		if err != nil {
+			fatalf("Unable to list subscriptions of topic %q for project %q: %s", *topicID, *projectID, err)
+		}
+		subscriptions = append(subscriptions, subscription)
+	}
+
+	var resources []string
+	if *cascade {
+		for _, subscription := range subscriptions {
+			resources = append(resources, subscription.String())
+		}
+	}
+	resources = append(resources, topic.String())
+	if err := confirmDeletion("delete-resource", *yes, describeEmulators([]string{""}), resources); err != nil {
+		fatalf("%s", err)
+	}
+
+	if *cascade {
+		for _, subscription := range subscriptions {
+			if err := subscription.Delete(ctx); err != nil && status.Code(err) != codes.NotFound {
+				fatalf("Unable to delete subscription %q for project %q: %s", subscription.ID(), *projectID, err)
+			}
+			fmt.Printf("Deleted subscription %q\n", subscription.ID())
+			audit(auditEvent{Action: "deleted", Kind: "subscription", Project: *projectID, Name: subscription.ID(), Source: "delete-resource"})
+		}
+	}
+
+	err = topic.Delete(ctx)
+	if status.Code(err) == codes.NotFound {
+		notFound("Topic", *topicID)
+		return
+	}
+	if err != nil {
+		fatalf("Unable to delete topic %q for project %q: %s", *topicID, *projectID, err)
+	}
+	fmt.Printf("Deleted topic %q\n", *topicID)
+	audit(auditEvent{Action: "deleted", Kind: "topic", Project: *projectID, Name: *topicID, Source: "delete-resource"})
+	if !*cascade && len(subscriptions) > 0 {
+		warnf("%d subscriptions of topic %q remain, detached from any topic; use -cascade to delete them too", len(subscriptions), *topicID)
+	}
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+// fakeTerminal makes confirmDeletion read answer from a terminal, or find no
+// terminal at all, returning what it writes.
+func fakeTerminal(t *testing.T, terminal bool, answer string) *bytes.Buffer {
+	t.Helper()
+	var output bytes.Buffer
+	isTerminal, input, out := stdinIsTerminal, confirmInput, confirmOutput
+	t.Cleanup(func() { stdinIsTerminal, confirmInput, confirmOutput = isTerminal, input, out })
+	stdinIsTerminal = func() bool { return terminal }
+	confirmInput = strings.NewReader(answer)
+	confirmOutput = &output
+	return &output
+}
+
+func TestConfirmDeletionWithYes(t *testing.T) {
+	for _, terminal := range []bool{true, false} {
+		output := fakeTerminal(t, terminal, "")
+		if err := confirmDeletion("-delete", true, "the emulator at localhost:8681", []string{"projects/p/topics/t"}); err != nil {
+			t.Errorf("confirmDeletion() with -yes, terminal %v = %v, want nil", terminal, err)
+		}
+		if output.Len() > 0 {
+			t.Errorf("confirmDeletion() with -yes asked %q, want nothing", output)
+		}
+	}
+}
+
+func TestConfirmDeletionWithoutTerminal(t *testing.T) {
+	output := fakeTerminal(t, false, "yes\n")
+	err := confirmDeletion("-delete", false, "the emulator at localhost:8681", []string{"projects/p/topics/t"})
+	if err == nil || !strings.Contains(err.Error(), "-yes") {
+		t.Errorf("confirmDeletion() without a terminal = %v, want an error asking for -yes", err)
+	}
+	if output.Len() > 0 {
+		t.Errorf("confirmDeletion() without a terminal asked %q, want nothing", output)
+	}
+}
+
+func TestConfirmDeletionOnTerminal(t *testing.T) {
+	resources := []string{"projects/p/subscriptions/s", "projects/p/topics/t"}
+	tests := []struct {
+		answer    string
+		confirmed bool
+	}{
+		{"yes\n", true},
+		{"  yes  \n", true},
+		{"yes", true},
+		{"y\n", false},
+		{"no\n", false},
+		{"\n", false},
+		{"", false},
+	}
+	for _, test := range tests {
+		output := fakeTerminal(t, true, test.answer)
+		err := confirmDeletion("-delete", false, "the emulator at localhost:8681", resources)
+		if confirmed := err == nil; confirmed != test.confirmed {
+			t.Errorf("confirmDeletion() answered %q = %v, want confirmed %v", test.answer, err, test.confirmed)
+		}
+		prompt := output.String()
+		if !strings.Contains(prompt, "Target: the emulator at localhost:8681\n") {
+			t.Errorf("confirmDeletion() asked %q, want it to show the target", prompt)
+		}
+		for _, resource := range resources {
+			if !strings.Contains(prompt, "  "+resource+"\n") {
+				t.Errorf("confirmDeletion() asked %q, want it to list %s", prompt, resource)
+			}
+		}
+	}
+}
+
+func TestTeardownResources(t *testing.T) {
+	config := &projectConfig{projectID: "p", autoSub: true, topics: Topics{
+		{ID: "orders", Subscriptions: []subscriptionSpec{{ID: "orders-worker"}}},
+		{ID: "events"},
+	}}
+	shared := &projectConfig{projectID: "p", subscriptionsOnly: true, topics: Topics{
+		{ID: "billing", Subscriptions: []subscriptionSpec{{ID: "billing-audit"}}},
+	}}
+
+	hosts := []string{"a:8681"}
+	byHost := map[string][]*applyTarget{"a:8681": {{config: config}, {config: shared}}}
+	want := []string{
+		"projects/p/subscriptions/orders-worker",
+		"projects/p/subscriptions/events-sub",
+		"projects/p/subscriptions/billing-audit",
+		"projects/p/topics/orders",
+		"projects/p/topics/events",
+	}
+	if got := teardownResources(hosts, byHost); strings.Join(got, " ") != strings.Join(want, " ") {
+		t.Errorf("teardownResources() = %q, want %q", got, want)
+	}
+
+	hosts = []string{"a:8681", "b:8681"}
+	byHost["b:8681"] = []*applyTarget{{config: config}}
+	got := teardownResources(hosts, byHost)
+	if len(got) != 9 || got[0] != "a:8681 projects/p/subscriptions/orders-worker" || got[8] != "b:8681 projects/p/topics/events" {
+		t.Errorf("teardownResources() on two hosts = %q, want each prefixed by its host", got)
+	}
+}
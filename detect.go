@@ -0,0 +1,126 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/client"
+)
+
+// emulatorPorts are the ports the well-known emulator images listen on.
+var emulatorPorts = map[uint16]bool{8085: true, 8681: true}
+
+// emulatorCandidate is a running container that looks like a Pub/Sub emulator.
+type emulatorCandidate struct {
+	container types.Container
+	reason    string
+	address   string
+}
+
+func (c emulatorCandidate) String() string {
+	return fmt.Sprintf("[%s] %s (%s) at %s", c.container.ID[:10], strings.Join(c.container.Names, ","), c.reason, c.address)
+}
+
+// detectEmulator looks for a running emulator container when no endpoint is
+// configured, and uses it if exactly one is found.
+func detectEmulator() {
+	if !*autoDetectEmulator || os.Getenv("PUBSUB_EMULATOR_HOST") != "" {
+		return
+	}
+
+	cli, err := client.NewClientWithOpts(client.FromEnv)
+	if err != nil {
+		warnf("Unable to create Docker client to detect the emulator: %s", err.Error())
+		return
+	}
+	containers, err := cli.ContainerList(context.Background(), types.ContainerListOptions{})
+	if err != nil {
+		warnf("Unable to list Docker containers to detect the emulator: %s", err.Error())
+		return
+	}
+
+	_, err = os.Stat("/.dockerenv")
+	insideContainer := err == nil
+	var candidates []emulatorCandidate
+	for _, container := range containers {
+		reason, port, ok := matchEmulator(container)
+		if !ok {
+			continue
+		}
+		address := emulatorAddress(container, port, insideContainer)
+		if address == "" {
+			debugf("Container [%s] looks like an emulator but has no reachable address", container.ID[:10])
+			continue
+		}
+		candidates = append(candidates, emulatorCandidate{container, reason, address})
+	}
+
+	switch len(candidates) {
+	case 0:
+		warnf("No running Pub/Sub emulator container found")
+	case 1:
+		fmt.Printf("Detected Pub/Sub emulator container %s; set PUBSUB_EMULATOR_HOST to override\n", candidates[0])
+		os.Setenv("PUBSUB_EMULATOR_HOST", candidates[0].address)
+	default:
+		var list strings.Builder
+		for _, candidate := range candidates {
+			list.WriteString("\n  " + candidate.String())
+		}
+		fatalf("Found %d possible Pub/Sub emulator containers, set PUBSUB_EMULATOR_HOST to choose one:%s", len(candidates), list.String())
+	}
+}
+
+// matchEmulator reports whether a container looks like a Pub/Sub emulator,
+// why, and the private port it listens on.
+func matchEmulator(container types.Container) (string, types.Port, bool) {
+	var port types.Port
+	for _, p := range container.Ports {
+		if emulatorPorts[p.PrivatePort] {
+			port = p
+			break
+		}
+	}
+
+	switch {
+	case strings.Contains(container.Image, "gcloud-pubsub-emulator"):
+		if port.PrivatePort == 0 && len(container.Ports) > 0 {
+			port = container.Ports[0]
+		}
+		return "image " + container.Image, port, port.PrivatePort != 0
+	case strings.Contains(container.Image, "cloud-sdk") && strings.Contains(container.Command, "pubsub"):
+		if port.PrivatePort == 0 {
+			port.PrivatePort = 8085
+		}
+		return "cloud-sdk running " + container.Command, port, true
+	case port.PrivatePort != 0 && strings.Contains(container.Command, "pubsub"):
+		return fmt.Sprintf("port %d", port.PrivatePort), port, true
+	}
+	return "", port, false
+}
+
+// emulatorAddress picks an address pubsubc can reach the container on: the
+// container's network address when running inside Docker, otherwise the port
+// published on the host.
+func emulatorAddress(container types.Container, port types.Port, insideContainer bool) string {
+	if !insideContainer && port.PublicPort != 0 {
+		ip := port.IP
+		if ip == "" || ip == "0.0.0.0" || ip == "::" {
+			ip = "localhost"
+		}
+		return net.JoinHostPort(ip, strconv.Itoa(int(port.PublicPort)))
+	}
+
+	if container.NetworkSettings != nil {
+		for _, network := range container.NetworkSettings.Networks {
+			if network.IPAddress != "" {
+				return net.JoinHostPort(network.IPAddress, strconv.Itoa(int(port.PrivatePort)))
+			}
+		}
+	}
+	return ""
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/thinkfluent/pubsubc/pkg/pubsubc"
+)
+
+// runDryRun prints the resources the discovered configs would create,
+// without connecting to Pub/Sub, and exits 1 if any config is invalid.
+func runDryRun() {
+	if configCount == 0 {
+		fatalf("No Pub/Sub configurations found")
+	}
+	hosts, byHost := pendingTargets()
+	checkLimits(hosts, byHost)
+
+	problems := skippedConfigs + skippedSeeds
+	for _, host := range hosts {
+		// Subscription IDs are unique within a project, whichever config
+		// declares them.
+		subscriptionTopics := make(map[string]string)
+		for _, target := range byHost[host] {
+			config := target.config
+			name := config.projectID
+			if host != "" {
+				name = host + " " + name
+			}
+			if config.subscriptionsOnly {
+				name += " (subscriptions only)"
+			}
+			fmt.Printf("%s from %s\n", name, config.sourceHint)
+
+			for _, schema := range config.schemas {
+				fmt.Printf("  schema %s (%s, from %s)\n", schema.id, describeSchemaType(schema.schemaType), schema.sourceHint)
+			}
+			for _, entry := range config.topics {
+				fmt.Printf("  topic %s%s\n", entry.ID, describeTopic(entry))
+				if entry.ID == "" {
+					warnf("%s: Empty topic name", config.sourceHint)
+					problems++
+				}
+				auto := ""
+				if len(entry.Subscriptions) == 0 && config.autoSub {
+					if *omitAutoSub {
+						continue
+					}
+					auto = " (auto-generated)"
+				}
+				for _, subscription := range config.withAutoSub(entry) {
+					fmt.Printf("    subscription %s%s%s\n", subscription.ID, describeSubscription(subscription), auto)
+					key := config.projectID + " " + subscription.ID
+					if topicID, ok := subscriptionTopics[key]; ok && topicID != entry.ID {
+						warnf("%s: Subscription %q is declared on both topic %q and topic %q", config.sourceHint, subscription.ID, topicID, entry.ID)
+						problems++
+					}
+					subscriptionTopics[key] = entry.ID
+					if subscription.PushEndpoint != "" {
+						if err := checkPushEndpoint(subscription.PushEndpoint); err != nil {
+							warnf("%s: Subscription %q: %s", config.sourceHint, subscription.ID, err)
+							problems++
+						}
+					}
+				}
+			}
+			if !*noSeed {
+				for _, seed := range config.seeds {
+					fmt.Printf("  seed %s from %s (%s)\n", seed.topicID, seed.sourceHint, describeSeed(seed))
+				}
+			}
+		}
+	}
+
+	fmt.Printf("Found %d Pub/Sub configurations\n", configCount)
+	if problems > 0 {
+		fmt.Fprintf(os.Stderr, "%s: Found %d problems in the configs\n", os.Args[0], problems)
+		os.Exit(1)
+	}
+	fmt.Println("Dry run, nothing was created")
+}
+
+// describeTopic lists the settings of a topic, for printing after its ID.
+func describeTopic(entry topicEntry) string {
+	var settings []string
+	if entry.Retention > 0 {
+		settings = append(settings, "retention "+entry.Retention.String())
+	}
+	if entry.Schema != "" {
+		settings = append(settings, fmt.Sprintf("schema %s, %s encoding", entry.Schema, entry.Encoding()))
+	}
+	if len(entry.Labels) > 0 {
+		settings = append(settings, "labels "+pubsubc.DescribeLabels(entry.Labels))
+	}
+	if len(settings) == 0 {
+		return ""
+	}
+	return " (" + strings.Join(settings, ", ") + ")"
+}
+
+// describeSubscription lists the settings of a subscription that differ from
+// a plain pull subscription, for printing after its ID.
+func describeSubscription(subscription subscriptionSpec) string {
+	var settings []string
+	if subscription.PushEndpoint != "" {
+		settings = append(settings, "push "+subscription.PushEndpoint)
+		if subscription.PushServiceAccount != "" {
+			settings = append(settings, "OIDC token for "+pubsubc.DescribePushAuth(subscription))
+		}
+	}
+	if subscription.BigQueryTable != "" {
+		settings = append(settings, "BigQuery table "+pubsubc.DescribeBigQuery(subscription))
+	}
+	if subscription.AckDeadline > 0 {
+		settings = append(settings, fmt.Sprintf("ack deadline %s", subscription.AckDeadline))
+	}
+	if subscription.Retention > 0 {
+		settings = append(settings, fmt.Sprintf("retention %s", subscription.Retention))
+	}
+	if subscription.RetainAcked {
+		settings = append(settings, "retains acked messages")
+	}
+	if subscription.Ordered {
+		settings = append(settings, "message ordering")
+	}
+	if subscription.MinBackoff > 0 || subscription.MaxBackoff > 0 {
+		settings = append(settings, "retry "+pubsubc.DescribeBackoff(subscription))
+	}
+	if subscription.Filter != "" {
+		settings = append(settings, fmt.Sprintf("filter %q", subscription.Filter))
+	}
+	if len(subscription.Labels) > 0 {
+		settings = append(settings, "labels "+pubsubc.DescribeLabels(subscription.Labels))
+	}
+	if subscription.DeadLetterTopic != "" {
+		deadLetter := "dead-letter topic " + subscription.DeadLetterTopic
+		if subscription.MaxDeliveryAttempts > 0 {
+			deadLetter += fmt.Sprintf(" after %d attempts", subscription.MaxDeliveryAttempts)
+		}
+		settings = append(settings, deadLetter)
+	}
+	if len(settings) == 0 {
+		return ""
+	}
+	return " (" + strings.Join(settings, ", ") + ")"
+}
+
+// checkPushEndpoint checks that a push endpoint is an absolute HTTP or HTTPS
+// URL.
+func checkPushEndpoint(endpoint string) error {
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return fmt.Errorf("Malformed push endpoint %q: %s", endpoint, err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("Push endpoint %q isn't an http or https URL", endpoint)
+	}
+	if u.Host == "" {
+		return fmt.Errorf("Push endpoint %q has no host", endpoint)
+	}
+	return nil
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// The Firebase CLI's defaults for the Pub/Sub emulator.
+const (
+	firebaseDefaultHost = "localhost"
+	firebaseDefaultPort = 8085
+)
+
+// firebaseProject is a demo project ID found alongside firebase.json, used as
+// the last resort default project.
+var firebaseProject string
+
+// firebaseConfig is the part of firebase.json describing the Pub/Sub emulator.
+type firebaseConfig struct {
+	Emulators struct {
+		PubSub *struct {
+			Host string `json:"host"`
+			Port int    `json:"port"`
+		} `json:"pubsub"`
+	} `json:"emulators"`
+}
+
+// configureFirebase reads the Pub/Sub emulator endpoint from firebase.json and
+// uses it unless PUBSUB_EMULATOR_HOST is already set. Without -firebase-config
+// a firebase.json in the working directory is used if there is one.
+func configureFirebase() {
+	path := *firebaseConfigPath
+	explicit := path != ""
+	if !explicit {
+		path = "firebase.json"
+		if _, err := os.Stat(path); err != nil {
+			return
+		}
+	}
+
+	host, err := readFirebaseConfig(path)
+	if err != nil {
+		if explicit {
+			fatalf("%s", err)
+		}
+		warnf("Ignoring %s: %s", path, err)
+		return
+	}
+
+	if projectID := readFirebaseDemoProject(filepath.Dir(path)); projectID != "" {
+		debugf("Using Firebase demo project %q as the default project", projectID)
+		firebaseProject = projectID
+	}
+
+	if host == "" {
+		debugf("No Pub/Sub emulator configured in %s", path)
+		return
+	}
+	if current := os.Getenv("PUBSUB_EMULATOR_HOST"); current != "" {
+		debugf("Using PUBSUB_EMULATOR_HOST=%s rather than %s from %s", current, host, path)
+		return
+	}
+	debugf("Using Pub/Sub emulator at %s from %s", host, path)
+	os.Setenv("PUBSUB_EMULATOR_HOST", host)
+}
+
+// readFirebaseConfig returns the Pub/Sub emulator's host:port, or "" if the
+// file doesn't configure one.
+func readFirebaseConfig(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("Unable to read Firebase config: %s", err)
+	}
+
+	var config firebaseConfig
+	if err := json.Unmarshal(data, &config); err != nil {
+		return "", fmt.Errorf("Unable to parse Firebase config %s: %s", path, err)
+	}
+	if config.Emulators.PubSub == nil {
+		return "", nil
+	}
+
+	host := config.Emulators.PubSub.Host
+	if host == "" || host == "0.0.0.0" || host == "::" {
+		host = firebaseDefaultHost
+	}
+	port := config.Emulators.PubSub.Port
+	if port == 0 {
+		port = firebaseDefaultPort
+	}
+	return net.JoinHostPort(host, strconv.Itoa(port)), nil
+}
+
+// readFirebaseDemoProject returns the default project from .firebaserc in dir
+// if it follows the demo- naming convention for emulator-only projects.
+func readFirebaseDemoProject(dir string) string {
+	data, err := os.ReadFile(filepath.Join(dir, ".firebaserc"))
+	if errors.Is(err, fs.ErrNotExist) {
+		return ""
+	}
+	if err != nil {
+		warnf("Unable to read .firebaserc: %s", err)
+		return ""
+	}
+
+	var rc struct {
+		Projects map[string]string `json:"projects"`
+	}
+	if err := json.Unmarshal(data, &rc); err != nil {
+		warnf("Unable to parse .firebaserc: %s", err)
+		return ""
+	}
+	if projectID := rc.Projects["default"]; strings.HasPrefix(projectID, "demo-") {
+		return projectID
+	}
+	return ""
+}
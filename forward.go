@@ -0,0 +1,310 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"cloud.google.com/go/pubsub"
+)
+
+// forwardRoutes maps a source topic to the topics its messages are
+// republished onto.
+type forwardRoutes map[string][]string
+
+// forwardCounters counts messages republished along a single route.
+type forwardCounters struct {
+	forwarded atomic.Int64
+	failed    atomic.Int64
+}
+
+// add records destinations for a source topic, ignoring duplicates.
+func (r forwardRoutes) add(from string, to []string) {
+	for _, destination := range to {
+		duplicate := false
+		for _, existing := range r[from] {
+			duplicate = duplicate || existing == destination
+		}
+		if !duplicate {
+			r[from] = append(r[from], destination)
+		}
+	}
+}
+
+// parseForwardRoute parses a "from=to1,to2" route definition.
+func parseForwardRoute(route string) (string, []string, error) {
+	from, to, ok := strings.Cut(route, "=")
+	if !ok || from == "" || to == "" {
+		return "", nil, fmt.Errorf("Expected route in the form from=to1,to2, got %q", route)
+	}
+	return from, strings.Split(to, ","), nil
+}
+
+// findForwardLoop returns the topics making up a forwarding cycle, or nil if
+// the routes are free of loops.
+func findForwardLoop(routes forwardRoutes) []string {
+	const (
+		unvisited = iota
+		visiting
+		visited
+	)
+	state := make(map[string]int)
+	var path []string
+
+	var visit func(topicID string) []string
+	visit = func(topicID string) []string {
+		switch state[topicID] {
+		case visiting:
+			for i, id := range path {
+				if id == topicID {
+					return append(append([]string{}, path[i:]...), topicID)
+				}
+			}
+		case visited:
+			return nil
+		}
+
+		state[topicID] = visiting
+		path = append(path, topicID)
+		for _, to := range routes[topicID] {
+			if loop := visit(to); loop != nil {
+				return loop
+			}
+		}
+		path = path[:len(path)-1]
+		state[topicID] = visited
+		return nil
+	}
+
+	sources := make([]string, 0, len(routes))
+	for from := range routes {
+		sources = append(sources, from)
+	}
+	sort.Strings(sources)
+	for _, from := range sources {
+		if loop := visit(from); loop != nil {
+			return loop
+		}
+	}
+	return nil
+}
+
+// runForward republishes every message published to a source topic onto one or
+// more destination topics, until interrupted.
+func runForward(args []string) {
+	var routeFlags stringList
+	flags := flag.NewFlagSet("forward", flag.ExitOnError)
+	projectID := flags.String("project", "", "Project ID of the topics")
+	from := flags.String("from", "", "Source topic")
+	to := flags.String("to", "", "Comma separated destination topics")
+	flags.Var(&routeFlags, "route", "Additional route as from=to1,to2 (may be repeated)")
+	statsInterval := flags.Duration("stats-interval", 30*time.Second, "How often to print forwarding statistics, 0 to only print on shutdown")
+	flags.Parse(args)
+
+	routes := make(forwardRoutes)
+	if *from != "" || *to != "" {
+		if *from == "" || *to == "" {
+			fatalf("-from and -to must be used together")
+		}
+		routes.add(*from, strings.Split(*to, ","))
+	}
+	for _, route := range routeFlags {
+		source, destinations, err := parseForwardRoute(route)
+		if err != nil {
+			fatalf("%s", err)
+		}
+		routes.add(source, destinations)
+	}
+	if *projectID == "" || len(routes) == 0 {
+		flags.Usage()
+		fatalf("-project and at least one route are required")
+	}
+	if loop := findForwardLoop(routes); loop != nil {
+		fatalf("Forwarding routes contain a loop: %s", strings.Join(loop, " -> "))
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	client, err := newClient(ctx, *projectID, "")
+	if err != nil {
+		fatalf("Unable to create client to project %q: %s", *projectID, err)
+	}
+
+	topics := make(map[string]*pubsub.Topic)
+	counters := make(map[string]*forwardCounters)
+	var routeNames []string
+	for source, destinations := range routes {
+		for _, destination := range destinations {
+			if _, ok := topics[destination]; !ok {
+				topic := client.Topic(destination)
+				topic.EnableMessageOrdering = true
+				topics[destination] = topic
+			}
+			name := source + " -> " + destination
+			counters[name] = &forwardCounters{}
+			routeNames = append(routeNames, name)
+		}
+	}
+	sort.Strings(routeNames)
+
+	printStats := func(suffix string) {
+		for _, name := range routeNames {
+			fmt.Printf("Forward %s%s: forwarded=%d failed=%d\n", name, suffix, counters[name].forwarded.Load(), counters[name].failed.Load())
+		}
+	}
+	if *statsInterval > 0 {
+		go func() {
+			ticker := time.NewTicker(*statsInterval)
+			defer ticker.Stop()
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case <-ticker.C:
+					printStats("")
+				}
+			}
+		}()
+	}
+
+	var wg sync.WaitGroup
+	for source, destinations := range routes {
+		subscription, err := forwardSubscription(ctx, client, source)
+		if err != nil {
+			fatalf("%s", err)
+		}
+
+		source, destinations := source, destinations
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			fmt.Printf("Forwarding topic %q in project %q to %s\n", source, *projectID, strings.Join(destinations, ", "))
+			progress := newForwardProgress()
+			err := subscription.Receive(ctx, func(ctx context.Context, msg *pubsub.Message) {
+				ok := progress.forward(msg.ID, destinations, func(destination string) error {
+					counter := counters[source+" -> "+destination]
+					if err := forwardMessage(ctx, topics[destination], msg); err != nil {
+						warnf("Unable to forward message %s from %q to %q: %s", msg.ID, source, destination, err)
+						counter.failed.Add(1)
+						return err
+					}
+					counter.forwarded.Add(1)
+					return nil
+				})
+				if ok {
+					msg.Ack()
+				} else {
+					msg.Nack()
+				}
+			})
+			if err != nil {
+				warnf("Stopped forwarding topic %q: %s", source, err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	for _, topic := range topics {
+		topic.Stop()
+	}
+	printStats(" (stopped)")
+}
+
+// forwardProgress remembers the destinations each message has reached, so
+// that a message redelivered after failing to reach some of them is only
+// published to the rest. A message is forgotten once it has reached them all.
+// Progress is only kept for as long as pubsubc runs.
+type forwardProgress struct {
+	mu      sync.Mutex
+	reached map[string]map[string]bool
+}
+
+func newForwardProgress() *forwardProgress {
+	return &forwardProgress{reached: make(map[string]map[string]bool)}
+}
+
+// forward publishes a message to every destination it hasn't reached yet,
+// reporting whether it has now reached them all.
+func (p *forwardProgress) forward(messageID string, destinations []string, publish func(destination string) error) bool {
+	p.mu.Lock()
+	reached := p.reached[messageID]
+	if reached == nil {
+		reached = make(map[string]bool)
+		p.reached[messageID] = reached
+	}
+	p.mu.Unlock()
+
+	ok := true
+	for _, destination := range destinations {
+		p.mu.Lock()
+		done := reached[destination]
+		p.mu.Unlock()
+		if done {
+			continue
+		}
+		if err := publish(destination); err != nil {
+			ok = false
+			continue
+		}
+		p.mu.Lock()
+		reached[destination] = true
+		p.mu.Unlock()
+	}
+	if ok {
+		p.mu.Lock()
+		delete(p.reached, messageID)
+		p.mu.Unlock()
+	}
+	return ok
+}
+
+// forwardSubscription returns the internal pull subscription used to read a
+// source topic, creating it if necessary.
+func forwardSubscription(ctx context.Context, client *pubsub.Client, topicID string) (*pubsub.Subscription, error) {
+	subscriptionID := "pubsubc-forward-" + topicID
+	subscription := client.Subscription(subscriptionID)
+	exists, err := subscription.Exists(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to check existence of subscription %q: %s", subscriptionID, err)
+	}
+	if exists {
+		return subscription, nil
+	}
+
+	debugf("Creating forwarding subscription %q on topic %q", subscriptionID, topicID)
+	subscription, err = client.CreateSubscription(ctx, subscriptionID, pubsub.SubscriptionConfig{
+		Topic:                 client.Topic(topicID),
+		EnableMessageOrdering: true,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("Unable to create forwarding subscription %q on topic %q: %s", subscriptionID, topicID, err)
+	}
+	audit(auditEvent{Action: "created", Kind: "subscription", Project: client.Project(), Name: subscriptionID, Source: "forward"})
+	return subscription, nil
+}
+
+// forwardMessage republishes a message, preserving its data, attributes and
+// ordering key, and waits for the publish to complete.
+func forwardMessage(ctx context.Context, topic *pubsub.Topic, msg *pubsub.Message) error {
+	result := topic.Publish(ctx, &pubsub.Message{
+		Data:        msg.Data,
+		Attributes:  msg.Attributes,
+		OrderingKey: msg.OrderingKey,
+	})
+	if _, err := result.Get(ctx); err != nil {
+		if msg.OrderingKey != "" {
+			topic.ResumePublish(msg.OrderingKey)
+		}
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestForwardProgressPartialFailure(t *testing.T) {
+	progress := newForwardProgress()
+	destinations := []string{"billing", "shipping", "audit"}
+	var published []string
+	failing := map[string]bool{"shipping": true}
+	publish := func(destination string) error {
+		if failing[destination] {
+			return errors.New("unavailable")
+		}
+		published = append(published, destination)
+		return nil
+	}
+
+	if progress.forward("1", destinations, publish) {
+		t.Fatal("forward() = true with a failing destination, want false")
+	}
+	if want := []string{"billing", "audit"}; !reflect.DeepEqual(published, want) {
+		t.Errorf("First delivery published to %q, want %q", published, want)
+	}
+
+	// The redelivered message only goes to the destination it missed.
+	published = nil
+	delete(failing, "shipping")
+	if !progress.forward("1", destinations, publish) {
+		t.Fatal("forward() = false once every destination succeeds, want true")
+	}
+	if want := []string{"shipping"}; !reflect.DeepEqual(published, want) {
+		t.Errorf("Redelivery published to %q, want %q", published, want)
+	}
+	if len(progress.reached) != 0 {
+		t.Errorf("Progress of %d messages kept after they reached every destination, want none", len(progress.reached))
+	}
+
+	// Another message starts from scratch.
+	published = nil
+	if !progress.forward("2", destinations, publish) {
+		t.Fatal("forward() = false, want true")
+	}
+	if !reflect.DeepEqual(published, destinations) {
+		t.Errorf("Second message published to %q, want %q", published, destinations)
+	}
+}
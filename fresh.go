@@ -0,0 +1,172 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/pubsub"
+	"google.golang.org/api/iterator"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// freshProject is a project, on one emulator host, checked by -fresh.
+type freshProject struct {
+	host          string
+	projectID     string
+	client        *pubsub.Client
+	topics        []string
+	subscriptions []string
+}
+
+func (p *freshProject) String() string {
+	if p.host != "" {
+		return p.host + " " + p.projectID
+	}
+	return p.projectID
+}
+
+var freshFound, freshRemoved int
+
+// ensureFresh checks that the projects of the targets are empty before
+// anything is created. With -fresh=fail it exits if any aren't; with
+// -fresh=purge it deletes their topics and subscriptions. Only projects
+// named by a config are listed or touched.
+func ensureFresh(hosts []string, byHost map[string][]*applyTarget) {
+	switch *fresh {
+	case "":
+		return
+	case "fail", "purge":
+	default:
+		fatalf("Unknown -fresh %q, expected fail or purge", *fresh)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	var projects []*freshProject
+	for _, host := range hosts {
+		seen := make(map[string]bool)
+		for _, target := range byHost[host] {
+			projectID := target.config.projectID
+			if seen[projectID] {
+				continue
+			}
+			seen[projectID] = true
+
+			if *fresh == "purge" {
+				if host == "" {
+					requireEmulator("-fresh=purge")
+				} else if isProductionHost(host) {
+					fatalf("Refusing to run -fresh=purge against %s: it must be an emulator", host)
+				}
+			}
+			client, err := connect(ctx, projectID, host)
+			if err != nil {
+				fatalf("%s", err)
+			}
+			project := &freshProject{host: host, projectID: projectID, client: client}
+			if err := project.list(ctx); err != nil {
+				fatalf("%s: %s", project, err)
+			}
+			freshFound += len(project.topics) + len(project.subscriptions)
+			projects = append(projects, project)
+		}
+	}
+	if freshFound == 0 {
+		debugf("Every project is empty")
+		return
+	}
+
+	var resources []string
+	for _, project := range projects {
+		for _, topicID := range project.topics {
+			resources = append(resources, fmt.Sprintf("%s: topic %s", project, topicID))
+		}
+		for _, subscriptionID := range project.subscriptions {
+			resources = append(resources, fmt.Sprintf("%s: subscription %s", project, subscriptionID))
+		}
+	}
+	if *fresh == "fail" {
+		for _, resource := range resources {
+			fmt.Printf("  %s\n", resource)
+		}
+		fatalf("-fresh=fail: found %d pre-existing resources", freshFound)
+	}
+	if err := confirmDeletion("-fresh=purge", *yes, describeEmulators(hosts), resources); err != nil {
+		fatalf("%s", err)
+	}
+
+	for _, project := range projects {
+		// Subscriptions go before topics, so none are left detached.
+		for _, subscriptionID := range project.subscriptions {
+			err := project.client.Subscription(subscriptionID).Delete(ctx)
+			if status.Code(err) == codes.NotFound {
+				// Someone else deleted it in the meantime.
+				continue
+			}
+			if err != nil {
+				fatalf("%s: Unable to delete subscription %q: %s", project, subscriptionID, err)
+			}
+			freshRemoved++
+			audit(auditEvent{Action: "deleted", Kind: "subscription", Project: project.projectID, Name: subscriptionID, Host: project.host, Source: "-fresh=purge"})
+		}
+		for _, topicID := range project.topics {
+			err := project.client.Topic(topicID).Delete(ctx)
+			if status.Code(err) == codes.NotFound {
+				// Someone else deleted it in the meantime.
+				continue
+			}
+			if err != nil {
+				fatalf("%s: Unable to delete topic %q: %s", project, topicID, err)
+			}
+			freshRemoved++
+			audit(auditEvent{Action: "deleted", Kind: "topic", Project: project.projectID, Name: topicID, Host: project.host, Source: "-fresh=purge"})
+		}
+	}
+}
+
+// list finds the topics and subscriptions in the project. Names are checked
+// against the project, in case the server lists other projects' too.
+func (p *freshProject) list(ctx context.Context) error {
+	prefix := "projects/" + p.projectID + "/"
+	topics := p.client.Topics(ctx)
+	for {
+		topic, err := topics.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("Unable to list topics: %s", err)
+		}
+		if strings.HasPrefix(topic.String(), prefix) {
+			p.topics = append(p.topics, topic.ID())
+		}
+	}
+
+	subscriptions := p.client.Subscriptions(ctx)
+	for {
+		subscription, err := subscriptions.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("Unable to list subscriptions: %s", err)
+		}
+		if strings.HasPrefix(subscription.String(), prefix) {
+			p.subscriptions = append(p.subscriptions, subscription.ID())
+		}
+	}
+	return nil
+}
+
+// reportFresh prints how many pre-existing resources -fresh found and
+// removed.
+func reportFresh() {
+	if *fresh == "" {
+		return
+	}
+	fmt.Printf("Fresh start: %d pre-existing resources found, %d removed\n", freshFound, freshRemoved)
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+// gcloudDefaults are fields gcloud always includes in its output. They are
+// only reported as not imported when they differ from these service defaults.
+var gcloudDefaults = map[string]interface{}{
+	"ackDeadlineSeconds":       float64(10),
+	"messageRetentionDuration": "604800s",
+	"expirationPolicy":         map[string]interface{}{"ttl": "2678400s"},
+}
+
+// gcloudOutputOnly are fields describing resource state rather than
+// configuration.
+var gcloudOutputOnly = map[string]bool{
+	"state":                         true,
+	"topicMessageRetentionDuration": true,
+}
+
+// importGcloud reads the JSON output of `gcloud pubsub topics list` and
+// `gcloud pubsub subscriptions list`. Either path may be empty.
+func importGcloud(topicsPath, subscriptionsPath string, mapping endpointMapping, topology *importedTopology) error {
+	if topicsPath != "" {
+		topics, err := readGcloudList(topicsPath)
+		if err != nil {
+			return err
+		}
+		for _, topic := range topics {
+			name, _ := topic["name"].(string)
+			projectID, topicID, ok := splitResourceName(name, "topics")
+			if !ok {
+				topology.unsupportedf("%s: topic name %q is not a full resource name", topicsPath, name)
+				continue
+			}
+			reportGcloudFields(topology, "topic "+name, topic, "name")
+
+			debugf("  Found topic %q in project %q", topicID, projectID)
+			topology.addTopic(projectID, topicID)
+		}
+	}
+
+	if subscriptionsPath != "" {
+		subscriptions, err := readGcloudList(subscriptionsPath)
+		if err != nil {
+			return err
+		}
+		for _, subscription := range subscriptions {
+			name, _ := subscription["name"].(string)
+			topicName, _ := subscription["topic"].(string)
+			subscriptionProjectID, subscriptionID, ok := splitResourceName(name, "subscriptions")
+			if !ok {
+				topology.unsupportedf("%s: subscription name %q is not a full resource name", subscriptionsPath, name)
+				continue
+			}
+			projectID, topicID, ok := splitResourceName(topicName, "topics")
+			if !ok {
+				topology.unsupportedf("subscription %s: topic %q is not a full resource name", name, topicName)
+				continue
+			}
+			if projectID != subscriptionProjectID {
+				topology.unsupportedf("subscription %s: topic %s is in another project", name, topicName)
+				continue
+			}
+
+			var pushEndpoint string
+			if pushConfig, ok := subscription["pushConfig"].(map[string]interface{}); ok {
+				pushEndpoint, _ = pushConfig["pushEndpoint"].(string)
+				reportGcloudFields(topology, "subscription "+name+" pushConfig", pushConfig, "pushEndpoint")
+			}
+			reportGcloudFields(topology, "subscription "+name, subscription, "name", "topic", "pushConfig")
+
+			debugf("  Found subscription %q on topic %q in project %q", subscriptionID, topicID, projectID)
+			topology.addSubscription(projectID, topicID, subscriptionID, mapping.rewrite(pushEndpoint))
+		}
+	}
+
+	return nil
+}
+
+// readGcloudList decodes a JSON array of resources.
+func readGcloudList(path string) ([]map[string]interface{}, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to read %s: %s", path, err)
+	}
+
+	var resources []map[string]interface{}
+	if err := json.Unmarshal(data, &resources); err != nil {
+		return nil, fmt.Errorf("Unable to parse %s as gcloud JSON output: %s", path, err)
+	}
+	return resources, nil
+}
+
+// splitResourceName splits "projects/<project>/<collection>/<id>".
+func splitResourceName(name, collection string) (string, string, bool) {
+	parts := strings.Split(name, "/")
+	if len(parts) != 4 || parts[0] != "projects" || parts[2] != collection || parts[1] == "" || parts[3] == "" {
+		return "", "", false
+	}
+	return parts[1], parts[3], true
+}
+
+// reportGcloudFields records every field of a resource that pubsubc can't
+// represent, skipping the supported ones, output-only fields and defaults.
+func reportGcloudFields(topology *importedTopology, label string, resource map[string]interface{}, supported ...string) {
+	skip := make(map[string]bool)
+	for _, field := range supported {
+		skip[field] = true
+	}
+
+	var fields []string
+	for field, value := range resource {
+		if skip[field] || gcloudOutputOnly[field] {
+			continue
+		}
+		if def, ok := gcloudDefaults[field]; ok && fmt.Sprint(def) == fmt.Sprint(value) {
+			continue
+		}
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+
+	for _, field := range fields {
+		topology.unsupportedf("%s: field %q is not supported", label, field)
+	}
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// postHook is a shell command to run after a config applies. A timeout of 0
+// means -post-hook-timeout.
+type postHook struct {
+	command string
+	timeout time.Duration
+}
+
+// runPostHooks runs every -post-hook, and then the config's own hooks, once
+// for each config that applied successfully, in discovery order.
+func runPostHooks(hosts []string, byHost map[string][]*applyTarget) {
+	for _, host := range hosts {
+		for _, target := range byHost[host] {
+			if target.failed {
+				continue
+			}
+			hooks := make([]postHook, 0, len(postHooks)+len(target.config.hooks))
+			for _, command := range postHooks {
+				hooks = append(hooks, postHook{command: command})
+			}
+			for _, hook := range append(hooks, target.config.hooks...) {
+				if err := runHook(hook, target); err != nil {
+					if *strictHooks {
+						fatalf("%s: Post-apply hook %q failed: %s", target.config.sourceHint, hook.command, err)
+					}
+					warnf("%s: Post-apply hook %q failed: %s", target.config.sourceHint, hook.command, err)
+				}
+			}
+		}
+	}
+}
+
+// runHook runs a hook command through the shell, describing the applied
+// config in its environment and prefixing each line of its output.
+func runHook(hook postHook, target *applyTarget) error {
+	timeout := hook.timeout
+	if timeout == 0 {
+		timeout = *postHookTimeout
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	host := target.host
+	if host == "" {
+		host = os.Getenv("PUBSUB_EMULATOR_HOST")
+	}
+
+	cmd := exec.CommandContext(ctx, "sh", "-c", hook.command)
+	cmd.Env = append(os.Environ(),
+		"PUBSUBC_PROJECT_ID="+target.config.projectID,
+		"PUBSUBC_EMULATOR_HOST="+host,
+		"PUBSUBC_SOURCE="+target.config.sourceHint,
+		"PUBSUBC_TOPICS="+strings.Join(target.config.topics.IDs(), ","),
+		"PUBSUBC_MANIFEST="+target.config.configFile,
+	)
+
+	prefix := fmt.Sprintf("[%s] ", target.config.projectID)
+	stdout := &prefixWriter{w: os.Stdout, prefix: prefix}
+	stderr := &prefixWriter{w: os.Stderr, prefix: prefix}
+	cmd.Stdout, cmd.Stderr = stdout, stderr
+	// Don't wait forever on output from processes the hook left running.
+	cmd.WaitDelay = time.Second
+
+	debugf("Running post-apply hook %q for project %q", hook.command, target.config.projectID)
+	err := cmd.Run()
+	stdout.flush()
+	stderr.flush()
+	if ctx.Err() == context.DeadlineExceeded {
+		return fmt.Errorf("timed out after %s", timeout)
+	}
+	if errors.Is(err, exec.ErrWaitDelay) {
+		debugf("Post-apply hook %q exited, leaving background processes holding its output", hook.command)
+		return nil
+	}
+	return err
+}
+
+// prefixWriter writes complete lines to w, prefixing each one.
+type prefixWriter struct {
+	w       io.Writer
+	prefix  string
+	pending []byte
+}
+
+func (p *prefixWriter) Write(b []byte) (int, error) {
+	p.pending = append(p.pending, b...)
+	for {
+		i := bytes.IndexByte(p.pending, '\n')
+		if i < 0 {
+			return len(b), nil
+		}
+		fmt.Fprintf(p.w, "%s%s\n", p.prefix, p.pending[:i])
+		p.pending = p.pending[i+1:]
+	}
+}
+
+// flush writes any final line that wasn't newline terminated.
+func (p *prefixWriter) flush() {
+	if len(p.pending) > 0 {
+		fmt.Fprintf(p.w, "%s%s\n", p.prefix, p.pending)
+		p.pending = nil
+	}
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"cloud.google.com/go/iam"
+	"cloud.google.com/go/iam/apiv1/iampb"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// iamPolicy is the IAM bindings a config file declares on a topic or
+// subscription: the members granted each role.
+type iamPolicy struct {
+	kind, id   string
+	bindings   map[string][]string
+	sourceHint string
+}
+
+// checkIAM checks the roles and members of an iam block.
+func checkIAM(bindings map[string][]string) error {
+	for _, role := range sortedKeys(bindings) {
+		if !strings.HasPrefix(role, "roles/") && !strings.Contains(role, "/roles/") {
+			return fmt.Errorf("iam role %q must be roles/name, or a custom role such as projects/p/roles/name", role)
+		}
+		if len(bindings[role]) == 0 {
+			return fmt.Errorf("iam role %q has no members", role)
+		}
+		for _, member := range bindings[role] {
+			if member != "allUsers" && member != "allAuthenticatedUsers" && !strings.Contains(member, ":") {
+				return fmt.Errorf("iam role %q: member %q must be allUsers, allAuthenticatedUsers or type:id, such as serviceAccount:name@project.iam.gserviceaccount.com", role, member)
+			}
+		}
+	}
+	return nil
+}
+
+// applyIAMPolicies grants the members of each role a config declares on its
+// topics and subscriptions, keeping every other binding. The emulator has no
+// IAM, so configs applied to one are skipped with a warning.
+func applyIAMPolicies(ctx context.Context, target *applyTarget) error {
+	if len(target.config.iam) == 0 {
+		return nil
+	}
+	if emulator := emulatorName(target.host); emulator != "" {
+		if !reconciling {
+			target.log().warnf("%s: Skipping the IAM bindings of project %q: the emulator at %s has no IAM", target.config.sourceHint, target.config.projectID, emulator)
+		}
+		return nil
+	}
+	projectID := target.config.projectID
+	var errs []error
+	for _, policy := range target.config.iam {
+		fields := []any{policy.kind, policy.id}
+		if policy.kind == "topic" && target.applier.TopicFailed(policy.id) {
+			errs = append(errs, withFields(fmt.Errorf("%s: Not binding roles on topic %q for project %q, which couldn't be created", policy.sourceHint, policy.id, projectID), fields...))
+			continue
+		}
+		handle := target.client.Topic(policy.id).IAM()
+		if policy.kind == "subscription" {
+			handle = target.client.Subscription(policy.id).IAM()
+		}
+		for _, role := range sortedKeys(policy.bindings) {
+			members := policy.bindings[role]
+			granted, err := grantRole(ctx, handle, role, members)
+			if err != nil {
+				errs = append(errs, withFields(fmt.Errorf("%s: Unable to bind role %q to %s on %s %q for project %q: %s", policy.sourceHint, role, strings.Join(members, ", "), policy.kind, policy.id, projectID, err), fields...))
+				continue
+			}
+			if len(granted) == 0 {
+				target.log(fields...).debugf("  Role %q is already bound to %s on %s %q", role, strings.Join(members, ", "), policy.kind, policy.id)
+				continue
+			}
+			target.log(fields...).debugf("  Bound role %q to %s on %s %q", role, strings.Join(granted, ", "), policy.kind, policy.id)
+			target.audit("updated", policy.kind, policy.id)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// grantRole grants role to the members of a resource's IAM policy that don't
+// have it, by read-modify-write, returning those it granted it to. A policy
+// that changed in between is read again.
+func grantRole(ctx context.Context, handle *iam.Handle, role string, members []string) ([]string, error) {
+	for attempt := 1; ; attempt++ {
+		policy, err := handle.V3().Policy(ctx)
+		if err != nil {
+			return nil, err
+		}
+		var granted []string
+		policy.Bindings, granted = withRole(policy.Bindings, role, members)
+		if len(granted) == 0 {
+			return nil, nil
+		}
+		err = handle.V3().SetPolicy(ctx, policy)
+		if status.Code(err) == codes.Aborted && attempt < 3 {
+			continue
+		}
+		return granted, err
+	}
+}
+
+// withRole returns bindings with role granted to members, and which of them
+// didn't have it yet. Conditional bindings of the role don't count, and, like
+// every other binding, are left as they are.
+func withRole(bindings []*iampb.Binding, role string, members []string) ([]*iampb.Binding, []string) {
+	var binding *iampb.Binding
+	for _, b := range bindings {
+		if b.Role == role && b.Condition == nil {
+			binding = b
+		}
+	}
+	if binding == nil {
+		binding = &iampb.Binding{Role: role}
+		bindings = append(bindings, binding)
+	}
+	var granted []string
+	for _, member := range members {
+		has := false
+		for _, m := range binding.Members {
+			has = has || m == member
+		}
+		if !has {
+			binding.Members = append(binding.Members, member)
+			granted = append(granted, member)
+		}
+	}
+	return bindings, granted
+}
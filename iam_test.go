@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"cloud.google.com/go/iam"
+	"cloud.google.com/go/iam/apiv1/iampb"
+	"google.golang.org/genproto/googleapis/type/expr"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+)
+
+// fakeIAM is an IAM policy service holding a single policy. Its first
+// aborted writes fail as if the policy had changed since it was read.
+type fakeIAM struct {
+	iampb.IAMPolicyClient
+	policy  *iampb.Policy
+	aborted int
+	writes  int
+}
+
+func (f *fakeIAM) GetIamPolicy(ctx context.Context, req *iampb.GetIamPolicyRequest, opts ...grpc.CallOption) (*iampb.Policy, error) {
+	return proto.Clone(f.policy).(*iampb.Policy), nil
+}
+
+func (f *fakeIAM) SetIamPolicy(ctx context.Context, req *iampb.SetIamPolicyRequest, opts ...grpc.CallOption) (*iampb.Policy, error) {
+	f.writes++
+	if f.aborted > 0 {
+		f.aborted--
+		return nil, status.Error(codes.Aborted, "etag mismatch")
+	}
+	f.policy = req.Policy
+	return f.policy, nil
+}
+
+func TestGrantRole(t *testing.T) {
+	conditional := &iampb.Binding{Role: "roles/pubsub.subscriber", Members: []string{"user:a@example.com"}, Condition: &expr.Expr{Expression: "true"}}
+	other := &iampb.Binding{Role: "roles/pubsub.viewer", Members: []string{"user:b@example.com"}}
+	fake := &fakeIAM{policy: &iampb.Policy{Bindings: []*iampb.Binding{conditional, other}}, aborted: 1}
+	handle := iam.InternalNewHandleGRPCClient(fake, "projects/p/subscriptions/s")
+
+	members := []string{"user:a@example.com", "serviceAccount:w@p.iam.gserviceaccount.com"}
+	granted, err := grantRole(context.Background(), handle, "roles/pubsub.subscriber", members)
+	if err != nil || !reflect.DeepEqual(granted, members) {
+		t.Fatalf("grantRole() = %v, %v, want %v", granted, err, members)
+	}
+	if fake.writes != 2 {
+		t.Errorf("grantRole() wrote the policy %d times, want 2 after an aborted write", fake.writes)
+	}
+	want := []*iampb.Binding{conditional, other, {Role: "roles/pubsub.subscriber", Members: members}}
+	if len(fake.policy.Bindings) != len(want) {
+		t.Fatalf("grantRole() left bindings %v, want %v", fake.policy.Bindings, want)
+	}
+	for i := range want {
+		if !proto.Equal(fake.policy.Bindings[i], want[i]) {
+			t.Errorf("grantRole() left binding %d as %v, want %v", i, fake.policy.Bindings[i], want[i])
+		}
+	}
+
+	granted, err = grantRole(context.Background(), handle, "roles/pubsub.subscriber", members[1:])
+	if err != nil || len(granted) != 0 || fake.writes != 2 {
+		t.Errorf("grantRole() of a bound role = %v, %v after %d writes, want nothing written", granted, err, fake.writes)
+	}
+}
+
+func TestCheckIAM(t *testing.T) {
+	for _, bindings := range []map[string][]string{
+		{"pubsub.publisher": {"user:a@example.com"}},
+		{"roles/pubsub.publisher": {}},
+		{"roles/pubsub.publisher": {"a@example.com"}},
+	} {
+		if err := checkIAM(bindings); err == nil {
+			t.Errorf("checkIAM(%v) = nil, want an error", bindings)
+		}
+	}
+	if err := checkIAM(map[string][]string{"projects/p/roles/custom": {"allUsers", "group:g@example.com"}}); err != nil {
+		t.Errorf("checkIAM() of a custom role = %v, want nil", err)
+	}
+}
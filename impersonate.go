@@ -0,0 +1,49 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+
+	"cloud.google.com/go/pubsub"
+	"google.golang.org/api/impersonate"
+	"google.golang.org/api/option"
+)
+
+// productionOptions are added to every client that talks to the real Pub/Sub
+// service rather than an emulator.
+var productionOptions []option.ClientOption
+
+// configureImpersonation sets up -impersonate-service-account credentials for
+// clients of the real Pub/Sub service. It has no effect on emulators, which
+// don't authenticate.
+func configureImpersonation() {
+	if *impersonateServiceAccount == "" {
+		return
+	}
+	if host := os.Getenv("PUBSUB_EMULATOR_HOST"); host != "" {
+		warnf("Ignoring -impersonate-service-account: using the emulator at %s", host)
+		return
+	}
+
+	ctx := context.Background()
+	tokenSource, err := impersonate.CredentialsTokenSource(ctx, impersonate.CredentialsConfig{
+		TargetPrincipal: *impersonateServiceAccount,
+		Scopes:          []string{pubsub.ScopePubSub},
+	})
+	if err == nil {
+		// Fetch a token now, so a missing grant fails here rather than
+		// halfway through creating resources.
+		_, err = tokenSource.Token()
+	}
+	if err != nil {
+		if strings.Contains(err.Error(), "iam.serviceAccounts.getAccessToken") || strings.Contains(err.Error(), "PERMISSION_DENIED") {
+			fatalf("Unable to impersonate %s: the current credentials need the Service Account Token Creator role (roles/iam.serviceAccountTokenCreator) on it: %s", *impersonateServiceAccount, err)
+		}
+		fatalf("Unable to impersonate %s: %s", *impersonateServiceAccount, err)
+	}
+
+	productionOptions = append(productionOptions, option.WithTokenSource(tokenSource))
+	fmt.Printf("Impersonating service account %s\n", *impersonateServiceAccount)
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/thinkfluent/pubsubc/pkg/pubsubc"
+)
+
+// importedTopology accumulates the topics and subscriptions found by an
+// importer, keyed by project ID, along with anything it could not convert.
+type importedTopology struct {
+	projects    map[string]Topics
+	order       []string
+	unsupported []string
+}
+
+func newImportedTopology() *importedTopology {
+	return &importedTopology{projects: make(map[string]Topics)}
+}
+
+// addTopic records a topic, creating its project on first use.
+func (t *importedTopology) addTopic(projectID, topicID string) {
+	topics, ok := t.projects[projectID]
+	if !ok {
+		t.order = append(t.order, projectID)
+	}
+	topics.Add(topicID)
+	t.projects[projectID] = topics
+}
+
+// addSubscription records a pull subscription, or a push subscription when
+// pushEndpoint is set, on a topic.
+func (t *importedTopology) addSubscription(projectID, topicID, subscriptionID, pushEndpoint string) {
+	t.addTopic(projectID, topicID)
+	topics := t.projects[projectID]
+	topics.Add(topicID, subscriptionSpec{ID: subscriptionID, PushEndpoint: pushEndpoint})
+	t.projects[projectID] = topics
+}
+
+// unsupportedf records a construct the importer could not convert.
+func (t *importedTopology) unsupportedf(format string, params ...interface{}) {
+	t.unsupported = append(t.unsupported, fmt.Sprintf(format, params...))
+}
+
+// configStrings renders each project in the PUBSUB_PROJECT<n> config string
+// format, in the order the projects were discovered, escaping any separators
+// in names and push endpoints. Topics are sorted, as importers don't find them
+// in any meaningful order.
+func (t *importedTopology) configStrings() []string {
+	var configs []string
+	for _, projectID := range t.order {
+		topics := append(Topics(nil), t.projects[projectID]...)
+		sort.Slice(topics, func(i, j int) bool { return topics[i].ID < topics[j].ID })
+
+		parts := []string{pubsubc.Escape(projectID)}
+		for _, entry := range topics {
+			part := pubsubc.Escape(entry.ID)
+			for _, subscription := range entry.Subscriptions {
+				part += ":" + pubsubc.Escape(subscription.ID)
+				if subscription.PushEndpoint != "" {
+					part += "+" + pubsubc.Escape(subscription.PushEndpoint)
+				}
+			}
+			parts = append(parts, part)
+		}
+		configs = append(configs, strings.Join(parts, ","))
+	}
+	return configs
+}
+
+// endpointMapping rewrites push endpoint prefixes, e.g. from a production
+// hostname to one reachable from the emulator.
+type endpointMapping [][2]string
+
+// loadEndpointMapping reads a mapping file of "from=to" lines. Blank lines and
+// lines starting with # are ignored.
+func loadEndpointMapping(path string) (endpointMapping, error) {
+	if path == "" {
+		return nil, nil
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to open endpoint mapping: %s", err)
+	}
+	defer file.Close()
+
+	var mapping endpointMapping
+	scanner := bufio.NewScanner(file)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		from, to, ok := strings.Cut(text, "=")
+		if !ok || from == "" {
+			return nil, fmt.Errorf("%s:%d: expected from=to, got %q", path, line, text)
+		}
+		mapping = append(mapping, [2]string{strings.TrimSpace(from), strings.TrimSpace(to)})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("Unable to read endpoint mapping: %s", err)
+	}
+	return mapping, nil
+}
+
+// rewrite replaces the prefix of the first matching entry.
+func (m endpointMapping) rewrite(endpoint string) string {
+	for _, entry := range m {
+		if strings.HasPrefix(endpoint, entry[0]) {
+			rewritten := entry[1] + strings.TrimPrefix(endpoint, entry[0])
+			debugf("  Rewrote push endpoint %q to %q", endpoint, rewritten)
+			return rewritten
+		}
+	}
+	return endpoint
+}
+
+// runImport converts topology defined by other tools into pubsubc configs and
+// either applies them or writes them out as PUBSUB_PROJECT<n> variables.
+func runImport(args []string) {
+	flags := flag.NewFlagSet("import", flag.ExitOnError)
+	terraformDir := flags.String("terraform", "", "Directory of Terraform .tf files to import")
+	gcloudTopics := flags.String("gcloud-topics", "", "File containing the JSON output of gcloud pubsub topics list")
+	gcloudSubscriptions := flags.String("gcloud-subs", "", "File containing the JSON output of gcloud pubsub subscriptions list")
+	project := flags.String("project", "", "Project ID for Terraform resources that don't declare one")
+	mappingFile := flags.String("endpoint-map", "", "File of from=to push endpoint prefix rewrites")
+	output := flags.String("o", "", "Write PUBSUB_PROJECT<n> variables to this file instead of applying")
+	flags.Parse(args)
+
+	mapping, err := loadEndpointMapping(*mappingFile)
+	if err != nil {
+		fatalf("%s", err)
+	}
+
+	if *terraformDir == "" && *gcloudTopics == "" && *gcloudSubscriptions == "" {
+		flags.Usage()
+		fatalf("No import source given")
+	}
+
+	topology := newImportedTopology()
+	if *terraformDir != "" {
+		if err := importTerraform(*terraformDir, *project, mapping, topology); err != nil {
+			fatalf("%s", err)
+		}
+	}
+	if *gcloudTopics != "" || *gcloudSubscriptions != "" {
+		if err := importGcloud(*gcloudTopics, *gcloudSubscriptions, mapping, topology); err != nil {
+			fatalf("%s", err)
+		}
+	}
+
+	for _, unsupported := range topology.unsupported {
+		warnf("Not imported: %s", unsupported)
+	}
+
+	configs := topology.configStrings()
+	if *output != "" {
+		var lines strings.Builder
+		for i, config := range configs {
+			fmt.Fprintf(&lines, "PUBSUB_PROJECT%d=%s\n", i+1, config)
+		}
+		if err := os.WriteFile(*output, []byte(lines.String()), 0644); err != nil {
+			fatalf("Unable to write %s: %s", *output, err)
+		}
+		fmt.Printf("Wrote %d imported Pub/Sub configurations to %s\n", len(configs), *output)
+		return
+	}
+
+	for i, config := range configs {
+		processConfigString(config, fmt.Sprintf("import %s", topology.order[i]), "")
+	}
+	applyConfigs()
+	pushRunMetrics()
+	flushAudit()
+	fmt.Printf("Applied %d imported Pub/Sub configurations\n", len(configs))
+	reportProbes()
+	applied := reportFailures()
+	if !reportDeliveries() || !applied {
+		os.Exit(1)
+	}
+}
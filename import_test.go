@@ -0,0 +1,43 @@
+package main
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/thinkfluent/pubsubc/pkg/pubsubc"
+)
+
+// TestImportRoundTrip checks that the config strings of imported topology,
+// separators and all, parse back to the same topics and subscriptions.
+func TestImportRoundTrip(t *testing.T) {
+	topology := newImportedTopology()
+	topology.addTopic("my-project", "orders")
+	topology.addSubscription("my-project", "orders", "orders-worker", "")
+	topology.addSubscription("my-project", "orders", "orders-push", "https://svc:8443/push+v2?a=1,b=2")
+	topology.addSubscription("my-project", "orders", "orders-odd", `http://user@svc/~x!y^z|w#frag\`)
+	topology.addSubscription("my-project", "shipments", "shipments.push", "http://worker:8080/")
+	topology.addTopic("~other", "events+v1")
+
+	configs := topology.configStrings()
+	if len(configs) != 2 {
+		t.Fatalf("configStrings() = %q, want 2 configs", configs)
+	}
+	for i, config := range configs {
+		projectID := topology.order[i]
+		want := append(Topics(nil), topology.projects[projectID]...)
+		sort.Slice(want, func(i, j int) bool { return want[i].ID < want[j].ID })
+
+		parsed, err := pubsubc.ParseConfig(config)
+		if err != nil {
+			t.Errorf("ParseConfig(%q) returned error: %s", config, err)
+			continue
+		}
+		if parsed.ProjectID != projectID || parsed.SubscriptionsOnly {
+			t.Errorf("ParseConfig(%q) has project %q, subscriptions only %v, want %q", config, parsed.ProjectID, parsed.SubscriptionsOnly, projectID)
+		}
+		if !reflect.DeepEqual(parsed.Topics, want) {
+			t.Errorf("ParseConfig(%q) = %+v, want %+v", config, parsed.Topics, want)
+		}
+	}
+}
@@ -0,0 +1,47 @@
+package main
+
+import "testing"
+
+func TestProcessJSONLabel(t *testing.T) {
+	defer func() { pendingConfigs, configCount, skippedConfigs = nil, 0, 0 }()
+	pendingConfigs, configCount, skippedConfigs = nil, 0, 0
+
+	value := `{"projects": [
+		{"id": "p1", "topics": [{"id": "orders", "subscriptions": [{"id": "orders-filtered", "filter": "attributes.type = \"order\""}]}]},
+		{"id": "p2", "host": "other:8085", "topics": [{"id": "events"}]}
+	]}`
+	if queued := processJSONLabel(value, "abc pubsubc.json", "emulator:8085"); queued != 2 {
+		t.Fatalf("processJSONLabel() queued %d configs, want 2", queued)
+	}
+	first, second := pendingConfigs[0], pendingConfigs[1]
+	if first.host != "emulator:8085" || second.host != "other:8085" {
+		t.Errorf("processJSONLabel() applied to hosts %q and %q, want the container's unless the project has its own", first.host, second.host)
+	}
+	if filter := first.topics[0].Subscriptions[0].Filter; filter != `attributes.type = "order"` {
+		t.Errorf("processJSONLabel() read filter %q", filter)
+	}
+	if first.sourceHint != "abc pubsubc.json:2" {
+		t.Errorf("processJSONLabel() gave source %q, want the label and line", first.sourceHint)
+	}
+}
+
+func TestProcessInvalidJSONLabel(t *testing.T) {
+	defer func() { pendingConfigs, configCount, skippedConfigs = nil, 0, 0 }()
+	for _, value := range []string{
+		`{"projects": [`,
+		`{"projects": [{"id": "p", "topics": [{"id": "t", "typo": 1}]}]}`,
+		`{"projects": [{"id": "p", "topics": []}]}`,
+	} {
+		pendingConfigs, configCount, skippedConfigs = nil, 0, 0
+		if queued := processJSONLabel(value, "abc pubsubc.json", ""); queued != 0 || skippedConfigs != 1 {
+			t.Errorf("processJSONLabel(%s) queued %d and skipped %d, want it skipped", value, queued, skippedConfigs)
+		}
+	}
+}
+
+func TestTextPosition(t *testing.T) {
+	text := "{\n  \"a\": 1,\n  oops\n}"
+	if line, column := textPosition(text, 15); line != 3 || column != 4 {
+		t.Errorf("textPosition() = line %d, column %d, want line 3, column 4", line, column)
+	}
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// resourceTotals counts the distinct resources the discovered configs declare,
+// across every host they are applied to.
+type resourceTotals struct {
+	Topics        int `json:"topics"`
+	Subscriptions int `json:"subscriptions"`
+	// bySource counts the resources each config source contributes.
+	bySource map[string]int
+}
+
+// declaredTotals are the totals of the last configs checked by checkLimits.
+var declaredTotals resourceTotals
+
+// countResources totals the resources declared by the targets. A resource
+// declared more than once for the same host and project counts once.
+func countResources(hosts []string, byHost map[string][]*applyTarget) resourceTotals {
+	totals := resourceTotals{bySource: make(map[string]int)}
+	seen := make(map[string]bool)
+	add := func(target *applyTarget, kind, id string) bool {
+		key := strings.Join([]string{target.host, target.config.projectID, kind, id}, " ")
+		if seen[key] {
+			return false
+		}
+		seen[key] = true
+		totals.bySource[target.config.sourceHint]++
+		return true
+	}
+	for _, host := range hosts {
+		for _, target := range byHost[host] {
+			for _, entry := range target.config.topics {
+				if add(target, "topic", entry.ID) {
+					totals.Topics++
+				}
+				for _, subscription := range target.config.withAutoSub(entry) {
+					if add(target, "subscription", subscription.ID) {
+						totals.Subscriptions++
+					}
+				}
+			}
+		}
+	}
+	return totals
+}
+
+// checkLimits exits, before anything is created, if the targets declare more
+// resources than -max-topics or -max-subscriptions allow.
+func checkLimits(hosts []string, byHost map[string][]*applyTarget) {
+	declaredTotals = countResources(hosts, byHost)
+	debugf("Configs declare %d topics and %d subscriptions", declaredTotals.Topics, declaredTotals.Subscriptions)
+
+	var exceeded []string
+	if *maxTopics > 0 && declaredTotals.Topics > *maxTopics {
+		exceeded = append(exceeded, fmt.Sprintf("%d topics (more than -max-topics %d)", declaredTotals.Topics, *maxTopics))
+	}
+	if *maxSubscriptions > 0 && declaredTotals.Subscriptions > *maxSubscriptions {
+		exceeded = append(exceeded, fmt.Sprintf("%d subscriptions (more than -max-subscriptions %d)", declaredTotals.Subscriptions, *maxSubscriptions))
+	}
+	if len(exceeded) == 0 {
+		return
+	}
+
+	sources := sortedKeys(declaredTotals.bySource)
+	sort.SliceStable(sources, func(i, j int) bool {
+		return declaredTotals.bySource[sources[i]] > declaredTotals.bySource[sources[j]]
+	})
+	if len(sources) > 3 {
+		sources = sources[:3]
+	}
+	var largest []string
+	for _, source := range sources {
+		largest = append(largest, fmt.Sprintf("%s (%d)", source, declaredTotals.bySource[source]))
+	}
+	fatalf("Refusing to run: the configs declare %s; the largest are %s", strings.Join(exceeded, " and "), strings.Join(largest, ", "))
+}
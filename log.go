@@ -0,0 +1,163 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+
+	"github.com/thinkfluent/pubsubc/pkg/pubsubc"
+)
+
+var (
+	logLevel  = flag.String("log-level", "", "Least severe log lines to print: debug, info, warn or error (default info, or debug with -debug)")
+	logFormat = flag.String("log-format", "text", "Format of log lines: text, or json for one object per line")
+)
+
+var (
+	// minLogLevel is the least severe level printed.
+	minLogLevel = slog.LevelInfo
+	// stdoutLogger and stderrLogger write JSON log lines, and are nil for the
+	// text format. Debug and info lines go to stdout, the rest to stderr, as
+	// with the text format.
+	stdoutLogger *slog.Logger
+	stderrLogger *slog.Logger
+)
+
+// configureLogging checks the -log-level and -log-format flags.
+func configureLogging() {
+	switch strings.ToLower(*logLevel) {
+	case "":
+		if *debug {
+			minLogLevel = slog.LevelDebug
+		}
+	case "debug":
+		minLogLevel = slog.LevelDebug
+	case "info":
+		minLogLevel = slog.LevelInfo
+	case "warn", "warning":
+		minLogLevel = slog.LevelWarn
+	case "error":
+		minLogLevel = slog.LevelError
+	default:
+		fatalf("Unknown -log-level %q, expected debug, info, warn or error", *logLevel)
+	}
+
+	switch *logFormat {
+	case "text":
+	case "json":
+		options := &slog.HandlerOptions{Level: minLogLevel}
+		stdoutLogger = slog.New(slog.NewJSONHandler(os.Stdout, options))
+		stderrLogger = slog.New(slog.NewJSONHandler(os.Stderr, options))
+	default:
+		fatalf("Unknown -log-format %q, expected text or json", *logFormat)
+	}
+}
+
+// logf prints a log line at level. fields are alternating keys and values,
+// such as the project and topic the line is about; the text format leaves
+// them out, as the message names them already.
+func logf(level slog.Level, fields []any, format string, params ...interface{}) {
+	if level < minLogLevel {
+		return
+	}
+	message := fmt.Sprintf(format, params...)
+
+	if stdoutLogger != nil {
+		logger := stdoutLogger
+		if level >= slog.LevelWarn {
+			logger = stderrLogger
+		}
+		logger.Log(context.Background(), level, strings.TrimSpace(message), fields...)
+		return
+	}
+
+	switch {
+	case level >= slog.LevelError:
+		fmt.Fprintf(os.Stderr, "%s: %s\n", os.Args[0], message)
+	case level >= slog.LevelWarn:
+		fmt.Fprintf(os.Stderr, "%s: WARNING %s\n", os.Args[0], message)
+	default:
+		fmt.Println(message)
+	}
+}
+
+// debugf prints debugging information.
+func debugf(format string, params ...interface{}) {
+	logf(slog.LevelDebug, nil, format, params...)
+}
+
+// warnf prints an error to stderr
+func warnf(format string, params ...interface{}) {
+	logf(slog.LevelWarn, nil, format, params...)
+}
+
+// fatalf prints an error to stderr and exits, once any audit events for
+// changes already made are published.
+func fatalf(format string, params ...interface{}) {
+	logf(slog.LevelError, nil, format, params...)
+	flushAudit()
+	os.Exit(1)
+}
+
+// logContext is the structured fields of the log lines about something, as
+// alternating keys and values.
+type logContext []any
+
+// log returns the log context of the config of a target, and optionally of
+// the topic or subscription a log line is about.
+func (t *applyTarget) log(keysAndValues ...any) logContext {
+	fields := logContext{"project", t.config.projectID, "source", t.config.sourceHint}
+	if t.host != "" {
+		fields = append(fields, "host", t.host)
+	}
+	return append(fields, keysAndValues...)
+}
+
+func (c logContext) debugf(format string, params ...interface{}) {
+	logf(slog.LevelDebug, c, format, params...)
+}
+
+func (c logContext) warnf(format string, params ...interface{}) {
+	logf(slog.LevelWarn, c, format, params...)
+}
+
+// fieldsError is an error about a single resource, carrying the structured
+// fields that name it.
+type fieldsError struct {
+	err    error
+	fields []any
+}
+
+func (e fieldsError) Error() string { return e.err.Error() }
+
+func (e fieldsError) Unwrap() error { return e.err }
+
+// withFields attaches structured fields, such as the topic or subscription it
+// is about, to an error.
+func withFields(err error, keysAndValues ...any) error {
+	if err == nil {
+		return nil
+	}
+	return fieldsError{err: err, fields: keysAndValues}
+}
+
+// errorFields returns the structured fields attached to an error, if any,
+// or naming the topic or subscription the pubsubc package failed on.
+func errorFields(err error) []any {
+	var withFields fieldsError
+	if errors.As(err, &withFields) {
+		return withFields.fields
+	}
+	var resourceErr *pubsubc.ResourceError
+	if errors.As(err, &resourceErr) {
+		if resourceErr.Subscription != "" {
+			return []any{"topic", resourceErr.Topic, "subscription", resourceErr.Subscription}
+		}
+		return []any{"topic", resourceErr.Topic}
+	}
+	return nil
+}
@@ -2,21 +2,96 @@ package main
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"flag"
 	"fmt"
+	"net/http"
 	"os"
 	"runtime"
+	"sort"
+	"strconv"
 	"strings"
+	"time"
 
 	"cloud.google.com/go/pubsub"
+	vkit "cloud.google.com/go/pubsub/apiv1"
 	"github.com/docker/docker/api/types"
 	"github.com/docker/docker/client"
+	"github.com/thinkfluent/pubsubc/pkg/pubsubc"
+	"google.golang.org/api/option"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
 )
 
 var (
-	debug   = flag.Bool("debug", false, "Enable debug logging")
+	debug   = flag.Bool("debug", false, "Enable debug logging, the same as -log-level debug")
 	help    = flag.Bool("help", false, "Display usage information")
 	version = flag.Bool("version", false, "Display version information")
+
+	configFile         = flag.String("config", "", "YAML or JSON file of configs to apply, in addition to any others (default $PUBSUBC_CONFIG_FILE)")
+	configDir          = flag.String("config-dir", "", "Directory of files each holding a config string, such as a mounted ConfigMap (default $PUBSUBC_CONFIG_DIR)")
+	firebaseConfigPath = flag.String("firebase-config", "", "Read the Pub/Sub emulator endpoint from this firebase.json (default ./firebase.json if present)")
+	replicate          = flag.String("replicate", "", "Comma separated emulator hosts to apply every config to")
+	autoDetectEmulator = flag.Bool("auto-detect-emulator", false, "Look for a running emulator container when PUBSUB_EMULATOR_HOST is not set")
+	defaultProject     = flag.String("default-project", "", "Project ID used for configs with an empty or $DEFAULT project")
+	autoSub            = flag.Bool("auto-sub", false, "Create a <topic>-sub pull subscription for topics declared without subscriptions")
+	imageLabels        = flag.Bool("image-labels", false, "Also read pubsubc labels from the image of each running container")
+	labelPrefix        = flag.String("label-prefix", "pubsubc", "Prefix of the Docker labels to read configs and hosts from, before the first . of their keys")
+	maxTopics          = flag.Int("max-topics", 1000, "Refuse to run if the configs declare more topics than this, 0 for no limit")
+	maxSubscriptions   = flag.Int("max-subscriptions", 5000, "Refuse to run if the configs declare more subscriptions than this, 0 for no limit")
+	fresh              = flag.String("fresh", "", "Before applying, fail if the configs' projects contain anything (fail), or delete it (purge)")
+	yes                = flag.Bool("yes", false, "Don't ask for confirmation before -delete, -recreate or -fresh=purge deletes anything, as needed without a terminal")
+
+	perConfigTimeout = flag.Duration("per-config-timeout", time.Minute, "How long applying a single config may take before it is abandoned")
+	waitTimeout      = flag.Duration("wait-timeout", time.Minute, "How long to wait for each emulator to answer before applying, 0 to fail fast")
+	concurrency      = flag.Int("concurrency", 8, "How many topics and subscriptions may be created at once, across every config")
+	retries          = flag.Int("retries", 3, "How many times to retry a request that fails because the emulator is unavailable or slow to answer")
+	reconcileEvery   = flag.Duration("reconcile-interval", 0, "Keep running and re-apply the discovered configs this often, 0 to apply them once")
+	fixOrphans       = flag.Bool("fix-orphans", false, "With -reconcile-interval, delete the orphaned subscriptions pubsubc manages before each pass, so those the configs declare are recreated")
+	bestEffort       = flag.Bool("best-effort", false, "Exit 0 even if some configs fail to apply, only warning about them")
+
+	watch  = flag.Bool("watch", false, "After applying, keep running and apply the Docker label configs of containers as they start")
+	dryRun = flag.Bool("dry-run", false, "Print and check the discovered configs without connecting to Pub/Sub")
+	verify = flag.Bool("verify", false, "Check that the resources the discovered configs declare exist and match, without creating anything")
+
+	// Labels can't change once a container is created, but a missed event or
+	// an emulator that restarted can still leave resources missing.
+	watchReconcileEvery = flag.Duration("watch-reconcile-interval", 10*time.Minute, "How often -watch re-applies the configs of every running container, 0 never")
+
+	noSeed = flag.Bool("no-seed", false, "Don't publish seed messages, only create the topics and subscriptions")
+
+	deleteConfigs = flag.Bool("delete", false, "Delete the subscriptions and topics the discovered configs declare, instead of creating them")
+	recreate      = flag.Bool("recreate", false, "Delete the subscriptions and topics the discovered configs declare, then create them again")
+
+	missingTopic        = flag.String("missing-topic", "fail", "What to do when a subscriptions-only config refers to a missing topic: fail, wait or create")
+	missingTopicTimeout = flag.Duration("missing-topic-timeout", time.Minute, "How long -missing-topic=wait waits for each topic")
+
+	probePush    = flag.Bool("probe-push", false, "Probe push endpoints after creating push subscriptions")
+	probeMethod  = flag.String("probe-method", http.MethodHead, "HTTP method used when probing push endpoints")
+	probeTimeout = flag.Duration("probe-timeout", 2*time.Second, "Timeout for a single push endpoint probe")
+	probeBudget  = flag.Duration("probe-budget", 10*time.Second, "Total time allowed for probing all push endpoints")
+
+	verifyDelivery = flag.Bool("verify-delivery", false, "After creating resources, check that a canary message reaches every pull subscription")
+	verifyTimeout  = flag.Duration("verify-timeout", 10*time.Second, "How long each config's delivery checks may take")
+
+	smokeTestPush    = flag.Bool("smoke-test-push", false, "After creating resources, check push delivery from every topic to a temporary listener")
+	smokeListen      = flag.String("smoke-listen", ":8687", "Address the push smoke test listener listens on")
+	smokeCallbackURL = flag.String("smoke-callback-url", "", "URL the emulator reaches the smoke test listener on (default detected)")
+
+	postHookTimeout = flag.Duration("post-hook-timeout", time.Minute, "How long a single post-apply hook may run")
+	strictHooks     = flag.Bool("strict-hooks", false, "Exit when a post-apply hook fails instead of warning")
+
+	impersonateServiceAccount = flag.String("impersonate-service-account", "", "Service account to impersonate when using the real Pub/Sub service")
+	transport                 = flag.String("transport", "grpc", "How configs are applied to the emulator: grpc, or rest for its HTTP/JSON API")
+
+	auditTopicName = flag.String("audit-topic", "", "Publish an event for every change pubsubc makes to this topic, as projects/<project>/topics/<topic>")
+
+	pushgatewayURL      = flag.String("pushgateway-url", "", "Push run metrics to this Prometheus Pushgateway when the run finishes")
+	pushgatewayJob      = flag.String("pushgateway-job", "pubsubc", "Job label for metrics pushed to the Pushgateway")
+	pushgatewayInstance = flag.String("pushgateway-instance", "", "Instance label for metrics pushed to the Pushgateway")
+	contentMetricsEvery = flag.Duration("content-metrics-interval", time.Minute, "How often a long-running pubsubc lists the projects it applied configs to, pushing what they hold to the Pushgateway, 0 never")
 )
 
 // The CommitHash and Revision variables are set during building.
@@ -27,94 +102,253 @@ var (
 
 var (
 	configCount = 0
+	// skippedConfigs counts the discovered configs too malformed to queue.
+	skippedConfigs = 0
+	// unappliedConfigs counts the queued configs that failed or timed out on
+	// any host.
+	unappliedConfigs = 0
+	postHooks        stringList
+)
+
+// commands maps subcommand names to their entry points. Each receives the
+// arguments following its name.
+var commands = map[string]func(args []string){
+	"import":          runImport,
+	"relay":           runRelay,
+	"forward":         runForward,
+	"await-empty":     runAwaitEmpty,
+	"stats":           runStats,
+	"proxy":           runProxy,
+	"monitor":         runMonitor,
+	"sync":            runSync,
+	"record":          runRecord,
+	"delete-resource": runDeleteResource,
+	"migrate-sub":     runMigrateSub,
+	"ui":              runUI,
+	"plan":            runPlan,
+	"show-plan":       runShowPlan,
+	"apply":           runApplyPlan,
+	"adopt":           runAdopt,
+	"churn":           runChurn,
+}
+
+// stringList is a flag.Value collecting every occurrence of a repeated flag.
+type stringList []string
+
+func (l *stringList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *stringList) Set(value string) error {
+	*l = append(*l, value)
+	return nil
+}
+
+// The resources a config declares are described by the pubsubc package.
+type (
+	subscriptionSpec = pubsubc.Subscription
+	topicEntry       = pubsubc.Topic
+	Topics           = pubsubc.Topics
 )
 
-// Topics describes a PubSub topic and its subscriptions.
-type Topics map[string][]string
+// withAutoSub returns the topic's subscriptions, or the one -auto-sub, or the
+// autoSub key of a config file, creates for a topic declared without any.
+func (c *projectConfig) withAutoSub(entry topicEntry) []subscriptionSpec {
+	if len(entry.Subscriptions) == 0 && c.autoSub {
+		return []subscriptionSpec{{ID: entry.ID + "-sub"}}
+	}
+	return entry.Subscriptions
+}
+
+// projectConfig is a parsed config waiting to be applied. The topics of a
+// subscriptionsOnly config belong to someone else and are never created,
+// unless -missing-topic=create. autoSub creates a subscription for topics
+// declared without any. defaults are already applied to the declared
+// subscriptions, and are kept for those autoSub creates. seeds are published
+// once every subscription exists. hooks run after it applies, after those of
+// -post-hook, and configFile is the config file it came from, if any.
+type projectConfig struct {
+	projectID         string
+	host              string
+	topics            Topics
+	sourceHint        string
+	subscriptionsOnly bool
+	autoSub           bool
+	defaults          subscriptionDefaults
+	seeds             []seedMessage
+	schemas           []schemaDefinition
+	hooks             []postHook
+	iam               []iamPolicy
+	configFile        string
+}
+
+// pendingConfigs are the discovered configs, applied together by
+// applyConfigs.
+var pendingConfigs []*projectConfig
 
 func versionString() string {
 	return fmt.Sprintf("pubsubc - build %s (%s) running on %s", Revision, CommitHash, runtime.Version())
 }
 
-// debugf prints debugging information.
-func debugf(format string, params ...interface{}) {
-	if *debug {
-		fmt.Printf(format+"\n", params...)
+// newClient connects to the PubSub service for the specified project ID. An
+// emulator host overrides the PUBSUB_EMULATOR_HOST default.
+func newClient(ctx context.Context, projectID string, host string) (*pubsub.Client, error) {
+	if host == "" {
+		return pubsub.NewClient(ctx, projectID, productionOptions...)
 	}
+	// pubsub.NewClient dials PUBSUB_EMULATOR_HOST itself when it is set, and
+	// only a connection of our own takes precedence over that.
+	conn, err := grpc.Dial(host, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		return nil, err
+	}
+	return pubsub.NewClient(ctx, projectID, option.WithGRPCConn(conn), option.WithTelemetryDisabled())
 }
 
-// fatalf prints an error to stderr and exits.
-func fatalf(format string, params ...interface{}) {
-	fmt.Fprintf(os.Stderr, os.Args[0]+": "+format+"\n", params...)
-	os.Exit(1)
+// newSubscriberClient creates a low-level subscriber API client. Unlike
+// pubsub.NewClient it doesn't read PUBSUB_EMULATOR_HOST itself.
+func newSubscriberClient(ctx context.Context, host string) (*vkit.SubscriberClient, error) {
+	if host == "" {
+		host = os.Getenv("PUBSUB_EMULATOR_HOST")
+	}
+	if host == "" {
+		return vkit.NewSubscriberClient(ctx, productionOptions...)
+	}
+	return vkit.NewSubscriberClient(ctx, emulatorOptions(host)...)
 }
 
-// warnf prints an error to stderr
-func warnf(format string, params ...interface{}) {
-	fmt.Fprintf(os.Stderr, os.Args[0]+": WARNING "+format+"\n", params...)
+// emulatorOptions are the client options for talking to the emulator at host.
+func emulatorOptions(host string) []option.ClientOption {
+	return []option.ClientOption{
+		option.WithEndpoint(host),
+		option.WithoutAuthentication(),
+		option.WithTelemetryDisabled(),
+		option.WithGRPCDialOption(grpc.WithTransportCredentials(insecure.NewCredentials())),
+	}
 }
 
-// create a connection to the PubSub service and create topics and subscriptions
-// for the specified project ID.
-func create(ctx context.Context, projectID string, topics Topics) error {
-	client, err := pubsub.NewClient(ctx, projectID)
+// connect creates a client for the specified project ID, on the emulator at
+// host if one is given.
+func connect(ctx context.Context, projectID string, host string) (*pubsub.Client, error) {
+	client, err := newClient(ctx, projectID, host)
 	if err != nil {
-		fatalf("Unable to create client to project %q: %s", projectID, err)
+		if host != "" {
+			return nil, fmt.Errorf("Unable to create client to project %q on %s: %s", projectID, host, err)
+		}
+		return nil, fmt.Errorf("Unable to create client to project %q: %s", projectID, err)
 	}
 	// No need to manually close the client (causes a netty error in the Pub/Sub emulator)
 	// defer client.Close()
-	
 
-	debugf("Client connected with project ID %q", projectID)
+	if host != "" {
+		debugf("Client connected with project ID %q on %s", projectID, host)
+	} else {
+		debugf("Client connected with project ID %q", projectID)
+	}
+	return client, nil
+}
 
-	for topicID, subscriptions := range topics {
+// newApplier returns the pubsubc.Applier of a target, which creates its
+// topics and subscriptions through its topology, with the request slots
+// shared by every target.
+func newApplier(target *applyTarget) *pubsubc.Applier {
+	opts := []pubsubc.Option{
+		pubsubc.WithRequestSlots(requestSlots),
+		pubsubc.WithRetries(*retries),
+		pubsubc.WithMissingTopic(missingTopicModes[*missingTopic], *missingTopicTimeout),
+		pubsubc.WithFieldLogger(func(keysAndValues []any, format string, params ...interface{}) {
+			// Lines about subscriptions are indented below their topics.
+			indent := "  "
+			for i := 0; i < len(keysAndValues); i += 2 {
+				if keysAndValues[i] == "subscription" {
+					indent = "    "
+				}
+			}
+			target.log(keysAndValues...).debugf(indent+format, params...)
+		}),
+		pubsubc.WithObserver(func(event pubsubc.Event) {
+			observeApply(target, event)
+		}),
+	}
+	if target.config.autoSub {
+		opts = append(opts, pubsubc.WithAutoSub(), pubsubc.WithAutoSubDefaults(target.config.defaults))
+	}
+	config := pubsubc.ProjectConfig{
+		ProjectID:         target.config.projectID,
+		Topics:            target.config.topics,
+		SubscriptionsOnly: target.config.subscriptionsOnly,
+	}
+	return pubsubc.NewApplier(config, target.topology, opts...)
+}
 
-		debugf("  Checking for existing topic %q", topicID)
-		topic := client.Topic(topicID)
-		exists, err := topic.Exists(ctx)
-		if err != nil {
-			return fmt.Errorf("Failed to check exisitence of topic %q for project %q: %s", topicID, projectID, err)
-		}
+// missingTopicModes maps the values of -missing-topic to what the pubsubc
+// package does.
+var missingTopicModes = map[string]pubsubc.MissingTopic{
+	"fail":   pubsubc.MissingTopicFail,
+	"wait":   pubsubc.MissingTopicWait,
+	"create": pubsubc.MissingTopicCreate,
+}
 
-		if exists {
-			debugf("  Topic %q already exists", topicID)
-		} else {
-			debugf("  Creating topic %q", topicID)
-			topic, err = client.CreateTopic(ctx, topicID)
-			if err != nil {
-				return fmt.Errorf("Unable to create topic %q for project %q: %s", topicID, projectID, err)
-			}
-		}
+// observeApply counts, audits and follows up on what became of a topic or
+// subscription of a target.
+func observeApply(target *applyTarget, event pubsubc.Event) {
+	counts, kind, name := &target.topics, "topic", event.Topic
+	if event.Subscription != nil {
+		counts, kind, name = &target.subscriptions, "subscription", event.Subscription.ID
+	}
+	switch event.Result {
+	case pubsubc.Created:
+		counts.created.Add(1)
+		target.audit("created", kind, name)
+	case pubsubc.Existing:
+		counts.skipped.Add(1)
+	case pubsubc.Updated:
+		counts.updated.Add(1)
+		target.audit("updated", kind, name)
+	case pubsubc.Failed:
+		counts.failed.Add(1)
+	}
+	if event.Subscription == nil || event.Result != pubsubc.Created {
+		return
+	}
+	subscription := event.Subscription
+	switch {
+	case event.Auto:
+		target.autoSubscriptions.Add(1)
+	case subscription.PushEndpoint != "":
+		target.pushSubscriptions.Add(1)
+		probeEndpoint(target.config.projectID, subscription.ID, subscription.PushEndpoint)
+	case subscription.BigQueryTable != "":
+		target.bigQuerySubscriptions.Add(1)
+	}
+}
 
-		for _, subscription := range subscriptions {
-			subscriptionParts := strings.Split(subscription, "+")
-			subscriptionID := subscriptionParts[0]
-			if len(subscriptionParts) > 1 {
-				pushEndpoint := strings.Replace(subscriptionParts[1], "|", ":", 2)
-				if (!strings.HasPrefix(pushEndpoint, "http")) {
-					pushEndpoint = "http://" + pushEndpoint
-				}
-				debugf("    Creating push subscription %q with target %q", subscriptionID, pushEndpoint)
-				pushConfig := pubsub.PushConfig{Endpoint: pushEndpoint}
-				_, err = client.CreateSubscription(
-					ctx,
-					subscriptionID,
-					pubsub.SubscriptionConfig{Topic: topic, PushConfig: pushConfig},
-				)
-				if err != nil {
-					return fmt.Errorf("Unable to create push subscription %q on topic %q for project %q using push endpoint %q: %s", subscriptionID, topicID, projectID, pushEndpoint, err)
-				}
-			} else {
-				debugf("    Creating pull subscription %q", subscriptionID)
-				_, err = client.CreateSubscription(ctx, subscriptionID, pubsub.SubscriptionConfig{Topic: topic})
-				if err != nil {
-					return fmt.Errorf("Unable to create subscription %q on topic %q for project %q: %s", subscriptionID, topicID, projectID, err)
-				}
-			}
+// createTopics creates the schemas of a config, then its topics that don't
+// already exist, up to -concurrency at a time. A topic that fails doesn't
+// stop the rest; every failure is returned together.
+func createTopics(ctx context.Context, target *applyTarget) error {
+	if target.config.subscriptionsOnly {
+		// Its topics are looked up once every other config's exist.
+		target.log().debugf("  Not creating the topics of subscriptions-only config %s", target.config.sourceHint)
+		return nil
+	}
+	// Topics can only be created with a schema that exists.
+	schemaErr := createSchemas(ctx, target)
+	err := target.applier.CreateTopics(ctx)
+	for _, entry := range target.config.topics {
+		if !target.applier.TopicCreated(entry.ID) && !target.applier.TopicFailed(entry.ID) {
+			checkTopicSchema(ctx, target, entry)
 		}
 	}
+	return errors.Join(schemaErr, err)
+}
 
-	return nil
+// createSubscriptions creates the subscriptions of every topic of a config,
+// up to -concurrency at a time. The topics must already exist, and the
+// subscriptions of those that failed are left out. A subscription that fails
+// doesn't stop the rest; every failure is returned together.
+func createSubscriptions(ctx context.Context, target *applyTarget) error {
+	return target.applier.CreateSubscriptions(ctx)
 }
 
 func processDockerLabelConfig() {
@@ -136,38 +370,305 @@ func processDockerLabelConfig() {
 
 	debugf("Looking for Docker label configs")
 
+	imageLabelCache := make(map[string]map[string]string)
 	for _, container := range containers {
-		debugf("Found container [%s] names %s", container.ID[:10], container.Names)
-		for key, value := range container.Labels {
-			labelKeyParts := strings.Split(key, ".")
-			if "pubsubc" == labelKeyParts[0] {
-				processConfigString(value, fmt.Sprintf("%s %s", container.ID[:10], key))
+		processContainerLabels(cli, container, imageLabelCache)
+	}
+}
+
+// watchedContainer is a container whose pubsubc labels have been read: a hash
+// of them, and the configs they hold.
+type watchedContainer struct {
+	hash    string
+	configs []*projectConfig
+}
+
+// watchedContainers are the containers whose labels have been read, by ID, so
+// that -watch only queues the configs of new containers, and of those whose
+// labels changed, again.
+var watchedContainers = make(map[string]*watchedContainer)
+
+// containerChange is how a container's pubsubc labels compare to when they
+// were last read.
+type containerChange int
+
+const (
+	containerUnchanged containerChange = iota
+	containerChanged
+	containerNew
+)
+
+// processContainerLabels queues the configs in a container's pubsubc labels,
+// unless they are unchanged since they were last read, returning how many it
+// queued and how the labels changed.
+func processContainerLabels(cli *client.Client, container types.Container, imageLabelCache map[string]map[string]string) (int, containerChange) {
+	debugf("Found container [%s] names %s", container.ID[:10], container.Names)
+	labels := container.Labels
+	var fromImage map[string]bool
+	if *imageLabels {
+		labels, fromImage = mergeImageLabels(cli, container, imageLabelCache)
+	}
+	host := containerHost(container.ID, labels)
+	keys := configLabelKeys(labels)
+
+	hash := sha256.New()
+	fmt.Fprintf(hash, "%s\x00", host)
+	for _, key := range keys {
+		fmt.Fprintf(hash, "%s\x00%s\x00", key, expandContainerPlaceholders(container, labels[key]))
+	}
+	sum := hex.EncodeToString(hash.Sum(nil))
+	change := containerNew
+	if watched, ok := watchedContainers[container.ID]; ok {
+		if watched.hash == sum {
+			return 0, containerUnchanged
+		}
+		change = containerChanged
+	}
+
+	queued, first := 0, len(pendingConfigs)
+	for _, key := range keys {
+		sourceHint := fmt.Sprintf("%s %s", container.ID[:10], key)
+		if fromImage[key] {
+			sourceHint += " (image " + container.Image + ")"
+		} else if *imageLabels {
+			sourceHint += " (container)"
+		}
+		value := expandContainerPlaceholders(container, labels[key])
+		if name, _ := labelName(key); name == jsonLabelName {
+			queued += processJSONLabel(value, sourceHint, host)
+			continue
+		}
+		processConfigString(value, sourceHint, host)
+		queued++
+	}
+	configs := append([]*projectConfig(nil), pendingConfigs[first:]...)
+	watchedContainers[container.ID] = &watchedContainer{hash: sum, configs: configs}
+	return queued, change
+}
+
+// configLabelKeys returns the keys of the config labels among a container's
+// labels, in the order they are applied: those ending in a number by that
+// number, such as pubsubc.config1 before pubsubc.config2 and pubsubc.config10,
+// then the rest in lexical order.
+func configLabelKeys(labels map[string]string) []string {
+	var keys []string
+	for key := range labels {
+		if name, ok := labelName(key); ok && !isHostLabel(name) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		a, aNumbered := labelNumber(keys[i])
+		b, bNumbered := labelNumber(keys[j])
+		switch {
+		case aNumbered && bNumbered && a != b:
+			return a < b
+		case aNumbered != bNumbered:
+			return aNumbered
+		default:
+			return keys[i] < keys[j]
+		}
+	})
+	return keys
+}
+
+// labelNumber returns the number a label key ends in, if it does.
+func labelNumber(key string) (int, bool) {
+	digits := len(key)
+	for digits > 0 && key[digits-1] >= '0' && key[digits-1] <= '9' {
+		digits--
+	}
+	n, err := strconv.Atoi(key[digits:])
+	return n, err == nil
+}
+
+// labelName returns the rest of a label key after the -label-prefix and a .,
+// and whether the key has the prefix at all.
+func labelName(key string) (string, bool) {
+	return strings.CutPrefix(key, *labelPrefix+".")
+}
+
+// mergeImageLabels returns a container's labels merged with those of the image
+// it was created from, and which of them came from the image. The container's
+// own labels win on conflict. Image inspections are cached by image ID.
+func mergeImageLabels(cli *client.Client, container types.Container, cache map[string]map[string]string) (map[string]string, map[string]bool) {
+	imageLabels, ok := cache[container.ImageID]
+	if !ok {
+		image, _, err := cli.ImageInspectWithRaw(context.Background(), container.ImageID)
+		if err != nil {
+			warnf("%s: Unable to inspect image %s: %s", container.ID[:10], container.Image, err.Error())
+		} else if image.Config != nil {
+			imageLabels = image.Config.Labels
+		}
+		cache[container.ImageID] = imageLabels
+	}
+
+	labels := make(map[string]string, len(container.Labels)+len(imageLabels))
+	fromImage := make(map[string]bool)
+	for key, value := range imageLabels {
+		labels[key] = value
+		fromImage[key] = true
+	}
+	for key, value := range container.Labels {
+		// Docker copies image labels onto the container, so only a differing
+		// value is the container's own.
+		if imageValue, ok := imageLabels[key]; ok && imageValue == value {
+			continue
+		}
+		labels[key] = value
+		delete(fromImage, key)
+	}
+	return labels, fromImage
+}
+
+// expandContainerPlaceholders replaces {container} in a label config with the
+// container's name, and {port} with the lowest port it exposes, so a push
+// endpoint can point back at the container carrying the label. A placeholder
+// that can't be resolved is left as it is, with a warning.
+func expandContainerPlaceholders(container types.Container, value string) string {
+	if strings.Contains(value, "{container}") {
+		// Names have a leading /, and those of legacy links name the linking
+		// container too.
+		var names []string
+		for _, name := range container.Names {
+			name = strings.TrimPrefix(name, "/")
+			if !strings.Contains(name, "/") {
+				names = append(names, name)
 			}
 		}
+		if len(names) == 1 {
+			value = strings.ReplaceAll(value, "{container}", names[0])
+		} else {
+			warnf("%s: Unable to resolve {container}, the container has %d names %v", container.ID[:10], len(names), container.Names)
+		}
+	}
+
+	if strings.Contains(value, "{port}") {
+		var port uint16
+		for _, p := range container.Ports {
+			if p.PrivatePort != 0 && (port == 0 || p.PrivatePort < port) {
+				port = p.PrivatePort
+			}
+		}
+		if port != 0 {
+			value = strings.ReplaceAll(value, "{port}", strconv.Itoa(int(port)))
+		} else {
+			warnf("%s: Unable to resolve {port}, the container exposes no ports", container.ID[:10])
+		}
+	}
+	return value
+}
+
+// isHostLabel reports whether the name of a label after its prefix is host or
+// host.<name>, which set the emulator host rather than a config.
+func isHostLabel(name string) bool {
+	return name == "host" || strings.HasPrefix(name, "host.")
+}
+
+// containerHost returns the emulator host a container's configs should be
+// applied to, or "" for the global default. If several host labels disagree,
+// the first in sorted order wins.
+func containerHost(containerID string, labels map[string]string) string {
+	var keys []string
+	for key := range labels {
+		if name, ok := labelName(key); ok && isHostLabel(name) {
+			keys = append(keys, key)
+		}
+	}
+	if len(keys) == 0 {
+		return ""
+	}
+	sort.Strings(keys)
+
+	host := labels[keys[0]]
+	for _, key := range keys[1:] {
+		if labels[key] != host {
+			warnf("%s: Conflicting host labels, using %s=%s and ignoring %s=%s", containerID[:10], keys[0], host, key, labels[key])
+		}
+	}
+	debugf("Using emulator host %s from label %s", host, keys[0])
+	return host
+}
+
+// resolveDefaultProject finds the project to use when a config doesn't name
+// one, returning where it came from.
+func resolveDefaultProject() (string, string, error) {
+	for _, env := range []string{"GOOGLE_CLOUD_PROJECT", "PUBSUB_PROJECT_ID"} {
+		if projectID := os.Getenv(env); projectID != "" {
+			return projectID, env, nil
+		}
+	}
+	if *defaultProject != "" {
+		return *defaultProject, "-default-project", nil
+	}
+	if firebaseProject != "" {
+		return firebaseProject, ".firebaserc", nil
+	}
+	return "", "", fmt.Errorf("No project given and no default set; use GOOGLE_CLOUD_PROJECT, PUBSUB_PROJECT_ID or -default-project")
+}
+
+func processConfigString(config string, sourceHint string, host string) {
+	if parsed := parseConfigString(config, sourceHint, host); parsed != nil {
+		queueConfig(parsed)
 	}
 }
 
-func processConfigString(config string, sourceHint string) {
+// parseConfigString parses a config string, returning nil if it is too
+// malformed to apply.
+func parseConfigString(config string, sourceHint string, host string) *projectConfig {
 	configCount++
 
-	// Separate the projectID from the topic definitions.
-	configParts := strings.Split(config, ",")
-	if len(configParts) < 2 {
-		warnf("%s: Expected at least 1 topic to be defined", sourceHint)
-		return
+	parsed, err := pubsubc.ParseConfig(config)
+	if err != nil {
+		logContext{"source", sourceHint}.warnf("%s: %s, skipping the config", sourceHint, err)
+		skippedConfigs++
+		return nil
+	}
+	return &projectConfig{
+		projectID:         parsed.ProjectID,
+		host:              host,
+		topics:            parsed.Topics,
+		sourceHint:        sourceHint,
+		subscriptionsOnly: parsed.SubscriptionsOnly,
+		autoSub:           *autoSub,
 	}
+}
 
-	// Separate the topicID from the subscription IDs.
-	topics := make(Topics)
-	for _, part := range configParts[1:] {
-		topicParts := strings.Split(part, ":")
-		topics[topicParts[0]] = topicParts[1:]
+// queueConfig queues a parsed config to be applied along with every other
+// discovered config.
+func queueConfig(config *projectConfig) {
+	if err := resolveConfigProject(config); err != nil {
+		logContext{"source", config.sourceHint}.warnf("%s: %s", config.sourceHint, err)
+		skippedConfigs++
+		return
 	}
+	pendingConfigs = append(pendingConfigs, config)
+}
 
-	// Create the project and all its topics and subscriptions.
-	if err := create(context.Background(), configParts[0], topics); err != nil {
-		warnf("%s: When creating resources: %s", sourceHint, err.Error())
+// resolveConfigProject replaces the empty or $DEFAULT project of a config
+// with the environment's default.
+func resolveConfigProject(config *projectConfig) error {
+	if config.projectID != "" && config.projectID != "$DEFAULT" {
+		return nil
 	}
+	resolved, source, err := resolveDefaultProject()
+	if err != nil {
+		return err
+	}
+	debugf("Using default project %q from %s for %s", resolved, source, config.sourceHint)
+	config.projectID = resolved
+	return nil
+}
+
+// discoverConfigs queues the configs from every source: the config file and
+// directory, environment variables and Docker labels, then hands out the seed
+// messages of the environment variables.
+func discoverConfigs() {
+	processConfigFile()
+	processConfigDir()
+	processEnvConfig()
+	processDockerLabelConfig()
+	processEnvSeeds()
 }
 
 func processEnvConfig() {
@@ -181,21 +682,63 @@ func processEnvConfig() {
 		if env == "" {
 			break
 		}
-		processConfigString(env, currentEnv)
+		// A companion _HOST variable applies the config to its own emulator.
+		host := os.Getenv(currentEnv + "_HOST")
+		if host != "" {
+			debugf("Using emulator host %s from %s_HOST", host, currentEnv)
+		}
+		config := parseConfigString(env, currentEnv, host)
+		if config == nil {
+			continue
+		}
+		if err := applyEnvSettings(currentEnv, config); err != nil {
+			logContext{"source", currentEnv}.warnf("%s: %s, skipping the config", currentEnv, err)
+			skippedConfigs++
+			continue
+		}
+		queueConfig(config)
 	}
 }
 
 func main() {
+	flag.Var(&postHooks, "post-hook", "Shell command to run after each config applies successfully (may be repeated)")
 	flag.Parse()
 	flag.Usage = func() {
 		fmt.Println()
 		fmt.Println("Configure with environment variables:")
 		fmt.Println(`   PUBSUB_PROJECT1="project1,topic1,topic2:subscription1,topic3:subscription2+endpoint1"`)
+		fmt.Println(`   PUBSUB_PROJECT1_DEFAULTS="ackDeadline=60s,retention=24h,retainAcked=true"`)
+		fmt.Println(`   PUBSUB_PROJECT1_HOST="pubsub-a:8681"`)
+		fmt.Println(`   PUBSUB_SEED1="project1,topic1,<base64 payload>,attribute1=value1"`)
 		fmt.Println()
 		fmt.Println("Configure with Docker labels:")
 		fmt.Println(`   pubsubc.config1="project1,topic1,topic2:subscription1,topic3:subscription2+endpoint1"`)
 		fmt.Println()
-		fmt.Printf(`Usage: %s`+"\n", os.Args[0])
+		fmt.Println("Configure with a YAML or JSON file:")
+		fmt.Println(`   pubsubc -config pubsubc.yaml`)
+		fmt.Println()
+		fmt.Println("Configure with a directory of config strings, one per file:")
+		fmt.Println(`   pubsubc -config-dir /etc/pubsubc`)
+		fmt.Println()
+		fmt.Println("Commands:")
+		fmt.Println("   import           Import topics and subscriptions from Terraform or gcloud output")
+		fmt.Println("   relay            Forward messages from a pull subscription to an HTTP endpoint")
+		fmt.Println("   forward          Republish messages from one topic onto others")
+		fmt.Println("   await-empty      Wait until subscriptions have no undelivered messages")
+		fmt.Println("   stats            Show subscriptions with their approximate backlog")
+		fmt.Println("   proxy            Serve the Pub/Sub API, creating missing topics on demand")
+		fmt.Println("   monitor          Report topic and subscription changes as they happen")
+		fmt.Println("   sync             Copy a project's topics and subscriptions between emulators")
+		fmt.Println("   record           Append messages from a subscription to a JSON lines file")
+		fmt.Println("   delete-resource  Delete a single topic or subscription")
+		fmt.Println("   migrate-sub      Copy a subscription and its settings to a new name")
+		fmt.Println("   ui               Serve a web page listing topics and subscriptions")
+		fmt.Println("   plan             Save the changes the discovered configs need to a plan file")
+		fmt.Println("   show-plan        Summarize a plan file")
+		fmt.Println("   apply            Make exactly the changes in a plan file")
+		fmt.Println("   adopt            Label existing topics and subscriptions as managed by pubsubc")
+		fmt.Println()
+		fmt.Printf(`Usage: %s [flags] [command [command flags]]`+"\n", os.Args[0])
 		flag.PrintDefaults()
 		fmt.Println()
 	}
@@ -210,15 +753,140 @@ func main() {
 		return
 	}
 
-	// Process any ENV variables & Docker labels
-	processEnvConfig()
-	processDockerLabelConfig()
+	configureOutput()
+	configureLogging()
+
+	switch *missingTopic {
+	case "fail", "wait", "create":
+	default:
+		fatalf("Unknown -missing-topic %q, expected fail, wait or create", *missingTopic)
+	}
+	if *concurrency < 1 {
+		fatalf("-concurrency must be at least 1, not %d", *concurrency)
+	}
+	if *retries < 0 {
+		fatalf("-retries can't be negative")
+	}
+	if *labelPrefix == "" {
+		fatalf("-label-prefix can't be empty")
+	}
+	if *watch && *fresh != "" {
+		fatalf("-fresh isn't supported with -watch")
+	}
+	if *watch && *dryRun {
+		fatalf("-dry-run isn't supported with -watch")
+	}
+	if *printConfig && (*dryRun || *watch || *verify || *deleteConfigs || *recreate || *fresh != "" || *reconcileEvery > 0 || *adminListen != "") {
+		fatalf("-print-config can't be combined with -dry-run, -watch, -verify, -delete, -recreate, -fresh, -reconcile-interval or -listen")
+	}
+	if *deleteConfigs && (*recreate || *watch || *dryRun || *fresh != "") {
+		fatalf("-delete can't be combined with -recreate, -watch, -dry-run or -fresh")
+	}
+	if *verify && (*dryRun || *watch || *deleteConfigs || *recreate || *fresh != "" || *reconcileEvery > 0) {
+		fatalf("-verify can't be combined with -dry-run, -watch, -delete, -recreate, -fresh or -reconcile-interval")
+	}
+	if *contentMetricsEvery < 0 {
+		fatalf("-content-metrics-interval can't be negative")
+	}
+	if *watchReconcileEvery < 0 {
+		fatalf("-watch-reconcile-interval can't be negative")
+	}
+	if *reconcileEvery < 0 {
+		fatalf("-reconcile-interval can't be negative")
+	}
+	if *reconcileEvery > 0 && (*watch || *dryRun || *deleteConfigs || *recreate || *fresh != "") {
+		fatalf("-reconcile-interval can't be combined with -watch, -dry-run, -delete, -recreate or -fresh")
+	}
+	if *fixOrphans && *reconcileEvery == 0 {
+		fatalf("-fix-orphans needs -reconcile-interval")
+	}
+	if *adminListen != "" && (*watch || *dryRun || *verify || *deleteConfigs || *fresh != "" || *reconcileEvery > 0) {
+		fatalf("-listen can't be combined with -watch, -dry-run, -verify, -delete, -fresh or -reconcile-interval")
+	}
 
-	// If the discovered config count is zero, print the usage info.
-	if 0 == configCount {
+	configureFirebase()
+	detectEmulator()
+	configureReplicas()
+	configureImpersonation()
+	configureTransport()
+	configureAudit()
+
+	// Run a named subcommand instead of the default config discovery.
+	if flag.NArg() > 0 {
+		command, ok := commands[flag.Arg(0)]
+		if !ok {
+			fatalf("Unknown command %q", flag.Arg(0))
+		}
+		command(flag.Args()[1:])
+		flushAudit()
+		return
+	}
+
+	// Process any ENV variables & Docker labels
+	listenAdmin()
+	discoverConfigs()
+	if *printConfig {
+		runPrintConfig()
+		return
+	}
+	if *dryRun {
+		runDryRun()
+		return
+	}
+	if *verify {
+		runVerifyConfigs()
+		return
+	}
+	if *deleteConfigs || *recreate {
+		if 0 == configCount {
+			fatalf("No Pub/Sub configurations found")
+		}
+		teardownConfigs()
+	}
+	if *deleteConfigs {
+		flushAudit()
+		return
+	}
+	discovered := pendingConfigs
+	before := currentApplyTotals()
+	applyConfigs()
+	pushRunMetrics()
+	flushAudit()
+
+	// If the discovered config count is zero, print the usage info. When
+	// watching or serving the admin API, the configs may still be on their
+	// way.
+	if 0 == configCount && !*watch && *adminListen == "" {
 		fmt.Println("No Pub/Sub configurations found")
 		flag.Usage()
 		os.Exit(1)
 	}
 	fmt.Printf("Found %d Pub/Sub configurations\n", configCount)
+	reportSummary()
+	reportFresh()
+	reportReplicas()
+	reportProbes()
+	applied := reportFailures()
+	delivered := reportDeliveries()
+	smokeTested := reportSmokeTests()
+	if *watch || *adminListen != "" || *reconcileEvery > 0 {
+		// Run as a daemon, which systemd may supervise.
+		ok, status := appliedStatus(len(discovered), before)
+		notifyApplied(ok && applied && smokeTested && delivered, status)
+		startWatchdog()
+		startContentMetrics()
+	}
+	if *watch {
+		watchDockerEvents()
+	}
+	if *adminListen != "" {
+		serveAdmin()
+	}
+	if *reconcileEvery > 0 {
+		reconcileConfigs(discovered)
+		return
+	}
+	if !applied || !smokeTested || !delivered {
+		os.Exit(1)
+	}
 }
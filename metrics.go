@@ -0,0 +1,118 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// resourceCounts tallies what a run did with one type of resource.
+type resourceCounts struct {
+	created atomic.Int64
+	skipped atomic.Int64
+	updated atomic.Int64
+	failed  atomic.Int64
+}
+
+var (
+	runStarted         = time.Now()
+	topicCounts        resourceCounts
+	subscriptionCounts resourceCounts
+	seedCounts         resourceCounts
+	failedConfigs      atomic.Int64
+	timedOutConfigs    atomic.Int64
+
+	// pushMu serializes pushes, and guards runMetrics, the metrics of the
+	// last run.
+	pushMu     sync.Mutex
+	runMetrics string
+)
+
+// pushRunMetrics pushes the run's counters to the -pushgateway-url, if set.
+// A failed push is only a warning; it never changes the outcome of the run.
+func pushRunMetrics() {
+	if *pushgatewayURL == "" {
+		return
+	}
+
+	success := 0
+	if configCount > 0 && failedConfigs.Load() == 0 && timedOutConfigs.Load() == 0 {
+		success = 1
+	}
+
+	var body bytes.Buffer
+	fmt.Fprintln(&body, "# TYPE pubsubc_resources_created gauge")
+	fmt.Fprintf(&body, "pubsubc_resources_created{type=\"topic\"} %d\n", topicCounts.created.Load())
+	fmt.Fprintf(&body, "pubsubc_resources_created{type=\"subscription\"} %d\n", subscriptionCounts.created.Load())
+	fmt.Fprintln(&body, "# TYPE pubsubc_resources_skipped gauge")
+	fmt.Fprintf(&body, "pubsubc_resources_skipped{type=\"topic\"} %d\n", topicCounts.skipped.Load())
+	fmt.Fprintf(&body, "pubsubc_resources_skipped{type=\"subscription\"} %d\n", subscriptionCounts.skipped.Load())
+	fmt.Fprintln(&body, "# TYPE pubsubc_resources_updated gauge")
+	fmt.Fprintf(&body, "pubsubc_resources_updated{type=\"subscription\"} %d\n", subscriptionCounts.updated.Load())
+	fmt.Fprintln(&body, "# TYPE pubsubc_resources_failed gauge")
+	fmt.Fprintf(&body, "pubsubc_resources_failed{type=\"topic\"} %d\n", topicCounts.failed.Load())
+	fmt.Fprintf(&body, "pubsubc_resources_failed{type=\"subscription\"} %d\n", subscriptionCounts.failed.Load())
+	fmt.Fprintln(&body, "# TYPE pubsubc_seed_messages gauge")
+	fmt.Fprintf(&body, "pubsubc_seed_messages{result=\"published\"} %d\n", seedCounts.created.Load())
+	fmt.Fprintf(&body, "pubsubc_seed_messages{result=\"failed\"} %d\n", seedCounts.failed.Load())
+	fmt.Fprintln(&body, "# TYPE pubsubc_resources_declared gauge")
+	fmt.Fprintf(&body, "pubsubc_resources_declared{type=\"topic\"} %d\n", declaredTotals.Topics)
+	fmt.Fprintf(&body, "pubsubc_resources_declared{type=\"subscription\"} %d\n", declaredTotals.Subscriptions)
+	fmt.Fprintln(&body, "# TYPE pubsubc_configs gauge")
+	fmt.Fprintf(&body, "pubsubc_configs %d\n", configCount)
+	fmt.Fprintln(&body, "# TYPE pubsubc_configs_failed gauge")
+	fmt.Fprintf(&body, "pubsubc_configs_failed %d\n", failedConfigs.Load())
+	fmt.Fprintln(&body, "# TYPE pubsubc_configs_timed_out gauge")
+	fmt.Fprintf(&body, "pubsubc_configs_timed_out %d\n", timedOutConfigs.Load())
+	fmt.Fprintln(&body, "# TYPE pubsubc_run_duration_seconds gauge")
+	fmt.Fprintf(&body, "pubsubc_run_duration_seconds %g\n", time.Since(runStarted).Seconds())
+	fmt.Fprintln(&body, "# TYPE pubsubc_run_success gauge")
+	fmt.Fprintf(&body, "pubsubc_run_success %d\n", success)
+	fmt.Fprintln(&body, "# TYPE pubsubc_run_last_completed_timestamp_seconds gauge")
+	fmt.Fprintf(&body, "pubsubc_run_last_completed_timestamp_seconds %d\n", time.Now().Unix())
+
+	pushMu.Lock()
+	runMetrics = body.String()
+	pushMu.Unlock()
+	pushMetrics()
+}
+
+// pushMetrics pushes the metrics of the last run, and the content metrics, to
+// the -pushgateway-url.
+func pushMetrics() {
+	pushMu.Lock()
+	defer pushMu.Unlock()
+	body := bytes.NewBufferString(runMetrics)
+	writeContentMetrics(body)
+
+	// PUT replaces every metric previously pushed for the same job and
+	// instance, so stale counters from an earlier run don't linger.
+	target := strings.TrimRight(*pushgatewayURL, "/") + "/metrics/job/" + url.PathEscape(*pushgatewayJob)
+	if *pushgatewayInstance != "" {
+		target += "/instance/" + url.PathEscape(*pushgatewayInstance)
+	}
+	req, err := http.NewRequest(http.MethodPut, target, body)
+	if err != nil {
+		warnf("Unable to push metrics to %s: %s", target, err)
+		return
+	}
+	req.Header.Set("Content-Type", "text/plain; version=0.0.4")
+
+	httpClient := &http.Client{Timeout: 10 * time.Second}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		warnf("Unable to push metrics to %s: %s", target, err)
+		return
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		warnf("Unable to push metrics to %s: HTTP %d", target, resp.StatusCode)
+		return
+	}
+	debugf("Pushed metrics to %s", target)
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// runMigrateSub creates a copy of a subscription under a new name, with the
+// same topic and settings, optionally deleting the original.
+func runMigrateSub(args []string) {
+	flags := flag.NewFlagSet("migrate-sub", flag.ExitOnError)
+	projectID := flags.String("project", "", "Project ID of the subscription")
+	from := flags.String("from", "", "Existing subscription to copy")
+	to := flags.String("to", "", "New subscription to create")
+	seek := flags.String("seek", "", "Position the new subscription: 'now' skips older messages, 'snapshot' copies the old subscription's backlog")
+	deleteOld := flags.Bool("delete-old", false, "Delete the old subscription once the new one exists")
+	flags.Parse(args)
+
+	if *projectID == "" || *from == "" || *to == "" {
+		flags.Usage()
+		fatalf("-project, -from and -to are required")
+	}
+	if *seek != "" && *seek != "now" && *seek != "snapshot" {
+		fatalf("-seek must be 'now' or 'snapshot', got %q", *seek)
+	}
+	requireEmulator("migrate-sub")
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	client, err := newClient(ctx, *projectID, "")
+	if err != nil {
+		fatalf("Unable to create client to project %q: %s", *projectID, err)
+	}
+
+	old := client.Subscription(*from)
+	config, err := old.Config(ctx)
+	if err != nil {
+		fatalf("Unable to fetch subscription %q for project %q: %s", *from, *projectID, err)
+	}
+	if subscriptionTopic(config.Topic) == deletedTopic {
+		fatalf("Subscription %q is detached from its topic and can't be migrated", *from)
+	}
+
+	// Snapshot before creating the new subscription, so no message published
+	// in between is missed.
+	snapshotID := fmt.Sprintf("pubsubc-migrate-%s-%d", *from, time.Now().Unix())
+	if *seek == "snapshot" {
+		debugf("Creating snapshot %q of subscription %q", snapshotID, *from)
+		if _, err := old.CreateSnapshot(ctx, snapshotID); err != nil {
+			fatalf("Unable to snapshot subscription %q for project %q: %s", *from, *projectID, err)
+		}
+	}
+	deleteSnapshot := func() {
+		if *seek != "snapshot" {
+			return
+		}
+		if err := client.Snapshot(snapshotID).Delete(context.Background()); err != nil {
+			warnf("Unable to delete snapshot %q: %s", snapshotID, err)
+		}
+	}
+
+	subscription, err := client.CreateSubscription(ctx, *to, config)
+	if err != nil {
+		deleteSnapshot()
+		if status.Code(err) == codes.AlreadyExists {
+			fatalf("Subscription %q already exists in project %q", *to, *projectID)
+		}
+		fatalf("Unable to create subscription %q on topic %q for project %q: %s", *to, config.Topic.ID(), *projectID, err)
+	}
+	fmt.Printf("Created subscription %q on topic %q with the settings of %q\n", *to, config.Topic.ID(), *from)
+	audit(auditEvent{Action: "created", Kind: "subscription", Project: *projectID, Name: *to, Source: "migrate-sub"})
+
+	switch *seek {
+	case "now":
+		err = subscription.SeekToTime(ctx, time.Now())
+	case "snapshot":
+		err = subscription.SeekToSnapshot(ctx, client.Snapshot(snapshotID))
+	}
+	deleteSnapshot()
+	if err != nil {
+		fatalf("Unable to seek subscription %q: %s", *to, err)
+	}
+
+	if *deleteOld {
+		if err := old.Delete(ctx); err != nil {
+			fatalf("Unable to delete subscription %q for project %q: %s", *from, *projectID, err)
+		}
+		fmt.Printf("Deleted subscription %q\n", *from)
+		audit(auditEvent{Action: "deleted", Kind: "subscription", Project: *projectID, Name: *from, Source: "migrate-sub"})
+	}
+}
@@ -0,0 +1,205 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"sort"
+	"syscall"
+	"time"
+
+	"cloud.google.com/go/pubsub"
+	"google.golang.org/api/iterator"
+)
+
+// subscriptionState is the part of a subscription's config that monitor
+// tracks.
+type subscriptionState struct {
+	topic        string
+	pushEndpoint string
+}
+
+// topologySnapshot is the set of topics and subscriptions in a project at one
+// point in time.
+type topologySnapshot struct {
+	topics        map[string]bool
+	subscriptions map[string]subscriptionState
+}
+
+// topologyEvent describes a single change between two snapshots.
+type topologyEvent struct {
+	Time         time.Time `json:"time"`
+	Event        string    `json:"event"`
+	Topic        string    `json:"topic,omitempty"`
+	Subscription string    `json:"subscription,omitempty"`
+	Detail       string    `json:"detail,omitempty"`
+}
+
+func (e topologyEvent) String() string {
+	var subject string
+	switch {
+	case e.Subscription != "":
+		subject = fmt.Sprintf("subscription %s", e.Subscription)
+	case e.Topic != "":
+		subject = fmt.Sprintf("topic %s", e.Topic)
+	default:
+		subject = "emulator"
+	}
+	message := fmt.Sprintf("%s %s %s", e.Time.Format(time.RFC3339), subject, e.Event)
+	if e.Detail != "" {
+		message += ": " + e.Detail
+	}
+	return message
+}
+
+// listTopology takes a snapshot of a project's topics and subscriptions.
+func listTopology(ctx context.Context, client *pubsub.Client) (topologySnapshot, error) {
+	snapshot := topologySnapshot{topics: make(map[string]bool), subscriptions: make(map[string]subscriptionState)}
+
+	topics := client.Topics(ctx)
+	for {
+		topic, err := topics.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return snapshot, fmt.Errorf("Unable to list topics: %s", err)
+		}
+		snapshot.topics[topic.ID()] = true
+	}
+
+	subscriptions := client.Subscriptions(ctx)
+	for {
+		config, err := subscriptions.NextConfig()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return snapshot, fmt.Errorf("Unable to list subscriptions: %s", err)
+		}
+		state := subscriptionState{topic: subscriptionTopic(config.Topic), pushEndpoint: config.PushConfig.Endpoint}
+		snapshot.subscriptions[config.ID()] = state
+	}
+	return snapshot, nil
+}
+
+// diffTopology returns the events that turn one snapshot into the next, in a
+// stable order.
+func diffTopology(previous, current topologySnapshot, now time.Time) []topologyEvent {
+	var events []topologyEvent
+
+	for _, topicID := range sortedKeys(current.topics) {
+		if !previous.topics[topicID] {
+			events = append(events, topologyEvent{Time: now, Event: "created", Topic: topicID})
+		}
+	}
+	for _, topicID := range sortedKeys(previous.topics) {
+		if !current.topics[topicID] {
+			events = append(events, topologyEvent{Time: now, Event: "deleted", Topic: topicID})
+		}
+	}
+
+	for _, subscriptionID := range sortedKeys(current.subscriptions) {
+		state := current.subscriptions[subscriptionID]
+		before, existed := previous.subscriptions[subscriptionID]
+		switch {
+		case !existed:
+			events = append(events, topologyEvent{Time: now, Event: "created", Topic: state.topic, Subscription: subscriptionID})
+		case before.topic != state.topic:
+			events = append(events, topologyEvent{Time: now, Event: "topic changed", Topic: state.topic, Subscription: subscriptionID, Detail: fmt.Sprintf("%s -> %s", before.topic, state.topic)})
+		case before.pushEndpoint != state.pushEndpoint:
+			events = append(events, topologyEvent{Time: now, Event: "push endpoint changed", Topic: state.topic, Subscription: subscriptionID, Detail: fmt.Sprintf("%q -> %q", before.pushEndpoint, state.pushEndpoint)})
+		}
+	}
+	for _, subscriptionID := range sortedKeys(previous.subscriptions) {
+		if _, ok := current.subscriptions[subscriptionID]; !ok {
+			events = append(events, topologyEvent{Time: now, Event: "deleted", Topic: previous.subscriptions[subscriptionID].topic, Subscription: subscriptionID})
+		}
+	}
+	return events
+}
+
+// sortedKeys returns the keys of a map in order.
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// runMonitor prints topology changes in a project as they happen, until
+// interrupted. It never creates or modifies anything.
+func runMonitor(args []string) {
+	flags := flag.NewFlagSet("monitor", flag.ExitOnError)
+	projectID := flags.String("project", "", "Project ID to monitor")
+	interval := flags.Duration("interval", 5*time.Second, "How often to take a snapshot")
+	jsonOutput := flags.Bool("json", false, "Print events as JSON lines")
+	flags.Parse(args)
+
+	if *projectID == "" {
+		flags.Usage()
+		fatalf("-project is required")
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	client, err := newClient(ctx, *projectID, "")
+	if err != nil {
+		fatalf("Unable to create client to project %q: %s", *projectID, err)
+	}
+
+	emit := func(event topologyEvent) {
+		if *jsonOutput {
+			json.NewEncoder(os.Stdout).Encode(event)
+		} else {
+			fmt.Println(event)
+		}
+	}
+
+	var previous *topologySnapshot
+	unavailable := false
+	ticker := time.NewTicker(*interval)
+	defer ticker.Stop()
+	for {
+		snapshotCtx, cancel := context.WithTimeout(ctx, *interval)
+		snapshot, err := listTopology(snapshotCtx, client)
+		cancel()
+		now := time.Now().UTC()
+		switch {
+		case ctx.Err() != nil:
+			return
+		case err != nil:
+			// Mark a gap rather than exiting, and keep diffing against the
+			// last good snapshot once the emulator is back.
+			if !unavailable {
+				emit(topologyEvent{Time: now, Event: "unavailable", Detail: err.Error()})
+				unavailable = true
+			}
+		default:
+			if unavailable {
+				emit(topologyEvent{Time: now, Event: "available again"})
+				unavailable = false
+			}
+			if previous == nil {
+				fmt.Fprintf(os.Stderr, "Monitoring project %q: %d topics, %d subscriptions\n", *projectID, len(snapshot.topics), len(snapshot.subscriptions))
+			} else {
+				for _, event := range diffTopology(*previous, snapshot, now) {
+					emit(event)
+				}
+			}
+			previous = &snapshot
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"time"
+)
+
+// notifiedReady is set once systemd has been told pubsubc is ready.
+var notifiedReady bool
+
+// sdNotify sends state, such as READY=1, to the systemd service manager
+// through NOTIFY_SOCKET, for services with Type=notify. It does nothing when
+// NOTIFY_SOCKET isn't set, and failing to notify is only logged.
+func sdNotify(state string) {
+	socket := os.Getenv("NOTIFY_SOCKET")
+	if socket == "" {
+		return
+	}
+	// A leading @ is an abstract socket, which Go dials as such.
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: socket, Net: "unixgram"})
+	if err != nil {
+		debugf("Unable to notify systemd: %s", err)
+		return
+	}
+	defer conn.Close()
+	if _, err := conn.Write([]byte(state)); err != nil {
+		debugf("Unable to notify systemd: %s", err)
+	}
+}
+
+// notifyApplied tells systemd the outcome of applying configs, as status,
+// and that pubsubc is ready the first time it applied them without failures.
+func notifyApplied(ok bool, status string) {
+	state := "STATUS=" + status
+	if ok && !notifiedReady {
+		notifiedReady = true
+		state = "READY=1\n" + state
+	}
+	sdNotify(state)
+}
+
+// startWatchdog pings systemd at half the WatchdogSec interval it passes in
+// WATCHDOG_USEC, if any, for as long as pubsubc runs.
+func startWatchdog() {
+	usec, err := strconv.ParseInt(os.Getenv("WATCHDOG_USEC"), 10, 64)
+	if err != nil || usec <= 0 || os.Getenv("NOTIFY_SOCKET") == "" {
+		return
+	}
+	// The watchdog may be meant for another process.
+	if pid := os.Getenv("WATCHDOG_PID"); pid != "" && pid != strconv.Itoa(os.Getpid()) {
+		return
+	}
+	interval := time.Duration(usec) * time.Microsecond / 2
+	debugf("Pinging the systemd watchdog every %s", interval)
+	go func() {
+		for range time.Tick(interval) {
+			sdNotify("WATCHDOG=1")
+		}
+	}()
+}
+
+// appliedStatus summarizes the configs applied since the counts were
+// taken, for notifyApplied, returning whether none failed.
+func appliedStatus(configs int, before applyTotals) (bool, string) {
+	delta := currentApplyTotals().minus(before)
+	return delta.failed == 0, fmt.Sprintf("Applied %d Pub/Sub configurations: %d created, %d already present, %d updated, %d failed",
+		configs, delta.created, delta.present, delta.updated, delta.failed)
+}
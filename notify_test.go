@@ -0,0 +1,57 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// listenNotify listens on a notify socket named name, returning a function
+// that reads the next state sent to it.
+func listenNotify(t *testing.T, name string) func() string {
+	t.Helper()
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: name, Net: "unixgram"})
+	if err != nil {
+		t.Fatalf("Unable to listen on %s: %s", name, err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	t.Setenv("NOTIFY_SOCKET", name)
+	return func() string {
+		conn.SetReadDeadline(time.Now().Add(time.Second))
+		buf := make([]byte, 256)
+		n, err := conn.Read(buf)
+		if err != nil {
+			t.Fatalf("No state sent: %s", err)
+		}
+		return string(buf[:n])
+	}
+}
+
+func TestNotifyApplied(t *testing.T) {
+	read := listenNotify(t, filepath.Join(t.TempDir(), "notify"))
+	t.Cleanup(func() { notifiedReady = false })
+
+	notifyApplied(false, "1 failed")
+	if got := read(); got != "STATUS=1 failed" {
+		t.Errorf("notifyApplied() after a failure sent %q, want only the status", got)
+	}
+	notifyApplied(true, "0 failed")
+	if got := read(); got != "READY=1\nSTATUS=0 failed" {
+		t.Errorf("notifyApplied() after the first success sent %q, want READY=1 and the status", got)
+	}
+	notifyApplied(true, "0 failed")
+	if got := read(); got != "STATUS=0 failed" {
+		t.Errorf("notifyApplied() once ready sent %q, want only the status", got)
+	}
+}
+
+func TestSDNotifyAbstractSocket(t *testing.T) {
+	read := listenNotify(t, fmt.Sprintf("@pubsubc-test-%d", os.Getpid()))
+	sdNotify("STOPPING=1")
+	if got := read(); got != "STOPPING=1" {
+		t.Errorf("sdNotify() sent %q to an abstract socket, want STOPPING=1", got)
+	}
+}
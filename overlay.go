@@ -0,0 +1,111 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"path"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+var overlayName = flag.String("overlay", "", "Overlay of the config file to apply, overriding its push endpoints and emulator hosts")
+
+// fileOverlay overrides the push endpoints of the subscriptions, and the
+// emulator hosts of the projects, of a config file, when selected with
+// -overlay:
+//
+//	overlays:
+//	  ci:
+//	    hosts:
+//	      my-project: pubsub:8085
+//	    pushEndpoints:
+//	      orders-push: http://worker:8080/push
+//	      "*-push": http://ci-sink:8080/push
+type fileOverlay struct {
+	Hosts         overrides `yaml:"hosts,omitempty"`
+	PushEndpoints overrides `yaml:"pushEndpoints,omitempty"`
+}
+
+// overrides maps IDs, or globs matching them, to values, in the order given.
+type overrides []override
+
+type override struct {
+	pattern, value string
+}
+
+func (o *fileOverlay) UnmarshalYAML(node *yaml.Node) error {
+	type plain fileOverlay
+	return decodeMapping(node, "overlay", (*plain)(o), "hosts", "pushEndpoints")
+}
+
+func (o *overrides) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind != yaml.MappingNode {
+		return fmt.Errorf("line %d: expected a mapping of IDs or globs to values", node.Line)
+	}
+	for i := 0; i < len(node.Content); i += 2 {
+		key, value := node.Content[i], node.Content[i+1]
+		if value.Kind != yaml.ScalarNode {
+			return fmt.Errorf("line %d: expected a value for %q", value.Line, key.Value)
+		}
+		if _, err := path.Match(key.Value, ""); err != nil {
+			return fmt.Errorf("line %d: invalid glob %q: %s", key.Line, key.Value, err)
+		}
+		*o = append(*o, override{pattern: key.Value, value: value.Value})
+	}
+	return nil
+}
+
+// lookup returns the value for id: the one given for it by name, or else the
+// first one whose glob matches it.
+func (o overrides) lookup(id string) (string, bool) {
+	for _, override := range o {
+		if override.pattern == id {
+			return override.value, true
+		}
+	}
+	for _, override := range o {
+		if matched, _ := path.Match(override.pattern, id); matched {
+			return override.value, true
+		}
+	}
+	return "", false
+}
+
+// applyOverlay overrides the push endpoints and hosts of the projects of a
+// config file with those of its overlay name. Subscriptions and projects the
+// overlay doesn't match are left as they are.
+func (c *fileConfig) applyOverlay(name string) error {
+	overlay, ok := c.Overlays[name]
+	if !ok {
+		if len(c.Overlays) == 0 {
+			return fmt.Errorf("overlay %q isn't defined, as there are no overlays", name)
+		}
+		return fmt.Errorf("overlay %q isn't defined, expected one of %s", name, strings.Join(sortedKeys(c.Overlays), ", "))
+	}
+	for i := range c.Projects {
+		project := &c.Projects[i]
+		if host, ok := overlay.Hosts.lookup(project.ID); ok {
+			debugf("Overlay %q sets the host of project %q to %q", name, project.ID, host)
+			project.Host = host
+		}
+		for _, topic := range project.Topics {
+			for j := range topic.Subscriptions {
+				subscription := &topic.Subscriptions[j]
+				endpoint, ok := overlay.PushEndpoints.lookup(subscription.ID)
+				if !ok {
+					continue
+				}
+				if subscription.BigQueryTable != "" {
+					return fmt.Errorf("line %d: overlay %q gives BigQuery subscription %q a push endpoint", subscription.line, name, subscription.ID)
+				}
+				if endpoint == "" && subscription.PushServiceAccount != "" {
+					return fmt.Errorf("line %d: overlay %q removes the push endpoint of subscription %q, which has a pushServiceAccount", subscription.line, name, subscription.ID)
+				}
+				debugf("Overlay %q sets the push endpoint of subscription %q to %q", name, subscription.ID, endpoint)
+				subscription.PushEndpoint = endpoint
+			}
+		}
+	}
+	return nil
+}
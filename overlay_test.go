@@ -0,0 +1,63 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+const overlayConfig = `
+projects:
+  - id: p
+    topics:
+      - id: orders
+        subscriptions:
+          - id: orders-push
+            pushEndpoint: http://localhost:8080/push
+          - id: audit-push
+            pushEndpoint: http://localhost:9090/push
+          - id: other
+            pushEndpoint: http://localhost:7070/push
+overlays:
+  ci:
+    hosts:
+      p: pubsub:8085
+    pushEndpoints:
+      "*-push": http://sink:8080/push
+      orders-push: http://worker:8080/push
+  laptop: {}
+`
+
+func TestApplyOverlay(t *testing.T) {
+	config, err := parseConfigFile([]byte(overlayConfig), "c.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := config.applyOverlay("ci"); err != nil {
+		t.Fatalf("applyOverlay(ci) = %v", err)
+	}
+	project := config.Projects[0]
+	if project.Host != "pubsub:8085" {
+		t.Errorf("applyOverlay(ci) set host %q, want pubsub:8085", project.Host)
+	}
+	want := map[string]string{
+		"orders-push": "http://worker:8080/push",
+		"audit-push":  "http://sink:8080/push",
+		"other":       "http://localhost:7070/push",
+	}
+	for _, subscription := range project.Topics[0].Subscriptions {
+		if subscription.PushEndpoint != want[subscription.ID] {
+			t.Errorf("applyOverlay(ci) set %s to %q, want %q", subscription.ID, subscription.PushEndpoint, want[subscription.ID])
+		}
+	}
+}
+
+func TestApplyUndefinedOverlay(t *testing.T) {
+	config, err := parseConfigFile([]byte(overlayConfig), "c.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = config.applyOverlay("staging")
+	if err == nil || !strings.Contains(err.Error(), "expected one of ci, laptop") {
+		t.Errorf("applyOverlay(staging) = %v, want an error listing ci and laptop", err)
+	}
+}
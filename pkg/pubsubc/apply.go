@@ -0,0 +1,521 @@
+package pubsubc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"cloud.google.com/go/pubsub"
+	"google.golang.org/api/option"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+// Option configures Apply.
+type Option func(*options)
+
+type options struct {
+	host                string
+	client              *pubsub.Client
+	clientOptions       []option.ClientOption
+	topology            Topology
+	autoSub             bool
+	autoSubDefaults     SubscriptionDefaults
+	retries             int
+	slots               chan struct{}
+	missingTopic        MissingTopic
+	missingTopicTimeout time.Duration
+	log                 func(keysAndValues []any, format string, params ...interface{})
+	observe             func(Event)
+}
+
+func newOptions(opts []Option) *options {
+	o := &options{
+		slots:               make(chan struct{}, 1),
+		missingTopicTimeout: time.Minute,
+		log:                 func([]any, string, ...interface{}) {},
+		observe:             func(Event) {},
+	}
+	for _, opt := range opts {
+		opt(o)
+	}
+	return o
+}
+
+// WithEmulatorHost applies the config to the emulator at host, rather than
+// the one PUBSUB_EMULATOR_HOST names.
+func WithEmulatorHost(host string) Option {
+	return func(o *options) { o.host = host }
+}
+
+// WithClient applies the config with an existing client, which must be for
+// the config's project. The client is left open.
+func WithClient(client *pubsub.Client) Option {
+	return func(o *options) { o.client = client }
+}
+
+// WithClientOptions passes options to the client Apply creates, such as
+// credentials for the real Pub/Sub service.
+func WithClientOptions(clientOptions ...option.ClientOption) Option {
+	return func(o *options) { o.clientOptions = append(o.clientOptions, clientOptions...) }
+}
+
+// WithTopology applies the config through topology instead of a client.
+func WithTopology(topology Topology) Option {
+	return func(o *options) { o.topology = topology }
+}
+
+// WithAutoSub creates a <topic>-sub pull subscription for every topic
+// declared without any subscriptions.
+func WithAutoSub() Option {
+	return func(o *options) { o.autoSub = true }
+}
+
+// WithAutoSubDefaults gives the subscriptions WithAutoSub creates the
+// settings of defaults.
+func WithAutoSubDefaults(defaults SubscriptionDefaults) Option {
+	return func(o *options) { o.autoSubDefaults = defaults }
+}
+
+// WithRetries retries a request that fails because Pub/Sub is unavailable or
+// slow to answer up to retries times, with exponential backoff.
+func WithRetries(retries int) Option {
+	return func(o *options) { o.retries = retries }
+}
+
+// WithConcurrency creates up to n topics or subscriptions at once. The
+// default of 1 creates them one at a time, in the order they are declared.
+func WithConcurrency(n int) Option {
+	return func(o *options) {
+		if n < 1 {
+			n = 1
+		}
+		o.slots = make(chan struct{}, n)
+	}
+}
+
+// WithRequestSlots creates a topic or subscription only while holding one of
+// the slots, so that several configs applied at once can share a limit. A
+// single slot creates them in the order they are declared.
+func WithRequestSlots(slots chan struct{}) Option {
+	return func(o *options) { o.slots = slots }
+}
+
+// MissingTopic is what Apply does when a topic of a subscriptions-only
+// config doesn't exist.
+type MissingTopic int
+
+const (
+	// MissingTopicFail fails the topic's subscriptions.
+	MissingTopicFail MissingTopic = iota
+	// MissingTopicWait waits for the topic to be created by someone else.
+	MissingTopicWait
+	// MissingTopicCreate creates the topic.
+	MissingTopicCreate
+)
+
+// WithMissingTopic sets what to do when a topic of a subscriptions-only
+// config doesn't exist, and how long MissingTopicWait waits for each.
+func WithMissingTopic(missingTopic MissingTopic, timeout time.Duration) Option {
+	return func(o *options) {
+		o.missingTopic = missingTopic
+		o.missingTopicTimeout = timeout
+	}
+}
+
+// WithLogger reports each step Apply takes to logf.
+func WithLogger(logf func(format string, params ...interface{})) Option {
+	return func(o *options) {
+		o.log = func(_ []any, format string, params ...interface{}) { logf(format, params...) }
+	}
+}
+
+// WithFieldLogger reports each step Apply takes to log, along with the
+// topic and subscription it is about as alternating keys and values.
+func WithFieldLogger(log func(keysAndValues []any, format string, params ...interface{})) Option {
+	return func(o *options) { o.log = log }
+}
+
+// WithObserver tells observe what became of every topic and subscription.
+// It may be called from several goroutines at once.
+func WithObserver(observe func(Event)) Option {
+	return func(o *options) { o.observe = observe }
+}
+
+// Result is what became of a topic or subscription.
+type Result int
+
+const (
+	Created Result = iota
+	Existing
+	// Updated is an existing subscription whose push endpoint was changed.
+	Updated
+	Failed
+)
+
+// Event reports what became of a topic, or of a Subscription on it. Auto
+// marks the subscription WithAutoSub creates, and Err is why it Failed.
+type Event struct {
+	Topic        string
+	Subscription *Subscription
+	Auto         bool
+	Result       Result
+	Err          error
+}
+
+// Apply creates the topics and then the subscriptions of a config, leaving
+// those that already exist alone, labels included, except that a
+// subscription's push endpoint is updated if it differs from the config. The
+// topics of a subscriptions-only config must already exist. A topic or
+// subscription that fails doesn't stop the rest, apart from the
+// subscriptions of a failed topic; every failure is returned joined, each a
+// *ResourceError.
+func Apply(ctx context.Context, config ProjectConfig, opts ...Option) error {
+	o := newOptions(opts)
+	projectID := config.ProjectID
+	if projectID == "" || projectID == "$DEFAULT" {
+		return fmt.Errorf("No project given")
+	}
+
+	topology := o.topology
+	if topology == nil {
+		client := o.client
+		if client == nil {
+			var err error
+			client, err = o.newClient(ctx, projectID)
+			if err != nil {
+				return fmt.Errorf("Unable to create client to project %q: %s", projectID, err)
+			}
+			defer client.Close()
+		}
+		topology = NewTopology(client)
+	}
+
+	applier := NewApplier(config, topology, opts...)
+	return errors.Join(append(applier.createTopics(ctx), applier.createSubscriptions(ctx)...)...)
+}
+
+// Applier applies a config in two steps, so that several configs can be
+// applied together with every topic created before any subscription, as a
+// subscription may refer to the topic of another config.
+type Applier struct {
+	config   ProjectConfig
+	topology Topology
+	o        *options
+
+	mu            sync.Mutex
+	failedTopics  map[string]bool
+	createdTopics map[string]bool
+}
+
+// NewApplier returns an Applier of a config through topology. The options
+// are those of Apply, apart from those choosing a client.
+func NewApplier(config ProjectConfig, topology Topology, opts ...Option) *Applier {
+	o := newOptions(opts)
+	if o.retries > 0 {
+		topology = retryingTopology{topology, o}
+	}
+	return &Applier{
+		config:        config,
+		topology:      topology,
+		o:             o,
+		failedTopics:  make(map[string]bool),
+		createdTopics: make(map[string]bool),
+	}
+}
+
+// TopicCreated reports whether a topic didn't exist before it was created.
+func (a *Applier) TopicCreated(topicID string) bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.createdTopics[topicID]
+}
+
+// TopicFailed reports whether a topic couldn't be created, or found, so that
+// its subscriptions aren't created.
+func (a *Applier) TopicFailed(topicID string) bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.failedTopics[topicID]
+}
+
+func (a *Applier) markTopic(topics map[string]bool, topicID string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	topics[topicID] = true
+}
+
+// CreateTopics creates the topics of the config that don't already exist. A
+// subscriptions-only config's topics are left for CreateSubscriptions to
+// look up.
+func (a *Applier) CreateTopics(ctx context.Context) error {
+	return errors.Join(a.createTopics(ctx)...)
+}
+
+func (a *Applier) createTopics(ctx context.Context) []error {
+	if a.config.SubscriptionsOnly {
+		return nil
+	}
+	projectID, entries := a.config.ProjectID, a.config.Topics
+	return a.forEach(len(entries), func(i int) error {
+		entry := entries[i]
+		fields := []any{"topic", entry.ID}
+		a.o.log(fields, "Checking for existing topic %q", entry.ID)
+		exists, err := a.topology.TopicExists(ctx, entry.ID)
+		if err != nil {
+			return a.topicFailed(entry.ID, fmt.Errorf("Failed to check existence of topic %q for project %q: %s", entry.ID, projectID, err))
+		}
+		if exists {
+			a.o.log(fields, "Topic %q already exists", entry.ID)
+			a.o.observe(Event{Topic: entry.ID, Result: Existing})
+			return nil
+		}
+
+		a.o.log(fields, "Creating topic %q%s", entry.ID, topicNote(entry))
+		err = a.topology.CreateTopic(ctx, entry)
+		if isAlreadyExists(err) {
+			// Another config declaring the same topic got there first.
+			a.o.log(fields, "Topic %q already exists", entry.ID)
+			a.o.observe(Event{Topic: entry.ID, Result: Existing})
+			return nil
+		}
+		if err != nil {
+			return a.topicFailed(entry.ID, fmt.Errorf("Unable to create topic %q for project %q: %s", entry.ID, projectID, err))
+		}
+		a.markTopic(a.createdTopics, entry.ID)
+		a.o.observe(Event{Topic: entry.ID, Result: Created})
+		return nil
+	})
+}
+
+// topicFailed records that a topic failed, returning why.
+func (a *Applier) topicFailed(topicID string, err error) error {
+	a.markTopic(a.failedTopics, topicID)
+	a.o.observe(Event{Topic: topicID, Result: Failed, Err: err})
+	return &ResourceError{Topic: topicID, Err: err}
+}
+
+// awaitTopic checks that a topic referenced by a subscriptions-only config
+// exists, handling a missing one as WithMissingTopic says.
+func (a *Applier) awaitTopic(ctx context.Context, topicID string) error {
+	projectID := a.config.ProjectID
+	fields := []any{"topic", topicID}
+	deadline := time.Now().Add(a.o.missingTopicTimeout)
+	for {
+		exists, err := a.topology.TopicExists(ctx, topicID)
+		if err != nil {
+			return fmt.Errorf("Failed to check existence of topic %q for project %q: %s", topicID, projectID, err)
+		}
+		if exists {
+			return nil
+		}
+
+		switch {
+		case a.o.missingTopic == MissingTopicCreate:
+			a.o.log(fields, "Creating missing topic %q", topicID)
+			if err := a.topology.CreateTopic(ctx, Topic{ID: topicID}); err != nil {
+				err = fmt.Errorf("Unable to create topic %q for project %q: %s", topicID, projectID, err)
+				a.o.observe(Event{Topic: topicID, Result: Failed, Err: err})
+				return err
+			}
+			a.markTopic(a.createdTopics, topicID)
+			a.o.observe(Event{Topic: topicID, Result: Created})
+			return nil
+		case a.o.missingTopic == MissingTopicWait && time.Now().Before(deadline):
+			a.o.log(fields, "Waiting for topic %q", topicID)
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(time.Second):
+			}
+		case a.o.missingTopic == MissingTopicWait:
+			return fmt.Errorf("Topic %q for project %q still doesn't exist after %s", topicID, projectID, a.o.missingTopicTimeout)
+		default:
+			return fmt.Errorf("Topic %q for project %q doesn't exist, and the config only declares subscriptions", topicID, projectID)
+		}
+	}
+}
+
+// CreateSubscriptions creates the subscriptions of every topic of the config.
+// The topics must already exist, and the subscriptions of those that failed
+// are left out.
+func (a *Applier) CreateSubscriptions(ctx context.Context) error {
+	return errors.Join(a.createSubscriptions(ctx)...)
+}
+
+func (a *Applier) createSubscriptions(ctx context.Context) []error {
+	entries := a.config.Topics
+	var errs []error
+	if a.config.SubscriptionsOnly {
+		errs = a.forEach(len(entries), func(i int) error {
+			if err := a.awaitTopic(ctx, entries[i].ID); err != nil {
+				a.markTopic(a.failedTopics, entries[i].ID)
+				return &ResourceError{Topic: entries[i].ID, Err: err}
+			}
+			return nil
+		})
+	}
+
+	type subscriptionJob struct {
+		topicID      string
+		subscription Subscription
+		auto         bool
+	}
+	var jobs []subscriptionJob
+	for _, entry := range entries {
+		if a.TopicFailed(entry.ID) {
+			continue
+		}
+		if len(entry.Subscriptions) == 0 && a.o.autoSub {
+			subscription := a.o.autoSubDefaults.Apply(Subscription{ID: entry.ID + "-sub"})
+			jobs = append(jobs, subscriptionJob{entry.ID, subscription, true})
+		}
+		for _, subscription := range entry.Subscriptions {
+			jobs = append(jobs, subscriptionJob{entry.ID, subscription, false})
+		}
+	}
+
+	return append(errs, a.forEach(len(jobs), func(i int) error {
+		job := jobs[i]
+		if err := a.createSubscription(ctx, job.topicID, job.subscription, job.auto); err != nil {
+			a.o.observe(Event{Topic: job.topicID, Subscription: &job.subscription, Auto: job.auto, Result: Failed, Err: err})
+			return &ResourceError{Topic: job.topicID, Subscription: job.subscription.ID, Err: err}
+		}
+		return nil
+	})...)
+}
+
+// createSubscription creates a subscription on a topic unless it already
+// exists. auto marks the subscription WithAutoSub creates.
+func (a *Applier) createSubscription(ctx context.Context, topicID string, subscription Subscription, auto bool) error {
+	projectID := a.config.ProjectID
+	subscriptionID, pushEndpoint := subscription.ID, subscription.PushEndpoint
+	fields := []any{"topic", topicID, "subscription", subscriptionID}
+	exists, err := a.existingSubscription(ctx, topicID, subscription, auto)
+	if err != nil || exists {
+		return err
+	}
+
+	switch {
+	case auto:
+		a.o.log(fields, "Creating auto-generated pull subscription %q", subscriptionID)
+		err = a.topology.CreateSubscription(ctx, topicID, subscription)
+		if err != nil && !isAlreadyExists(err) {
+			err = fmt.Errorf("Unable to create auto-generated subscription %q on topic %q for project %q: %s", subscriptionID, topicID, projectID, err)
+		}
+	case subscription.BigQueryTable != "":
+		a.o.log(fields, "Creating BigQuery subscription %q writing to table %s%s", subscriptionID, DescribeBigQuery(subscription), creationNote(subscription))
+		err = a.topology.CreateSubscription(ctx, topicID, subscription)
+		if err != nil && !isAlreadyExists(err) {
+			err = fmt.Errorf("Unable to create BigQuery subscription %q on topic %q for project %q writing to table %s: %s", subscriptionID, topicID, projectID, DescribeBigQuery(subscription), err)
+		}
+	case pushEndpoint != "" && subscription.PushServiceAccount != "":
+		a.o.log(fields, "Creating push subscription %q with target %q%s and OIDC token for %s", subscriptionID, pushEndpoint, creationNote(subscription), DescribePushAuth(subscription))
+		err = a.topology.CreateSubscription(ctx, topicID, subscription)
+		if err != nil && !isAlreadyExists(err) {
+			err = fmt.Errorf("Unable to create push subscription %q on topic %q for project %q using push endpoint %q with OIDC token for %s: %s", subscriptionID, topicID, projectID, pushEndpoint, DescribePushAuth(subscription), err)
+		}
+	case pushEndpoint != "":
+		a.o.log(fields, "Creating push subscription %q with target %q%s", subscriptionID, pushEndpoint, creationNote(subscription))
+		err = a.topology.CreateSubscription(ctx, topicID, subscription)
+		if err != nil && !isAlreadyExists(err) {
+			err = fmt.Errorf("Unable to create push subscription %q on topic %q for project %q using push endpoint %q: %s", subscriptionID, topicID, projectID, pushEndpoint, err)
+		}
+	default:
+		a.o.log(fields, "Creating pull subscription %q%s", subscriptionID, creationNote(subscription))
+		err = a.topology.CreateSubscription(ctx, topicID, subscription)
+		if err != nil && !isAlreadyExists(err) {
+			err = fmt.Errorf("Unable to create subscription %q on topic %q for project %q: %s", subscriptionID, topicID, projectID, err)
+		}
+	}
+	if isAlreadyExists(err) {
+		// Another config declaring the same subscription got there first.
+		a.o.log(fields, "Subscription %q already exists", subscriptionID)
+		a.o.observe(Event{Topic: topicID, Subscription: &subscription, Auto: auto, Result: Existing})
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	a.o.observe(Event{Topic: topicID, Subscription: &subscription, Auto: auto, Result: Created})
+	return nil
+}
+
+// existingSubscription reports whether a subscription already exists. If it
+// does with a different push endpoint, the endpoint is updated to match.
+func (a *Applier) existingSubscription(ctx context.Context, topicID string, subscription Subscription, auto bool) (bool, error) {
+	projectID, subscriptionID := a.config.ProjectID, subscription.ID
+	fields := []any{"subscription", subscriptionID}
+	a.o.log(fields, "Checking for existing subscription %q", subscriptionID)
+	pushEndpoint, exists, err := a.topology.SubscriptionPushEndpoint(ctx, subscriptionID)
+	if err != nil {
+		return false, fmt.Errorf("Failed to check existence of subscription %q for project %q: %s", subscriptionID, projectID, err)
+	}
+	if !exists {
+		return false, nil
+	}
+	if pushEndpoint == subscription.PushEndpoint {
+		a.o.log(fields, "Subscription %q already exists", subscriptionID)
+		a.o.observe(Event{Topic: topicID, Subscription: &subscription, Auto: auto, Result: Existing})
+		return true, nil
+	}
+
+	a.o.log(fields, "Subscription %q already exists, updating its push endpoint from %q to %q", subscriptionID, pushEndpoint, subscription.PushEndpoint)
+	if err := a.topology.UpdatePushConfig(ctx, subscription); err != nil {
+		return true, fmt.Errorf("Unable to update push endpoint of subscription %q for project %q to %q: %s", subscriptionID, projectID, subscription.PushEndpoint, err)
+	}
+	a.o.observe(Event{Topic: topicID, Subscription: &subscription, Auto: auto, Result: Updated})
+	return true, nil
+}
+
+// forEach calls fn for every index up to n, each call holding one of the
+// request slots, and returns the errors in index order. With a single slot
+// the calls are made one after another, in index order.
+func (a *Applier) forEach(n int, fn func(i int) error) []error {
+	results := make([]error, n)
+	if cap(a.o.slots) == 1 {
+		for i := 0; i < n; i++ {
+			a.o.slots <- struct{}{}
+			results[i] = fn(i)
+			<-a.o.slots
+		}
+	} else {
+		var wg sync.WaitGroup
+		for i := 0; i < n; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				a.o.slots <- struct{}{}
+				defer func() { <-a.o.slots }()
+				results[i] = fn(i)
+			}(i)
+		}
+		wg.Wait()
+	}
+
+	var errs []error
+	for _, err := range results {
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errs
+}
+
+// newClient connects to Pub/Sub for the project. Without an emulator host,
+// pubsub.NewClient uses PUBSUB_EMULATOR_HOST if it is set.
+func (o options) newClient(ctx context.Context, projectID string) (*pubsub.Client, error) {
+	if o.host == "" {
+		return pubsub.NewClient(ctx, projectID, o.clientOptions...)
+	}
+	// pubsub.NewClient dials PUBSUB_EMULATOR_HOST itself when it is set, and
+	// only a connection of our own takes precedence over that.
+	conn, err := grpc.Dial(o.host, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		return nil, err
+	}
+	clientOptions := append([]option.ClientOption{option.WithGRPCConn(conn), option.WithTelemetryDisabled()}, o.clientOptions...)
+	return pubsub.NewClient(ctx, projectID, clientOptions...)
+}
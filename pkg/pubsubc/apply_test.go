@@ -0,0 +1,76 @@
+package pubsubc
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sync"
+	"testing"
+
+	"cloud.google.com/go/pubsub/apiv1/pubsubpb"
+	"cloud.google.com/go/pubsub/pstest"
+)
+
+// recordingReactor records the name of every resource created, without
+// handling the request.
+type recordingReactor struct {
+	mu    *sync.Mutex
+	names *[]string
+}
+
+func (r recordingReactor) React(req interface{}) (bool, interface{}, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	switch req := req.(type) {
+	case *pubsubpb.Topic:
+		*r.names = append(*r.names, req.Name)
+	case *pubsubpb.Subscription:
+		*r.names = append(*r.names, req.Name)
+	}
+	return false, nil, nil
+}
+
+// TestApplyInDeclarationOrder applies a config to two fresh servers one
+// request at a time, and checks that both runs create the same resources, and
+// log the same lines, in the order they are declared.
+func TestApplyInDeclarationOrder(t *testing.T) {
+	config := mustParseConfig(t, "p,orders:orders-worker:orders-audit,shipments,invoices:invoices-worker^invoices-dlq")
+	run := func() (created, lines []string) {
+		var mu sync.Mutex
+		reactor := recordingReactor{&mu, &created}
+		client := newTestClient(t,
+			pstest.ServerReactorOption{FuncName: "CreateTopic", Reactor: reactor},
+			pstest.ServerReactorOption{FuncName: "CreateSubscription", Reactor: reactor},
+		)
+		logf := func(format string, params ...interface{}) {
+			lines = append(lines, fmt.Sprintf(format, params...))
+		}
+		if err := Apply(context.Background(), config, WithClient(client), WithConcurrency(1), WithAutoSub(), WithLogger(logf)); err != nil {
+			t.Fatalf("Apply() = %v, want nil", err)
+		}
+		return created, lines
+	}
+
+	created, lines := run()
+	want := []string{
+		"projects/p/topics/orders",
+		"projects/p/topics/shipments",
+		"projects/p/topics/invoices",
+		"projects/p/topics/invoices-dlq",
+		"projects/p/subscriptions/orders-worker",
+		"projects/p/subscriptions/orders-audit",
+		"projects/p/subscriptions/shipments-sub",
+		"projects/p/subscriptions/invoices-worker",
+		"projects/p/subscriptions/invoices-dlq-sub",
+	}
+	if !reflect.DeepEqual(created, want) {
+		t.Errorf("First run created %q, want %q", created, want)
+	}
+	createdAgain, linesAgain := run()
+	if !reflect.DeepEqual(createdAgain, created) {
+		t.Errorf("Second run created %q, first run %q", createdAgain, created)
+	}
+	if !reflect.DeepEqual(linesAgain, lines) {
+		t.Errorf("Second run logged %q, first run %q", linesAgain, lines)
+	}
+}
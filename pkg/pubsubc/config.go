@@ -0,0 +1,541 @@
+// Package pubsubc parses pubsubc config strings and applies them to Pub/Sub,
+// so Go tests can create their topics and subscriptions without running the
+// pubsubc binary:
+//
+//	config, err := pubsubc.ParseConfig("my-project,orders:orders-worker")
+//	if err != nil {
+//		return err
+//	}
+//	err = pubsubc.Apply(ctx, config, pubsubc.WithEmulatorHost(host))
+package pubsubc
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"cloud.google.com/go/pubsub"
+)
+
+// Subscription is a declared subscription. A zero AckDeadline or Retention
+// leaves the service default, as does a zero MaxDeliveryAttempts for a
+// subscription with a DeadLetterTopic, which is a topic ID in the same
+// project. Messages with the same ordering key are delivered in order to an
+// Ordered subscription. A Filter is passed to Pub/Sub verbatim. A non-zero
+// MinBackoff or MaxBackoff sets the retry policy, leaving the other at the
+// service default. Labels are only set when the subscription is created. A
+// push subscription with a PushServiceAccount sends an OIDC token for that
+// service account, with the PushAudience if there is one. A subscription with
+// a BigQueryTable, of the form project.dataset.table, writes its messages to
+// that table instead of being pulled or pushed: to the columns of the topic's
+// schema with BigQueryUseTopicSchema, and with the message ID, publish time
+// and attributes in columns of their own with BigQueryWriteMetadata.
+type Subscription struct {
+	ID                     string
+	PushEndpoint           string
+	PushServiceAccount     string
+	PushAudience           string
+	BigQueryTable          string
+	BigQueryUseTopicSchema bool
+	BigQueryWriteMetadata  bool
+	AckDeadline            time.Duration
+	Retention              time.Duration
+	RetainAcked            bool
+	DeadLetterTopic        string
+	MaxDeliveryAttempts    int
+	Ordered                bool
+	Filter                 string
+	MinBackoff             time.Duration
+	MaxBackoff             time.Duration
+	Labels                 map[string]string
+}
+
+// Config returns the settings to create the subscription on topic with.
+func (s Subscription) Config(topic *pubsub.Topic) pubsub.SubscriptionConfig {
+	config := pubsub.SubscriptionConfig{
+		Topic:                 topic,
+		PushConfig:            s.PushConfig(),
+		AckDeadline:           s.AckDeadline,
+		RetentionDuration:     s.Retention,
+		RetainAckedMessages:   s.RetainAcked,
+		EnableMessageOrdering: s.Ordered,
+		Filter:                s.Filter,
+		Labels:                s.Labels,
+	}
+	if s.DeadLetterTopic != "" {
+		// topic is named projects/<project>/topics/<topic>.
+		name := topic.String()
+		config.DeadLetterPolicy = &pubsub.DeadLetterPolicy{
+			DeadLetterTopic:     name[:strings.LastIndex(name, "/")+1] + s.DeadLetterTopic,
+			MaxDeliveryAttempts: s.MaxDeliveryAttempts,
+		}
+	}
+	if s.BigQueryTable != "" {
+		config.BigQueryConfig = pubsub.BigQueryConfig{
+			Table:          s.BigQueryTable,
+			UseTopicSchema: s.BigQueryUseTopicSchema,
+			WriteMetadata:  s.BigQueryWriteMetadata,
+		}
+	}
+	if s.MinBackoff > 0 || s.MaxBackoff > 0 {
+		config.RetryPolicy = &pubsub.RetryPolicy{}
+		if s.MinBackoff > 0 {
+			config.RetryPolicy.MinimumBackoff = s.MinBackoff
+		}
+		if s.MaxBackoff > 0 {
+			config.RetryPolicy.MaximumBackoff = s.MaxBackoff
+		}
+	}
+	return config
+}
+
+// PushConfig returns the push settings of the subscription, which are empty
+// for a pull subscription.
+func (s Subscription) PushConfig() pubsub.PushConfig {
+	config := pubsub.PushConfig{Endpoint: s.PushEndpoint}
+	if s.PushEndpoint != "" && s.PushServiceAccount != "" {
+		config.AuthenticationMethod = &pubsub.OIDCToken{
+			ServiceAccountEmail: s.PushServiceAccount,
+			Audience:            s.PushAudience,
+		}
+	}
+	return config
+}
+
+// Topic is a Pub/Sub topic and its subscriptions. Labels are only set when
+// the topic is created, as are a non-zero Retention, which keeps messages for
+// that long even once acknowledged, and a Schema. A topic with a Schema, the ID
+// of an existing schema in the same project, only accepts messages that are
+// valid against it in the SchemaEncoding, json (the default) or binary.
+type Topic struct {
+	ID             string
+	Subscriptions  []Subscription
+	Labels         map[string]string
+	Retention      time.Duration
+	Schema         string
+	SchemaEncoding string
+}
+
+// Config returns the settings to create the topic in a project with.
+func (t Topic) Config(projectID string) *pubsub.TopicConfig {
+	config := &pubsub.TopicConfig{Labels: t.Labels}
+	if t.Retention > 0 {
+		config.RetentionDuration = t.Retention
+	}
+	if t.Schema != "" {
+		config.SchemaSettings = &pubsub.SchemaSettings{
+			Schema:   fmt.Sprintf("projects/%s/schemas/%s", projectID, t.Schema),
+			Encoding: pubsub.EncodingJSON,
+		}
+		if t.SchemaEncoding == "binary" {
+			config.SchemaSettings.Encoding = pubsub.EncodingBinary
+		}
+	}
+	return config
+}
+
+// Encoding returns the encoding the topic's messages are validated in.
+func (t Topic) Encoding() string {
+	if t.SchemaEncoding == "" {
+		return "json"
+	}
+	return t.SchemaEncoding
+}
+
+// Topics describes Pub/Sub topics and their subscriptions, in the order they
+// were declared. Resources are created in this order.
+type Topics []Topic
+
+// Add appends subscriptions to a topic, declaring the topic first if it is
+// new.
+func (t *Topics) Add(topicID string, subscriptions ...Subscription) {
+	for i := range *t {
+		if (*t)[i].ID == topicID {
+			(*t)[i].Subscriptions = append((*t)[i].Subscriptions, subscriptions...)
+			return
+		}
+	}
+	*t = append(*t, Topic{ID: topicID, Subscriptions: subscriptions})
+}
+
+// SetLabels sets the labels of a declared topic.
+func (t Topics) SetLabels(topicID string, labels map[string]string) {
+	for i := range t {
+		if t[i].ID == topicID {
+			t[i].Labels = labels
+		}
+	}
+}
+
+// SetRetention sets how long a declared topic retains messages.
+func (t Topics) SetRetention(topicID string, retention time.Duration) {
+	for i := range t {
+		if t[i].ID == topicID {
+			t[i].Retention = retention
+		}
+	}
+}
+
+// SetSchema sets the schema a declared topic validates messages against, and
+// their encoding.
+func (t Topics) SetSchema(topicID, schemaID, encoding string) {
+	for i := range t {
+		if t[i].ID == topicID {
+			t[i].Schema = schemaID
+			t[i].SchemaEncoding = encoding
+		}
+	}
+}
+
+// AddDeadLetterTopics declares the dead-letter topics of the subscriptions
+// that aren't declared themselves, after the rest.
+func (t *Topics) AddDeadLetterTopics() {
+	for _, entry := range *t {
+		for _, subscription := range entry.Subscriptions {
+			if subscription.DeadLetterTopic != "" {
+				t.Add(subscription.DeadLetterTopic)
+			}
+		}
+	}
+}
+
+// IDs returns the topic IDs in declaration order.
+func (t Topics) IDs() []string {
+	ids := make([]string, len(t))
+	for i, entry := range t {
+		ids[i] = entry.ID
+	}
+	return ids
+}
+
+// ProjectConfig is a parsed config string. The topics of a SubscriptionsOnly
+// config belong to someone else, and Apply only checks that they exist. An
+// empty ProjectID or $DEFAULT is left for the caller to resolve.
+type ProjectConfig struct {
+	ProjectID         string
+	Topics            Topics
+	SubscriptionsOnly bool
+}
+
+// ParseConfig parses a config string: the project, then comma separated
+// topics, each followed by colon separated subscriptions. A ~ before the
+// project marks a config that only declares subscriptions. Dead-letter topics
+// that aren't declared are added to the topics. A backslash escapes a
+// separator, or another backslash, that is part of a value.
+func ParseConfig(config string) (ProjectConfig, error) {
+	// Separate the projectID from the topic definitions.
+	configParts := splitUnescaped(config, ',')
+	if len(configParts) < 2 {
+		return ProjectConfig{}, fmt.Errorf("Expected at least 1 topic to be defined")
+	}
+
+	// Separate the topicID from the subscription IDs. A topic listed more than
+	// once collects the subscriptions from every occurrence, in the position of
+	// the first.
+	var topics Topics
+	for _, part := range configParts[1:] {
+		topicParts := splitUnescaped(part, ':')
+		var subscriptions []Subscription
+		for _, subscription := range topicParts[1:] {
+			spec, err := ParseSubscription(subscription)
+			if err != nil {
+				return ProjectConfig{}, err
+			}
+			subscriptions = append(subscriptions, spec)
+		}
+		topics.Add(unescape(topicParts[0]), subscriptions...)
+	}
+	topics.AddDeadLetterTopics()
+
+	projectID := configParts[0]
+	return ProjectConfig{
+		ProjectID:         unescape(strings.TrimPrefix(projectID, "~")),
+		Topics:            topics,
+		SubscriptionsOnly: strings.HasPrefix(projectID, "~"),
+	}, nil
+}
+
+// ParseSubscription parses a subscription of a config string: its ID, then
+// any !flags and ~min-max for a retry policy in either order, followed by
+// +endpoint for a push subscription or +bq=project.dataset.table for a
+// BigQuery subscription, then ^topic or ^topic|attempts for a dead-letter
+// topic. The flags are !ordered, for message ordering, and for a BigQuery
+// subscription !usetopicschema and !writemetadata. A push endpoint may be
+// followed by @ and the service account to authenticate with, then #audience.
+// A flag or retry policy after the + or ^, or a + after the ^, is an error. A
+// backslash escapes a separator that is part of a value, such as \+ in a push
+// endpoint; an escaped | in an endpoint is kept rather than becoming a :.
+func ParseSubscription(subscription string) (Subscription, error) {
+	var spec Subscription
+	if countUnescaped(subscription, '^') > 1 {
+		return spec, fmt.Errorf("Subscription %q has more than one dead-letter topic", subscription)
+	}
+	if countUnescaped(subscription, '+') > 1 {
+		return spec, fmt.Errorf("Subscription %q has more than one +, escape a + in a push endpoint as \\+", subscription)
+	}
+	subscription, deadLetter, hasDeadLetter := cutUnescaped(subscription, '^')
+	if hasDeadLetter {
+		if segment := misplacedSegment(deadLetter); segment != "" {
+			return spec, fmt.Errorf("Subscription %q: %s must come before the ^dead-letter topic", subscription+"^"+deadLetter, segment)
+		}
+		if indexUnescaped(deadLetter, '+') >= 0 {
+			return spec, fmt.Errorf("Subscription %q: the +endpoint must come before the ^dead-letter topic", subscription+"^"+deadLetter)
+		}
+		topicID, attempts, hasAttempts := cutUnescaped(deadLetter, '|')
+		topicID, attempts = unescape(topicID), unescape(attempts)
+		if topicID == "" {
+			return spec, fmt.Errorf("Subscription %q has no dead-letter topic after ^", subscription)
+		}
+		spec.DeadLetterTopic = topicID
+		if hasAttempts {
+			// Pub/Sub only accepts 5 to 100 delivery attempts.
+			n, err := strconv.Atoi(attempts)
+			if err != nil || n < 5 || n > 100 {
+				return spec, fmt.Errorf("Subscription %q: max delivery attempts must be from 5 to 100, not %q", subscription, attempts)
+			}
+			spec.MaxDeliveryAttempts = n
+		}
+	}
+
+	subscriptionParts := splitUnescaped(subscription, '+')
+	segments := splitBefore(subscriptionParts[0], "!~")
+	spec.ID = unescape(segments[0])
+	if spec.ID == "" {
+		return spec, fmt.Errorf("Subscription %q has no ID", subscription)
+	}
+	hasBackoff := false
+	for _, segment := range segments[1:] {
+		value := unescape(segment[1:])
+		if segment[0] == '~' {
+			if hasBackoff {
+				return spec, fmt.Errorf("Subscription %q has more than one retry policy", subscription)
+			}
+			hasBackoff = true
+			minimum, maximum, ok := strings.Cut(value, "-")
+			if !ok {
+				return spec, fmt.Errorf("Subscription %q: expected a retry policy of ~min-max, such as ~10s-300s", subscription)
+			}
+			var err error
+			if spec.MinBackoff, err = time.ParseDuration(minimum); err != nil {
+				return spec, fmt.Errorf("Subscription %q: invalid minimum backoff %q: %s", subscription, minimum, err)
+			}
+			if spec.MaxBackoff, err = time.ParseDuration(maximum); err != nil {
+				return spec, fmt.Errorf("Subscription %q: invalid maximum backoff %q: %s", subscription, maximum, err)
+			}
+			if err := CheckRetryPolicy(spec.MinBackoff, spec.MaxBackoff); err != nil {
+				return spec, fmt.Errorf("Subscription %q: %s", subscription, err)
+			}
+			continue
+		}
+		switch value {
+		case "ordered":
+			spec.Ordered = true
+		case "usetopicschema":
+			spec.BigQueryUseTopicSchema = true
+		case "writemetadata":
+			spec.BigQueryWriteMetadata = true
+		default:
+			return spec, fmt.Errorf("Subscription %q has unknown flag !%s, expected !ordered, !usetopicschema or !writemetadata", subscription, value)
+		}
+	}
+	bigQuery := len(subscriptionParts) > 1 && strings.HasPrefix(subscriptionParts[1], "bq=")
+	if (spec.BigQueryUseTopicSchema || spec.BigQueryWriteMetadata) && !bigQuery {
+		return spec, fmt.Errorf("Subscription %q: !usetopicschema and !writemetadata need a BigQuery table, such as +bq=project.dataset.table", subscription)
+	}
+	if len(subscriptionParts) == 1 {
+		return spec, nil
+	}
+	if segment := misplacedSegment(subscriptionParts[1]); segment != "" {
+		return spec, fmt.Errorf("Subscription %q: %s must come before the +", subscription, segment)
+	}
+	if bigQuery {
+		table := strings.TrimPrefix(subscriptionParts[1], "bq=")
+		if table == "" || indexUnescaped(table, '@') >= 0 {
+			return spec, fmt.Errorf("Subscription %q: expected a BigQuery table after bq=, such as bq=project.dataset.table", subscription)
+		}
+		spec.BigQueryTable = unescape(table)
+		return spec, nil
+	}
+	endpoint, serviceAccount, hasAuth := cutUnescaped(subscriptionParts[1], '@')
+	if hasAuth {
+		serviceAccount, audience, _ := cutUnescaped(serviceAccount, '#')
+		serviceAccount, audience = unescape(serviceAccount), unescape(audience)
+		if !strings.Contains(serviceAccount, "@") {
+			return spec, fmt.Errorf("Subscription %q: expected a service account email after the push endpoint's @, not %q", subscription, serviceAccount)
+		}
+		spec.PushServiceAccount = serviceAccount
+		spec.PushAudience = audience
+	}
+	pushEndpoint := unescape(replaceUnescaped(endpoint, '|', ':', 2))
+	if !strings.HasPrefix(pushEndpoint, "http") {
+		pushEndpoint = "http://" + pushEndpoint
+	}
+	spec.PushEndpoint = pushEndpoint
+	return spec, nil
+}
+
+// misplacedSegment returns the !flag or ~min-max retry policy that part ends
+// with, or "" if it doesn't end with one.
+func misplacedSegment(part string) string {
+	segments := splitBefore(part, "!~")
+	if len(segments) == 1 {
+		return ""
+	}
+	segment := segments[len(segments)-1]
+	value := unescape(segment[1:])
+	if segment[0] == '!' {
+		switch value {
+		case "ordered", "usetopicschema", "writemetadata":
+			return segment
+		}
+		return ""
+	}
+	minimum, maximum, ok := strings.Cut(value, "-")
+	if !ok {
+		return ""
+	}
+	if _, err := time.ParseDuration(minimum); err != nil {
+		return ""
+	}
+	if _, err := time.ParseDuration(maximum); err != nil {
+		return ""
+	}
+	return segment
+}
+
+// CheckRetryPolicy checks backoffs against the range Pub/Sub accepts, 0 to
+// 600 seconds, and that the minimum isn't more than the maximum. A zero
+// backoff is left at the service default.
+func CheckRetryPolicy(minimum, maximum time.Duration) error {
+	for _, backoff := range []time.Duration{minimum, maximum} {
+		if backoff < 0 || backoff > 600*time.Second {
+			return fmt.Errorf("backoff must be between 0s and 600s, not %s", backoff)
+		}
+	}
+	if minimum > 0 && maximum > 0 && minimum > maximum {
+		return fmt.Errorf("minimum backoff %s is more than the maximum %s", minimum, maximum)
+	}
+	return nil
+}
+
+// CheckTopicRetention checks a topic's message retention against the range
+// Pub/Sub accepts, 10 minutes to 31 days.
+func CheckTopicRetention(retention time.Duration) error {
+	if retention < 10*time.Minute || retention > 31*24*time.Hour {
+		return fmt.Errorf("message retention must be between 10m and 744h (31 days), not %s", retention)
+	}
+	return nil
+}
+
+// SubscriptionDefaults are settings for every subscription of a config that
+// doesn't set its own. Zero values leave the service defaults.
+type SubscriptionDefaults struct {
+	AckDeadline time.Duration
+	Retention   time.Duration
+	RetainAcked bool
+}
+
+// Apply returns the subscription with any settings it doesn't set itself
+// taken from the defaults.
+func (d SubscriptionDefaults) Apply(spec Subscription) Subscription {
+	if spec.AckDeadline == 0 {
+		spec.AckDeadline = d.AckDeadline
+	}
+	if spec.Retention == 0 {
+		spec.Retention = d.Retention
+	}
+	spec.RetainAcked = spec.RetainAcked || d.RetainAcked
+	return spec
+}
+
+// ApplyTo gives every subscription of the topics the defaults.
+func (d SubscriptionDefaults) ApplyTo(topics Topics) {
+	for i := range topics {
+		for j := range topics[i].Subscriptions {
+			topics[i].Subscriptions[j] = d.Apply(topics[i].Subscriptions[j])
+		}
+	}
+}
+
+// ParseSubscriptionDefaults parses comma separated settings such as
+// "ackDeadline=60s,retention=24h,retainAcked=true". The values are checked
+// against the ranges Pub/Sub accepts, so a mistake is reported before
+// anything is created.
+func ParseSubscriptionDefaults(value string) (SubscriptionDefaults, error) {
+	var defaults SubscriptionDefaults
+	for _, setting := range strings.Split(value, ",") {
+		key, val, ok := strings.Cut(setting, "=")
+		if !ok {
+			return defaults, fmt.Errorf("Expected key=value, not %q", setting)
+		}
+		switch key = strings.TrimSpace(key); key {
+		case "ackDeadline":
+			d, err := time.ParseDuration(val)
+			if err != nil {
+				return defaults, fmt.Errorf("Invalid ackDeadline %q: %s", val, err)
+			}
+			if d < 10*time.Second || d > 600*time.Second {
+				return defaults, fmt.Errorf("ackDeadline must be between 10s and 600s, not %s", d)
+			}
+			defaults.AckDeadline = d
+		case "retention":
+			d, err := time.ParseDuration(val)
+			if err != nil {
+				return defaults, fmt.Errorf("Invalid retention %q: %s", val, err)
+			}
+			if d < 10*time.Minute || d > 7*24*time.Hour {
+				return defaults, fmt.Errorf("retention must be between 10m and 168h, not %s", d)
+			}
+			defaults.Retention = d
+		case "retainAcked":
+			b, err := strconv.ParseBool(val)
+			if err != nil {
+				return defaults, fmt.Errorf("Invalid retainAcked %q, expected true or false", val)
+			}
+			defaults.RetainAcked = b
+		default:
+			return defaults, fmt.Errorf("Unknown setting %q, expected ackDeadline, retention or retainAcked", key)
+		}
+	}
+	return defaults, nil
+}
+
+// labelPattern is the characters Pub/Sub allows in label keys and values.
+var labelPattern = regexp.MustCompile(`^[\p{Ll}\p{Lo}0-9_-]{0,63}$`)
+
+// CheckLabels checks labels against the rules Pub/Sub has for them: at most
+// 64, with keys of 1 to 63 lowercase letters, digits, underscores and dashes
+// that start with a letter, and values of up to 63 of the same.
+func CheckLabels(labels map[string]string) error {
+	if len(labels) > 64 {
+		return fmt.Errorf("%d labels is more than the 64 allowed", len(labels))
+	}
+	for key, value := range labels {
+		first, _ := utf8.DecodeRuneInString(key)
+		if key == "" || !labelPattern.MatchString(key) || !unicode.IsLower(first) && !unicode.Is(unicode.Lo, first) {
+			return fmt.Errorf("Invalid label key %q: keys must be 1 to 63 lowercase letters, digits, underscores or dashes, starting with a letter", key)
+		}
+		if !labelPattern.MatchString(value) {
+			return fmt.Errorf("Invalid value %q of label %q: values must be up to 63 lowercase letters, digits, underscores or dashes", value, key)
+		}
+	}
+	return nil
+}
+
+// ParseLabels parses comma separated labels such as "env=test,team=payments",
+// checking them with CheckLabels.
+func ParseLabels(value string) (map[string]string, error) {
+	labels := make(map[string]string)
+	for _, label := range strings.Split(value, ",") {
+		key, val, ok := strings.Cut(label, "=")
+		if !ok {
+			return nil, fmt.Errorf("Expected key=value, not %q", label)
+		}
+		labels[strings.TrimSpace(key)] = strings.TrimSpace(val)
+	}
+	return labels, CheckLabels(labels)
+}
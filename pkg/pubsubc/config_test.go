@@ -0,0 +1,202 @@
+package pubsubc
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+// TestParseConfigUnescaped checks that config strings without backslashes
+// parse as they did before escapes were supported.
+func TestParseConfigUnescaped(t *testing.T) {
+	tests := []struct {
+		config string
+		want   ProjectConfig
+	}{
+		{"p,t", ProjectConfig{ProjectID: "p", Topics: Topics{{ID: "t"}}}},
+		{"p,t1,t2:s1", ProjectConfig{ProjectID: "p", Topics: Topics{{ID: "t1"}, {ID: "t2", Subscriptions: []Subscription{{ID: "s1"}}}}}},
+		{"~p,t:s", ProjectConfig{ProjectID: "p", Topics: Topics{{ID: "t", Subscriptions: []Subscription{{ID: "s"}}}}, SubscriptionsOnly: true}},
+		{"$DEFAULT,t", ProjectConfig{ProjectID: "$DEFAULT", Topics: Topics{{ID: "t"}}}},
+		{"p,t:s,t:s2", ProjectConfig{ProjectID: "p", Topics: Topics{{ID: "t", Subscriptions: []Subscription{{ID: "s"}, {ID: "s2"}}}}}},
+		{"p,orders.v1:orders.v1.worker", ProjectConfig{ProjectID: "p", Topics: Topics{{ID: "orders.v1", Subscriptions: []Subscription{{ID: "orders.v1.worker"}}}}}},
+		{"p,t:push+endpoint", ProjectConfig{ProjectID: "p", Topics: Topics{{ID: "t", Subscriptions: []Subscription{
+			{ID: "push", PushEndpoint: "http://endpoint"},
+		}}}}},
+		{"p,t:push+worker|8080", ProjectConfig{ProjectID: "p", Topics: Topics{{ID: "t", Subscriptions: []Subscription{
+			{ID: "push", PushEndpoint: "http://worker:8080"},
+		}}}}},
+		{"p,t:push+http|//worker|8080/path", ProjectConfig{ProjectID: "p", Topics: Topics{{ID: "t", Subscriptions: []Subscription{
+			{ID: "push", PushEndpoint: "http://worker:8080/path"},
+		}}}}},
+		{"p,t:push+https|//h/a|b|c", ProjectConfig{ProjectID: "p", Topics: Topics{{ID: "t", Subscriptions: []Subscription{
+			{ID: "push", PushEndpoint: "https://h/a:b|c"},
+		}}}}},
+		{"p,t:push+worker|8080@sa@p.iam.gserviceaccount.com#aud", ProjectConfig{ProjectID: "p", Topics: Topics{{ID: "t", Subscriptions: []Subscription{
+			{ID: "push", PushEndpoint: "http://worker:8080", PushServiceAccount: "sa@p.iam.gserviceaccount.com", PushAudience: "aud"},
+		}}}}},
+		{"p,t:s!ordered~10s-20s+worker|8080^dlq|5", ProjectConfig{ProjectID: "p", Topics: Topics{
+			{ID: "t", Subscriptions: []Subscription{{
+				ID: "s", Ordered: true, MinBackoff: 10 * time.Second, MaxBackoff: 20 * time.Second,
+				PushEndpoint: "http://worker:8080", DeadLetterTopic: "dlq", MaxDeliveryAttempts: 5,
+			}}},
+			{ID: "dlq"},
+		}}},
+		{"p,t:s^t2,t2", ProjectConfig{ProjectID: "p", Topics: Topics{
+			{ID: "t", Subscriptions: []Subscription{{ID: "s", DeadLetterTopic: "t2"}}},
+			{ID: "t2"},
+		}}},
+	}
+	for _, test := range tests {
+		got, err := ParseConfig(test.config)
+		if err != nil {
+			t.Errorf("ParseConfig(%q) returned error: %s", test.config, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("ParseConfig(%q) = %+v, want %+v", test.config, got, test.want)
+		}
+	}
+}
+
+func TestParseConfigEscaped(t *testing.T) {
+	tests := []struct {
+		config string
+		want   ProjectConfig
+	}{
+		{`p,t:push+https\://svc\:8443/push\+v2`, ProjectConfig{ProjectID: "p", Topics: Topics{{ID: "t", Subscriptions: []Subscription{
+			{ID: "push", PushEndpoint: "https://svc:8443/push+v2"},
+		}}}}},
+		{`p,t:push+https|//svc/a\|b`, ProjectConfig{ProjectID: "p", Topics: Topics{{ID: "t", Subscriptions: []Subscription{
+			{ID: "push", PushEndpoint: "https://svc/a|b"},
+		}}}}},
+		{`p,t:push+svc/q\@x@sa@p.iam.gserviceaccount.com#a\#b`, ProjectConfig{ProjectID: "p", Topics: Topics{{ID: "t", Subscriptions: []Subscription{
+			{ID: "push", PushEndpoint: "http://svc/q@x", PushServiceAccount: "sa@p.iam.gserviceaccount.com", PushAudience: "a#b"},
+		}}}}},
+		{`p,t\,1:s\!1`, ProjectConfig{ProjectID: "p", Topics: Topics{{ID: "t,1", Subscriptions: []Subscription{{ID: "s!1"}}}}}},
+		{`p\\q,t`, ProjectConfig{ProjectID: `p\q`, Topics: Topics{{ID: "t"}}}},
+		{`p,t:s\`, ProjectConfig{ProjectID: "p", Topics: Topics{{ID: "t", Subscriptions: []Subscription{{ID: `s\`}}}}}},
+		{`p,t\`, ProjectConfig{ProjectID: "p", Topics: Topics{{ID: `t\`}}}},
+		{`p,t:s^dl\^q|5`, ProjectConfig{ProjectID: "p", Topics: Topics{
+			{ID: "t", Subscriptions: []Subscription{{ID: "s", DeadLetterTopic: "dl^q", MaxDeliveryAttempts: 5}}},
+			{ID: "dl^q"},
+		}}},
+	}
+	for _, test := range tests {
+		got, err := ParseConfig(test.config)
+		if err != nil {
+			t.Errorf("ParseConfig(%q) returned error: %s", test.config, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("ParseConfig(%q) = %+v, want %+v", test.config, got, test.want)
+		}
+	}
+}
+
+func TestParseSubscription(t *testing.T) {
+	backoff := func(spec Subscription) Subscription {
+		spec.MinBackoff, spec.MaxBackoff = 10*time.Second, 20*time.Second
+		return spec
+	}
+	tests := []struct {
+		subscription string
+		want         Subscription
+	}{
+		{"sub", Subscription{ID: "sub"}},
+		{"sub!ordered", Subscription{ID: "sub", Ordered: true}},
+		{"sub~10s-20s", backoff(Subscription{ID: "sub"})},
+		{"sub!ordered~10s-20s", backoff(Subscription{ID: "sub", Ordered: true})},
+		{"sub~10s-20s!ordered", backoff(Subscription{ID: "sub", Ordered: true})},
+		{"sub!ordered+worker|8080", Subscription{ID: "sub", Ordered: true, PushEndpoint: "http://worker:8080"}},
+		{"sub~10s-20s!ordered+worker|8080", backoff(Subscription{ID: "sub", Ordered: true, PushEndpoint: "http://worker:8080"})},
+		{"sub!ordered~10s-20s+worker@sa@p.iam.gserviceaccount.com#aud", backoff(Subscription{
+			ID: "sub", Ordered: true, PushEndpoint: "http://worker", PushServiceAccount: "sa@p.iam.gserviceaccount.com", PushAudience: "aud",
+		})},
+		{"sub!writemetadata!usetopicschema+bq=p.d.t", Subscription{
+			ID: "sub", BigQueryTable: "p.d.t", BigQueryUseTopicSchema: true, BigQueryWriteMetadata: true,
+		}},
+		{"sub~10s-20s!writemetadata+bq=p.d.t^dlq", backoff(Subscription{
+			ID: "sub", BigQueryTable: "p.d.t", BigQueryWriteMetadata: true, DeadLetterTopic: "dlq",
+		})},
+		{"sub^dlq", Subscription{ID: "sub", DeadLetterTopic: "dlq"}},
+		{"sub^dlq|100", Subscription{ID: "sub", DeadLetterTopic: "dlq", MaxDeliveryAttempts: 100}},
+		{"sub!ordered^dlq|5", Subscription{ID: "sub", Ordered: true, DeadLetterTopic: "dlq", MaxDeliveryAttempts: 5}},
+		{"sub~10s-20s!ordered^dlq|5", backoff(Subscription{ID: "sub", Ordered: true, DeadLetterTopic: "dlq", MaxDeliveryAttempts: 5})},
+		{"sub!ordered~10s-20s+worker|8080@sa@p.iam.gserviceaccount.com#aud^dlq|10", backoff(Subscription{
+			ID: "sub", Ordered: true, PushEndpoint: "http://worker:8080", PushServiceAccount: "sa@p.iam.gserviceaccount.com", PushAudience: "aud",
+			DeadLetterTopic: "dlq", MaxDeliveryAttempts: 10,
+		})},
+		{"sub+https|//worker/~user/x!y", Subscription{ID: "sub", PushEndpoint: "https://worker/~user/x!y"}},
+	}
+	for _, test := range tests {
+		got, err := ParseSubscription(test.subscription)
+		if err != nil {
+			t.Errorf("ParseSubscription(%q) returned error: %s", test.subscription, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("ParseSubscription(%q) = %+v, want %+v", test.subscription, got, test.want)
+		}
+	}
+}
+
+func TestParseSubscriptionErrors(t *testing.T) {
+	tests := []struct {
+		subscription string
+		want         string
+	}{
+		{"", `Subscription "" has no ID`},
+		{"!ordered", `Subscription "!ordered" has no ID`},
+		{"sub!unknown", `Subscription "sub!unknown" has unknown flag !unknown, expected !ordered, !usetopicschema or !writemetadata`},
+		{"sub~10s", `Subscription "sub~10s": expected a retry policy of ~min-max, such as ~10s-300s`},
+		{"sub~x-20s", `Subscription "sub~x-20s": invalid minimum backoff "x": time: invalid duration "x"`},
+		{"sub~20s-10s", `Subscription "sub~20s-10s": minimum backoff 20s is more than the maximum 10s`},
+		{"sub~10s-20s~1s-2s", `Subscription "sub~10s-20s~1s-2s" has more than one retry policy`},
+		{"sub+worker!ordered", `Subscription "sub+worker!ordered": !ordered must come before the +`},
+		{"sub+worker~10s-20s", `Subscription "sub+worker~10s-20s": ~10s-20s must come before the +`},
+		{"sub+bq=p.d.t!writemetadata", `Subscription "sub+bq=p.d.t!writemetadata": !writemetadata must come before the +`},
+		{"sub+a+b", `Subscription "sub+a+b" has more than one +, escape a + in a push endpoint as \+`},
+		{"sub!usetopicschema", `Subscription "sub!usetopicschema": !usetopicschema and !writemetadata need a BigQuery table, such as +bq=project.dataset.table`},
+		{"sub+bq=", `Subscription "sub+bq=": expected a BigQuery table after bq=, such as bq=project.dataset.table`},
+		{"sub+worker@notanemail", `Subscription "sub+worker@notanemail": expected a service account email after the push endpoint's @, not "notanemail"`},
+		{"sub^", `Subscription "sub" has no dead-letter topic after ^`},
+		{"sub^dlq^dlq2", `Subscription "sub^dlq^dlq2" has more than one dead-letter topic`},
+		{"sub^dlq|4", `Subscription "sub": max delivery attempts must be from 5 to 100, not "4"`},
+		{"sub^dlq|101", `Subscription "sub": max delivery attempts must be from 5 to 100, not "101"`},
+		{"sub^dlq|x", `Subscription "sub": max delivery attempts must be from 5 to 100, not "x"`},
+		{"sub^dlq!ordered", `Subscription "sub^dlq!ordered": !ordered must come before the ^dead-letter topic`},
+		{"sub^dlq|5~10s-20s", `Subscription "sub^dlq|5~10s-20s": ~10s-20s must come before the ^dead-letter topic`},
+		{"sub^dlq+worker", `Subscription "sub^dlq+worker": the +endpoint must come before the ^dead-letter topic`},
+	}
+	for _, test := range tests {
+		_, err := ParseSubscription(test.subscription)
+		if err == nil {
+			t.Errorf("ParseSubscription(%q) succeeded, want error %q", test.subscription, test.want)
+			continue
+		}
+		if err.Error() != test.want {
+			t.Errorf("ParseSubscription(%q) returned error %q, want %q", test.subscription, err, test.want)
+		}
+	}
+}
+
+func TestAddDeadLetterTopics(t *testing.T) {
+	topics := Topics{
+		{ID: "orders", Subscriptions: []Subscription{
+			{ID: "orders-worker", DeadLetterTopic: "orders-dlq"},
+			{ID: "orders-audit", DeadLetterTopic: "shipments"},
+		}},
+		{ID: "shipments", Subscriptions: []Subscription{
+			{ID: "shipments-worker", DeadLetterTopic: "orders-dlq"},
+			{ID: "shipments-audit", DeadLetterTopic: "shipments-dlq"},
+		}},
+	}
+	topics.AddDeadLetterTopics()
+	want := []string{"orders", "shipments", "orders-dlq", "shipments-dlq"}
+	if got := topics.IDs(); !reflect.DeepEqual(got, want) {
+		t.Errorf("AddDeadLetterTopics() declared %q, want %q", got, want)
+	}
+	if got := topics[2].Subscriptions; got != nil {
+		t.Errorf("Dead-letter topic has subscriptions %+v, want none", got)
+	}
+}
@@ -0,0 +1,96 @@
+package pubsubc
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// DescribeLabels lists labels as key=value, sorted by key.
+func DescribeLabels(labels map[string]string) string {
+	pairs := make([]string, 0, len(labels))
+	for key, value := range labels {
+		pairs = append(pairs, key+"="+value)
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
+
+// DescribePushAuth names the service account, and any audience, of the OIDC
+// token a push subscription sends.
+func DescribePushAuth(subscription Subscription) string {
+	if subscription.PushAudience == "" {
+		return subscription.PushServiceAccount
+	}
+	return fmt.Sprintf("%s with audience %q", subscription.PushServiceAccount, subscription.PushAudience)
+}
+
+// DescribeBigQuery names the table a BigQuery subscription writes to, and
+// how.
+func DescribeBigQuery(subscription Subscription) string {
+	var settings []string
+	if subscription.BigQueryUseTopicSchema {
+		settings = append(settings, "topic schema")
+	}
+	if subscription.BigQueryWriteMetadata {
+		settings = append(settings, "metadata")
+	}
+	if len(settings) == 0 {
+		return fmt.Sprintf("%q", subscription.BigQueryTable)
+	}
+	return fmt.Sprintf("%q using %s", subscription.BigQueryTable, strings.Join(settings, " and "))
+}
+
+// DescribeBackoff describes the retry policy of a subscription, naming the
+// service defaults for a backoff it doesn't set.
+func DescribeBackoff(subscription Subscription) string {
+	minimum, maximum := "10s (default)", "600s (default)"
+	if subscription.MinBackoff > 0 {
+		minimum = subscription.MinBackoff.String()
+	}
+	if subscription.MaxBackoff > 0 {
+		maximum = subscription.MaxBackoff.String()
+	}
+	return fmt.Sprintf("backoff %s to %s", minimum, maximum)
+}
+
+// creationNote describes a subscription's message ordering, retry policy and
+// labels, for the log line of creating it.
+func creationNote(subscription Subscription) string {
+	var notes []string
+	if subscription.Ordered {
+		notes = append(notes, "message ordering")
+	}
+	if subscription.MinBackoff > 0 || subscription.MaxBackoff > 0 {
+		notes = append(notes, "retry "+DescribeBackoff(subscription))
+	}
+	if len(notes) == 0 {
+		return labelsNote(subscription.Labels)
+	}
+	return " with " + strings.Join(notes, " and ") + labelsNote(subscription.Labels)
+}
+
+// topicNote describes a topic's message retention and schema, for the log
+// line of creating it.
+func topicNote(entry Topic) string {
+	var notes []string
+	if entry.Retention > 0 {
+		notes = append(notes, "retention "+entry.Retention.String())
+	}
+	if entry.Schema != "" {
+		notes = append(notes, fmt.Sprintf("schema %q (%s)", entry.Schema, entry.Encoding()))
+	}
+	if len(notes) == 0 {
+		return labelsNote(entry.Labels)
+	}
+	return " with " + strings.Join(notes, " and ") + labelsNote(entry.Labels)
+}
+
+// labelsNote lists the labels a topic or subscription is created with, for
+// the log line of creating it.
+func labelsNote(labels map[string]string) string {
+	if len(labels) == 0 {
+		return ""
+	}
+	return " labelled " + DescribeLabels(labels)
+}
@@ -0,0 +1,121 @@
+package pubsubc
+
+import "strings"
+
+// A backslash in a config string escapes the character after it, so that a
+// separator such as , : + ^ | @ # ! or ~, or a backslash itself, can appear
+// in a value. Strings are split on the unescaped separators first, and only
+// each value is unescaped, so that an escaped | in a push endpoint stays a |
+// rather than becoming a :.
+
+// escaped are the characters Escape puts a backslash before.
+const escaped = `,:+^|@#!~\`
+
+// Escape returns value with a backslash before every separator, and every
+// backslash, so that it parses back as value wherever it is in a config
+// string.
+func Escape(value string) string {
+	if !strings.ContainsAny(value, escaped) {
+		return value
+	}
+	var b strings.Builder
+	for i := 0; i < len(value); i++ {
+		if strings.IndexByte(escaped, value[i]) >= 0 {
+			b.WriteByte('\\')
+		}
+		b.WriteByte(value[i])
+	}
+	return b.String()
+}
+
+// splitUnescaped splits s around every unescaped sep, leaving any escapes in
+// the parts.
+func splitUnescaped(s string, sep byte) []string {
+	var parts []string
+	start := 0
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			i++
+		case sep:
+			parts = append(parts, s[start:i])
+			start = i + 1
+		}
+	}
+	return append(parts, s[start:])
+}
+
+// cutUnescaped slices s around the first unescaped sep, like strings.Cut.
+func cutUnescaped(s string, sep byte) (before, after string, found bool) {
+	if i := indexUnescaped(s, sep); i >= 0 {
+		return s[:i], s[i+1:], true
+	}
+	return s, "", false
+}
+
+// indexUnescaped returns the index of the first unescaped sep in s, or -1.
+func indexUnescaped(s string, sep byte) int {
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			i++
+		case sep:
+			return i
+		}
+	}
+	return -1
+}
+
+// splitBefore splits s before every unescaped byte of seps, so that each part
+// after the first starts with the separator it was split at.
+func splitBefore(s, seps string) []string {
+	var parts []string
+	start := 0
+	for i := 0; i < len(s); i++ {
+		switch {
+		case s[i] == '\\':
+			i++
+		case strings.IndexByte(seps, s[i]) >= 0:
+			parts = append(parts, s[start:i])
+			start = i
+		}
+	}
+	return append(parts, s[start:])
+}
+
+// countUnescaped counts the unescaped occurrences of sep in s.
+func countUnescaped(s string, sep byte) int {
+	return len(splitUnescaped(s, sep)) - 1
+}
+
+// replaceUnescaped replaces the first n unescaped occurrences of old in s
+// with new.
+func replaceUnescaped(s string, old, new byte, n int) string {
+	b := []byte(s)
+	for i := 0; i < len(b) && n > 0; i++ {
+		switch b[i] {
+		case '\\':
+			i++
+		case old:
+			b[i] = new
+			n--
+		}
+	}
+	return string(b)
+}
+
+// unescape removes the backslashes escaping characters in a value. A
+// trailing backslash is kept.
+func unescape(s string) string {
+	if !strings.Contains(s, `\`) {
+		return s
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+1 < len(s) {
+			i++
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
@@ -0,0 +1,79 @@
+package pubsubc
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSplitUnescaped(t *testing.T) {
+	tests := []struct {
+		s    string
+		want []string
+	}{
+		{"a:b:c", []string{"a", "b", "c"}},
+		{`a\:b:c`, []string{`a\:b`, "c"}},
+		{`a\\:b`, []string{`a\\`, "b"}},
+		{`a:b\`, []string{"a", `b\`}},
+		{"", []string{""}},
+		{":", []string{"", ""}},
+	}
+	for _, test := range tests {
+		if got := splitUnescaped(test.s, ':'); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("splitUnescaped(%q) = %q, want %q", test.s, got, test.want)
+		}
+	}
+}
+
+func TestCutUnescaped(t *testing.T) {
+	tests := []struct {
+		s             string
+		before, after string
+		found         bool
+	}{
+		{"a@b@c", "a", "b@c", true},
+		{`a\@b@c`, `a\@b`, "c", true},
+		{`a\@b`, `a\@b`, "", false},
+		{`a\`, `a\`, "", false},
+	}
+	for _, test := range tests {
+		before, after, found := cutUnescaped(test.s, '@')
+		if before != test.before || after != test.after || found != test.found {
+			t.Errorf("cutUnescaped(%q) = %q, %q, %v, want %q, %q, %v", test.s, before, after, found, test.before, test.after, test.found)
+		}
+	}
+}
+
+func TestReplaceUnescaped(t *testing.T) {
+	tests := []struct {
+		s    string
+		want string
+	}{
+		{"http|//host|8080/path|x", "http://host:8080/path|x"},
+		{`http|//host\|8080|1`, `http://host\|8080:1`},
+		{"host", "host"},
+	}
+	for _, test := range tests {
+		if got := replaceUnescaped(test.s, '|', ':', 2); got != test.want {
+			t.Errorf("replaceUnescaped(%q) = %q, want %q", test.s, got, test.want)
+		}
+	}
+}
+
+func TestUnescape(t *testing.T) {
+	tests := []struct {
+		s    string
+		want string
+	}{
+		{"plain", "plain"},
+		{`a\:b`, "a:b"},
+		{`a\\b`, `a\b`},
+		{`\,\+\|\@\#\^\~\!`, ",+|@#^~!"},
+		{`trailing\`, `trailing\`},
+		{`\x`, "x"},
+	}
+	for _, test := range tests {
+		if got := unescape(test.s); got != test.want {
+			t.Errorf("unescape(%q) = %q, want %q", test.s, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,102 @@
+package pubsubc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// IsTransient reports whether err means Pub/Sub couldn't be reached or was
+// too slow to answer, so that the same request may succeed if retried.
+func IsTransient(err error) bool {
+	if code := status.Code(err); code == codes.Unavailable || code == codes.DeadlineExceeded {
+		return true
+	}
+	var urlErr *url.Error
+	return errors.As(err, &urlErr)
+}
+
+// Retry calls call until it succeeds, fails with an error that isn't
+// transient, or has been retried retries times, backing off exponentially
+// from 250ms to 5s. onRetry, if not nil, is told of each failed attempt
+// before it is retried.
+func Retry(ctx context.Context, retries int, call func() error, onRetry func(attempt int, backoff time.Duration, err error)) error {
+	backoff := 250 * time.Millisecond
+	for attempt := 1; ; attempt++ {
+		err := call()
+		if err == nil || !IsTransient(err) || ctx.Err() != nil {
+			return err
+		}
+		if attempt > retries {
+			if retries == 0 {
+				return err
+			}
+			return fmt.Errorf("%w (after %d attempts)", err, attempt)
+		}
+		if onRetry != nil {
+			onRetry(attempt, backoff, err)
+		}
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(backoff):
+		}
+		if backoff *= 2; backoff > 5*time.Second {
+			backoff = 5 * time.Second
+		}
+	}
+}
+
+// retryingTopology retries the calls of a topology that fail with a
+// transient error, as WithRetries says.
+type retryingTopology struct {
+	Topology
+	o *options
+}
+
+// retry retries call, logging each retry with the fields of what it is
+// about.
+func (t retryingTopology) retry(ctx context.Context, what string, fields []any, call func() error) error {
+	return Retry(ctx, t.o.retries, call, func(attempt int, backoff time.Duration, err error) {
+		t.o.log(fields, "Request for %s failed (attempt %d), retrying in %s: %s", what, attempt, backoff, err)
+	})
+}
+
+func (t retryingTopology) TopicExists(ctx context.Context, topicID string) (exists bool, err error) {
+	err = t.retry(ctx, fmt.Sprintf("topic %q", topicID), []any{"topic", topicID}, func() error {
+		exists, err = t.Topology.TopicExists(ctx, topicID)
+		return err
+	})
+	return exists, err
+}
+
+func (t retryingTopology) CreateTopic(ctx context.Context, topic Topic) error {
+	return t.retry(ctx, fmt.Sprintf("topic %q", topic.ID), []any{"topic", topic.ID}, func() error {
+		return t.Topology.CreateTopic(ctx, topic)
+	})
+}
+
+func (t retryingTopology) CreateSubscription(ctx context.Context, topicID string, subscription Subscription) error {
+	return t.retry(ctx, fmt.Sprintf("subscription %q", subscription.ID), []any{"topic", topicID, "subscription", subscription.ID}, func() error {
+		return t.Topology.CreateSubscription(ctx, topicID, subscription)
+	})
+}
+
+func (t retryingTopology) SubscriptionPushEndpoint(ctx context.Context, subscriptionID string) (endpoint string, exists bool, err error) {
+	err = t.retry(ctx, fmt.Sprintf("subscription %q", subscriptionID), []any{"subscription", subscriptionID}, func() error {
+		endpoint, exists, err = t.Topology.SubscriptionPushEndpoint(ctx, subscriptionID)
+		return err
+	})
+	return endpoint, exists, err
+}
+
+func (t retryingTopology) UpdatePushConfig(ctx context.Context, subscription Subscription) error {
+	return t.retry(ctx, fmt.Sprintf("subscription %q", subscription.ID), []any{"subscription", subscription.ID}, func() error {
+		return t.Topology.UpdatePushConfig(ctx, subscription)
+	})
+}
@@ -0,0 +1,143 @@
+package pubsubc
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"cloud.google.com/go/pubsub"
+	"cloud.google.com/go/pubsub/apiv1/pubsubpb"
+	"cloud.google.com/go/pubsub/pstest"
+	"google.golang.org/api/option"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+)
+
+// newTestClient starts a fake Pub/Sub server with the given reactors and
+// returns a client of project p connected to it.
+func newTestClient(t *testing.T, reactors ...pstest.ServerReactorOption) *pubsub.Client {
+	t.Helper()
+	srv := pstest.NewServer(reactors...)
+	t.Cleanup(func() { srv.Close() })
+	conn, err := grpc.Dial(srv.Addr, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	client, err := pubsub.NewClient(context.Background(), "p", option.WithGRPCConn(conn), option.WithTelemetryDisabled())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { client.Close() })
+	return client
+}
+
+// failingReactor fails the first failures requests for a resource, all of
+// them if failures is negative, with code, counting every request for it.
+type failingReactor struct {
+	name     string
+	code     codes.Code
+	failures int64
+	calls    atomic.Int64
+}
+
+func (r *failingReactor) React(req interface{}) (bool, interface{}, error) {
+	var name string
+	switch req := req.(type) {
+	case *pubsubpb.Topic:
+		name = req.Name
+	case *pubsubpb.Subscription:
+		name = req.Name
+	}
+	if !strings.HasSuffix(name, "/"+r.name) {
+		return false, nil, nil
+	}
+	if calls := r.calls.Add(1); r.failures < 0 || calls <= r.failures {
+		return true, nil, status.Error(r.code, "injected failure")
+	}
+	return false, nil, nil
+}
+
+func mustParseConfig(t *testing.T, config string) ProjectConfig {
+	t.Helper()
+	parsed, err := ParseConfig(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return parsed
+}
+
+func TestApplyRetriesTransientErrors(t *testing.T) {
+	ctx := context.Background()
+	for _, code := range []codes.Code{codes.Unavailable, codes.DeadlineExceeded} {
+		t.Run(code.String(), func(t *testing.T) {
+			topicReactor := &failingReactor{name: "orders", code: code, failures: 2}
+			subscriptionReactor := &failingReactor{name: "orders-worker", code: code, failures: 1}
+			client := newTestClient(t,
+				pstest.ServerReactorOption{FuncName: "CreateTopic", Reactor: topicReactor},
+				pstest.ServerReactorOption{FuncName: "CreateSubscription", Reactor: subscriptionReactor},
+			)
+
+			config := mustParseConfig(t, "p,orders:orders-worker")
+			if err := Apply(ctx, config, WithClient(client), WithRetries(3)); err != nil {
+				t.Fatalf("Apply() = %v, want nil", err)
+			}
+			if calls := topicReactor.calls.Load(); calls != 3 {
+				t.Errorf("CreateTopic called %d times, want 3", calls)
+			}
+			if calls := subscriptionReactor.calls.Load(); calls != 2 {
+				t.Errorf("CreateSubscription called %d times, want 2", calls)
+			}
+			if exists, err := client.Subscription("orders-worker").Exists(ctx); err != nil || !exists {
+				t.Errorf("Subscription exists = %v, %v, want true", exists, err)
+			}
+		})
+	}
+}
+
+func TestApplyGivesUpAfterRetries(t *testing.T) {
+	reactor := &failingReactor{name: "orders", code: codes.DeadlineExceeded, failures: -1}
+	client := newTestClient(t, pstest.ServerReactorOption{FuncName: "CreateTopic", Reactor: reactor})
+
+	err := Apply(context.Background(), mustParseConfig(t, "p,orders:orders-worker"), WithClient(client), WithRetries(2))
+	var resourceErr *ResourceError
+	if !errors.As(err, &resourceErr) || resourceErr.Topic != "orders" {
+		t.Fatalf("Apply() = %v, want a ResourceError for topic orders", err)
+	}
+	if !strings.Contains(err.Error(), "after 3 attempts") {
+		t.Errorf("Apply() = %v, want a failure after 3 attempts", err)
+	}
+	if calls := reactor.calls.Load(); calls != 3 {
+		t.Errorf("CreateTopic called %d times, want 3", calls)
+	}
+}
+
+func TestApplyDoesNotRetryOtherErrors(t *testing.T) {
+	ctx := context.Background()
+	reactor := &failingReactor{name: "bad", code: codes.InvalidArgument, failures: -1}
+	client := newTestClient(t, pstest.ServerReactorOption{FuncName: "CreateSubscription", Reactor: reactor})
+
+	err := Apply(ctx, mustParseConfig(t, "p,orders:bad:good,shipments"), WithClient(client), WithRetries(3))
+	if calls := reactor.calls.Load(); calls != 1 {
+		t.Errorf("CreateSubscription called %d times, want 1", calls)
+	}
+	var resourceErr *ResourceError
+	if !errors.As(err, &resourceErr) {
+		t.Fatalf("Apply() = %v, want a ResourceError", err)
+	}
+	if resourceErr.Topic != "orders" || resourceErr.Subscription != "bad" {
+		t.Errorf("ResourceError is for %q/%q, want orders/bad", resourceErr.Topic, resourceErr.Subscription)
+	}
+	if joined, ok := err.(interface{ Unwrap() []error }); !ok || len(joined.Unwrap()) != 1 {
+		t.Errorf("Apply() = %v, want exactly one joined error", err)
+	}
+	if exists, err := client.Subscription("good").Exists(ctx); err != nil || !exists {
+		t.Errorf("Subscription good exists = %v, %v, want true", exists, err)
+	}
+	if exists, err := client.Topic("shipments").Exists(ctx); err != nil || !exists {
+		t.Errorf("Topic shipments exists = %v, %v, want true", exists, err)
+	}
+}
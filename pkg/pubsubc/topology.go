@@ -0,0 +1,87 @@
+package pubsubc
+
+import (
+	"context"
+
+	"cloud.google.com/go/pubsub"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Topology is the part of the Pub/Sub API that Apply uses. NewTopology
+// implements it with the client library.
+type Topology interface {
+	TopicExists(ctx context.Context, topicID string) (bool, error)
+	// CreateTopic creates a topic, returning an AlreadyExists error if there
+	// is one with the same ID.
+	CreateTopic(ctx context.Context, topic Topic) error
+	// CreateSubscription creates a subscription on a topic, returning an
+	// AlreadyExists error if there is one with the same ID.
+	CreateSubscription(ctx context.Context, topicID string, subscription Subscription) error
+	// SubscriptionPushEndpoint returns the push endpoint of a subscription,
+	// which is empty for a pull subscription, and whether it exists at all.
+	SubscriptionPushEndpoint(ctx context.Context, subscriptionID string) (string, bool, error)
+	// UpdatePushConfig sets the push endpoint, and any authentication, of an
+	// existing subscription to those of subscription.
+	UpdatePushConfig(ctx context.Context, subscription Subscription) error
+}
+
+// NewTopology returns the Topology of a client's project.
+func NewTopology(client *pubsub.Client) Topology {
+	return clientTopology{client}
+}
+
+type clientTopology struct {
+	client *pubsub.Client
+}
+
+func (t clientTopology) TopicExists(ctx context.Context, topicID string) (bool, error) {
+	return t.client.Topic(topicID).Exists(ctx)
+}
+
+func (t clientTopology) CreateTopic(ctx context.Context, topic Topic) error {
+	_, err := t.client.CreateTopicWithConfig(ctx, topic.ID, topic.Config(t.client.Project()))
+	return err
+}
+
+func (t clientTopology) CreateSubscription(ctx context.Context, topicID string, subscription Subscription) error {
+	_, err := t.client.CreateSubscription(ctx, subscription.ID, subscription.Config(t.client.Topic(topicID)))
+	return err
+}
+
+func (t clientTopology) SubscriptionPushEndpoint(ctx context.Context, subscriptionID string) (string, bool, error) {
+	config, err := t.client.Subscription(subscriptionID).Config(ctx)
+	if status.Code(err) == codes.NotFound {
+		return "", false, nil
+	}
+	if err != nil {
+		return "", false, err
+	}
+	return config.PushConfig.Endpoint, true, nil
+}
+
+func (t clientTopology) UpdatePushConfig(ctx context.Context, subscription Subscription) error {
+	pushConfig := subscription.PushConfig()
+	_, err := t.client.Subscription(subscription.ID).Update(ctx, pubsub.SubscriptionConfigToUpdate{
+		PushConfig: &pushConfig,
+	})
+	return err
+}
+
+// ResourceError is the failure of a single topic, or of a subscription on
+// it. Apply returns every one it meets, joined.
+type ResourceError struct {
+	Topic        string
+	Subscription string
+	Err          error
+}
+
+func (e *ResourceError) Error() string { return e.Err.Error() }
+
+func (e *ResourceError) Unwrap() error { return e.Err }
+
+// isAlreadyExists reports whether err is from creating a resource that
+// already exists.
+func isAlreadyExists(err error) bool {
+	return status.Code(err) == codes.AlreadyExists
+}
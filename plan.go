@@ -0,0 +1,333 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"cloud.google.com/go/pubsub"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// planVersion is the version of the plan file format written by plan.
+const planVersion = 1
+
+// plan is the saved result of comparing the discovered configs with the
+// emulator.
+type plan struct {
+	Version   int          `json:"version"`
+	CreatedAt time.Time    `json:"createdAt"`
+	Actions   []planAction `json:"actions"`
+	// Totals are the resources the configs declare, whether or not they
+	// already exist.
+	Totals *resourceTotals `json:"totals,omitempty"`
+}
+
+// planAction is a single change to a topic or subscription. Fingerprint
+// identifies the state of the resource the action was planned against.
+type planAction struct {
+	Host                 string `json:"host,omitempty"`
+	Project              string `json:"project"`
+	Kind                 string `json:"kind"`
+	Name                 string `json:"name"`
+	Action               string `json:"action"`
+	Topic                string `json:"topic,omitempty"`
+	PushEndpoint         string `json:"pushEndpoint,omitempty"`
+	PreviousPushEndpoint string `json:"previousPushEndpoint,omitempty"`
+	Fingerprint          string `json:"fingerprint"`
+}
+
+func (a planAction) String() string {
+	name := a.Project + "/" + a.Name
+	if a.Host != "" {
+		name = a.Host + " " + name
+	}
+	switch {
+	case a.Kind == "topic":
+		return fmt.Sprintf("+ topic %s", name)
+	case a.Action == "create" && a.PushEndpoint != "":
+		return fmt.Sprintf("+ subscription %s (topic %s, push %s)", name, a.Topic, a.PushEndpoint)
+	case a.Action == "create":
+		return fmt.Sprintf("+ subscription %s (topic %s)", name, a.Topic)
+	default:
+		return fmt.Sprintf("~ subscription %s push endpoint %q -> %q", name, a.PreviousPushEndpoint, a.PushEndpoint)
+	}
+}
+
+// resourceState is what a plan knows about a resource before changing it.
+type resourceState struct {
+	Exists       bool   `json:"exists"`
+	Topic        string `json:"topic,omitempty"`
+	PushEndpoint string `json:"pushEndpoint,omitempty"`
+}
+
+func (s resourceState) fingerprint() string {
+	encoded, _ := json.Marshal(s)
+	sum := sha256.Sum256(encoded)
+	return hex.EncodeToString(sum[:8])
+}
+
+// planClients caches a client per emulator host and project.
+type planClients map[[2]string]*pubsub.Client
+
+func (c planClients) get(ctx context.Context, host, projectID string) (*pubsub.Client, error) {
+	key := [2]string{host, projectID}
+	if client, ok := c[key]; ok {
+		return client, nil
+	}
+	client, err := connect(ctx, projectID, host)
+	if err != nil {
+		return nil, err
+	}
+	c[key] = client
+	return client, nil
+}
+
+// observeTopic returns the current state of a topic.
+func observeTopic(ctx context.Context, client *pubsub.Client, topicID string) (resourceState, error) {
+	exists, err := client.Topic(topicID).Exists(ctx)
+	if err != nil {
+		return resourceState{}, fmt.Errorf("Failed to check existence of topic %q: %s", topicID, err)
+	}
+	return resourceState{Exists: exists}, nil
+}
+
+// observeSubscription returns the current state of a subscription.
+func observeSubscription(ctx context.Context, client *pubsub.Client, subscriptionID string) (resourceState, error) {
+	config, err := client.Subscription(subscriptionID).Config(ctx)
+	if status.Code(err) == codes.NotFound {
+		return resourceState{}, nil
+	}
+	if err != nil {
+		return resourceState{}, fmt.Errorf("Unable to fetch subscription %q: %s", subscriptionID, err)
+	}
+	return resourceState{Exists: true, Topic: subscriptionTopic(config.Topic), PushEndpoint: config.PushConfig.Endpoint}, nil
+}
+
+// runPlan compares the discovered configs with the emulator and writes the
+// changes needed to a plan file, without making any of them.
+func runPlan(args []string) {
+	flags := flag.NewFlagSet("plan", flag.ExitOnError)
+	output := flags.String("o", "", "File to write the plan to")
+	flags.Parse(args)
+
+	if *output == "" {
+		flags.Usage()
+		fatalf("-o is required")
+	}
+
+	discoverConfigs()
+	if configCount == 0 {
+		fatalf("No Pub/Sub configurations found")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	result := plan{Version: planVersion, CreatedAt: time.Now().UTC(), Actions: []planAction{}}
+	var subscriptionActions []planAction
+	clients := make(planClients)
+	failed := false
+	// Configs may declare the same resource more than once.
+	planned := make(map[string]bool)
+	add := func(action planAction) bool {
+		key := action.Host + " " + action.Project + " " + action.Kind + " " + action.Name
+		if planned[key] {
+			return false
+		}
+		planned[key] = true
+		return true
+	}
+	hosts, byHost := pendingTargets()
+	checkLimits(hosts, byHost)
+	result.Totals = &declaredTotals
+	for _, host := range hosts {
+		for _, target := range byHost[host] {
+			config := target.config
+			client, err := clients.get(ctx, host, config.projectID)
+			if err != nil {
+				warnf("%s: %s", config.sourceHint, err)
+				failed = true
+				continue
+			}
+
+			for _, entry := range config.topics {
+				topicID := entry.ID
+				state, err := observeTopic(ctx, client, topicID)
+				if err != nil {
+					warnf("%s: %s", config.sourceHint, err)
+					failed = true
+					continue
+				}
+				action := planAction{Host: host, Project: config.projectID, Kind: "topic", Name: topicID, Action: "create", Fingerprint: state.fingerprint()}
+				if !state.Exists && config.subscriptionsOnly && *missingTopic != "create" {
+					warnf("%s: Topic %q doesn't exist, and the config only declares subscriptions", config.sourceHint, topicID)
+					failed = true
+					continue
+				}
+				if !state.Exists && add(action) {
+					result.Actions = append(result.Actions, action)
+				}
+
+				for _, subscription := range config.withAutoSub(entry) {
+					subscriptionID, pushEndpoint := subscription.ID, subscription.PushEndpoint
+					state, err := observeSubscription(ctx, client, subscriptionID)
+					if err != nil {
+						warnf("%s: %s", config.sourceHint, err)
+						failed = true
+						continue
+					}
+					action := planAction{Host: host, Project: config.projectID, Kind: "subscription", Name: subscriptionID, Topic: topicID, PushEndpoint: pushEndpoint, Fingerprint: state.fingerprint()}
+					switch {
+					case !state.Exists:
+						action.Action = "create"
+					case state.Topic != topicID:
+						warnf("%s: Subscription %q is on topic %q instead of %q and can't be moved", config.sourceHint, subscriptionID, state.Topic, topicID)
+						failed = true
+						continue
+					case state.PushEndpoint != pushEndpoint:
+						action.Action = "update"
+						action.PreviousPushEndpoint = state.PushEndpoint
+					default:
+						continue
+					}
+					if add(action) {
+						subscriptionActions = append(subscriptionActions, action)
+					}
+				}
+			}
+		}
+	}
+	if failed {
+		fatalf("Unable to plan every config, not writing %s", *output)
+	}
+	// As when applying, every topic comes before any subscription.
+	result.Actions = append(result.Actions, subscriptionActions...)
+
+	encoded, err := json.MarshalIndent(result, "", "  ")
+	if err != nil {
+		fatalf("Unable to encode plan: %s", err)
+	}
+	if err := os.WriteFile(*output, append(encoded, '\n'), 0644); err != nil {
+		fatalf("Unable to write %s: %s", *output, err)
+	}
+	printPlan(result)
+	fmt.Printf("Wrote plan to %s\n", *output)
+}
+
+// readPlan loads a plan file, rejecting versions this build doesn't know.
+func readPlan(path string) plan {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		fatalf("Unable to read plan: %s", err)
+	}
+	var result plan
+	if err := json.Unmarshal(data, &result); err != nil {
+		fatalf("Unable to parse plan %s: %s", path, err)
+	}
+	if result.Version != planVersion {
+		fatalf("Plan %s has version %d, but only version %d is supported", path, result.Version, planVersion)
+	}
+	return result
+}
+
+// printPlan prints a plan one action per line, followed by a summary.
+func printPlan(result plan) {
+	created, updated := 0, 0
+	for _, action := range result.Actions {
+		fmt.Println(action)
+		if action.Action == "create" {
+			created++
+		} else {
+			updated++
+		}
+	}
+	fmt.Printf("Plan: %d to create, %d to update\n", created, updated)
+}
+
+// runShowPlan prints a saved plan.
+func runShowPlan(args []string) {
+	flags := flag.NewFlagSet("show-plan", flag.ExitOnError)
+	flags.Parse(args)
+	if flags.NArg() != 1 {
+		fatalf("Usage: show-plan <plan file>")
+	}
+
+	result := readPlan(flags.Arg(0))
+	fmt.Printf("Plan created at %s\n", result.CreatedAt.Format(time.RFC3339))
+	if result.Totals != nil {
+		fmt.Printf("Configs declare %d topics and %d subscriptions\n", result.Totals.Topics, result.Totals.Subscriptions)
+	}
+	printPlan(result)
+}
+
+// runApplyPlan makes exactly the changes in a saved plan, refusing to start if
+// any resource has changed since the plan was made.
+func runApplyPlan(args []string) {
+	flags := flag.NewFlagSet("apply", flag.ExitOnError)
+	planFile := flags.String("plan", "", "Plan file written by plan")
+	flags.Parse(args)
+
+	if *planFile == "" {
+		flags.Usage()
+		fatalf("-plan is required")
+	}
+	result := readPlan(*planFile)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	clients := make(planClients)
+	drifted := 0
+	for _, action := range result.Actions {
+		client, err := clients.get(ctx, action.Host, action.Project)
+		if err != nil {
+			fatalf("%s", err)
+		}
+		var state resourceState
+		if action.Kind == "topic" {
+			state, err = observeTopic(ctx, client, action.Name)
+		} else {
+			state, err = observeSubscription(ctx, client, action.Name)
+		}
+		if err != nil {
+			fatalf("%s", err)
+		}
+		if state.fingerprint() != action.Fingerprint {
+			warnf("%s %s/%s has changed since the plan was made", action.Kind, action.Project, action.Name)
+			drifted++
+		}
+	}
+	if drifted > 0 {
+		fatalf("Refusing to apply %s: %d resources have drifted, run plan again", *planFile, drifted)
+	}
+
+	for _, action := range result.Actions {
+		client, _ := clients.get(ctx, action.Host, action.Project)
+		fmt.Println(action)
+		var err error
+		switch {
+		case action.Kind == "topic":
+			_, err = client.CreateTopic(ctx, action.Name)
+		case action.Action == "create":
+			_, err = client.CreateSubscription(ctx, action.Name, pubsub.SubscriptionConfig{
+				Topic:      client.Topic(action.Topic),
+				PushConfig: pubsub.PushConfig{Endpoint: action.PushEndpoint},
+			})
+		default:
+			pushConfig := pubsub.PushConfig{Endpoint: action.PushEndpoint}
+			_, err = client.Subscription(action.Name).Update(ctx, pubsub.SubscriptionConfigToUpdate{PushConfig: &pushConfig})
+		}
+		if err != nil {
+			fatalf("Unable to %s %s %s/%s: %s", action.Action, action.Kind, action.Project, action.Name, err)
+		}
+		audit(auditEvent{Action: action.Action + "d", Kind: action.Kind, Project: action.Project, Name: action.Name, Host: action.Host, Source: "apply " + *planFile})
+	}
+	fmt.Printf("Applied %d planned changes\n", len(result.Actions))
+}
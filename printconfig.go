@@ -0,0 +1,222 @@
+package main
+
+import (
+	"encoding/base64"
+	"flag"
+	"fmt"
+	"os"
+	"reflect"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"gopkg.in/yaml.v3"
+)
+
+var (
+	printConfig = flag.Bool("print-config", false, "Print the discovered configs as a YAML config file, without connecting to Pub/Sub")
+	omitAutoSub = flag.Bool("omit-auto-sub", false, "Leave the subscriptions -auto-sub and autoSub create out of -dry-run and -print-config")
+)
+
+// runPrintConfig prints the discovered configs, from every source, as a
+// single config file that -config reads back, and exits 1 if any config is
+// invalid.
+func runPrintConfig() {
+	if configCount == 0 {
+		fatalf("No Pub/Sub configurations found")
+	}
+	problems := skippedConfigs + skippedSeeds
+	var file fileConfig
+	for _, config := range pendingConfigs {
+		project, unprinted := printedProject(config)
+		file.Projects = append(file.Projects, project)
+		problems += unprinted
+	}
+
+	encoder := yaml.NewEncoder(os.Stdout)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(file); err != nil {
+		fatalf("Unable to print the configs: %s", err)
+	}
+	encoder.Close()
+	if problems > 0 {
+		fmt.Fprintf(os.Stderr, "%s: Found %d problems in the configs\n", os.Args[0], problems)
+		os.Exit(1)
+	}
+}
+
+// printedProject returns the config file project of a config, and how many
+// of its seed messages, being for topics it doesn't declare, can't be part
+// of it. The subscriptions autoSub creates are marked, or left out with
+// -omit-auto-sub, the config then keeping autoSub to create them again.
+func printedProject(config *projectConfig) (fileProject, int) {
+	project := fileProject{ID: config.projectID, Host: config.host, SubscriptionsOnly: config.subscriptionsOnly}
+	// A config file may turn autoSub off despite -auto-sub.
+	if config.autoSub || *autoSub {
+		project.AutoSub = &config.autoSub
+	}
+
+	seeds := make(map[string][]fileSeed)
+	for _, seed := range config.seeds {
+		printed := fileSeed{Attributes: seed.attributes}
+		if isText(seed.data) {
+			printed.Data = string(seed.data)
+		} else {
+			printed.Base64 = base64.StdEncoding.EncodeToString(seed.data)
+		}
+		seeds[seed.topicID] = append(seeds[seed.topicID], printed)
+	}
+
+	iam := make(map[string]map[string][]string)
+	for _, policy := range config.iam {
+		iam[policy.kind+" "+policy.id] = policy.bindings
+	}
+
+	for _, entry := range config.topics {
+		topic := fileTopic{ID: entry.ID, Labels: entry.Labels, Seed: seeds[entry.ID], IAM: iam["topic "+entry.ID]}
+		delete(seeds, entry.ID)
+		if entry.Retention > 0 {
+			topic.MessageRetention = formatDuration(entry.Retention)
+		}
+		if entry.Schema != "" {
+			topic.Schema = &fileSchema{ID: entry.Schema, Encoding: entry.SchemaEncoding}
+			for _, schema := range config.schemas {
+				if schema.id == entry.Schema {
+					topic.Schema.Type = strings.ToLower(describeSchemaType(schema.schemaType))
+					topic.Schema.DefinitionFile = schema.path
+				}
+			}
+		}
+		auto := len(entry.Subscriptions) == 0
+		for _, spec := range config.withAutoSub(entry) {
+			if auto {
+				if *omitAutoSub {
+					continue
+				}
+				spec = config.defaults.Apply(spec)
+			}
+			subscription := printedSubscription(spec)
+			subscription.IAM = iam["subscription "+spec.ID]
+			subscription.auto = auto
+			topic.Subscriptions = append(topic.Subscriptions, subscription)
+		}
+		project.Topics = append(project.Topics, topic)
+	}
+
+	for _, hook := range config.hooks {
+		printed := fileHook{Command: hook.command}
+		if hook.timeout > 0 {
+			printed.Timeout = formatDuration(hook.timeout)
+		}
+		project.Hooks = append(project.Hooks, printed)
+	}
+
+	unprinted := 0
+	for _, seed := range config.seeds {
+		if _, ok := seeds[seed.topicID]; ok {
+			warnf("%s: Seed message for topic %q, which %s doesn't declare, can't be printed", seed.sourceHint, seed.topicID, config.sourceHint)
+			unprinted++
+		}
+	}
+	return project, unprinted
+}
+
+// printedSubscription returns the config file subscription of a declared
+// one.
+func printedSubscription(spec subscriptionSpec) fileSubscription {
+	subscription := fileSubscription{
+		ID:                     spec.ID,
+		PushEndpoint:           spec.PushEndpoint,
+		PushServiceAccount:     spec.PushServiceAccount,
+		PushAudience:           spec.PushAudience,
+		BigQueryTable:          spec.BigQueryTable,
+		BigQueryUseTopicSchema: spec.BigQueryUseTopicSchema,
+		BigQueryWriteMetadata:  spec.BigQueryWriteMetadata,
+		AckDeadlineSeconds:     int(spec.AckDeadline / time.Second),
+		RetainAckedMessages:    spec.RetainAcked,
+		DeadLetterTopic:        spec.DeadLetterTopic,
+		MaxDeliveryAttempts:    spec.MaxDeliveryAttempts,
+		EnableMessageOrdering:  spec.Ordered,
+		Filter:                 spec.Filter,
+		Labels:                 spec.Labels,
+	}
+	if spec.Retention > 0 {
+		subscription.MessageRetention = formatDuration(spec.Retention)
+	}
+	if spec.MinBackoff > 0 {
+		subscription.MinimumBackoff = formatDuration(spec.MinBackoff)
+	}
+	if spec.MaxBackoff > 0 {
+		subscription.MaximumBackoff = formatDuration(spec.MaxBackoff)
+	}
+	return subscription
+}
+
+// formatDuration formats a duration like time.Duration.String, without the
+// zero minutes and seconds of whole hours and minutes: 24h rather than 24h0m0s.
+func formatDuration(d time.Duration) string {
+	s := d.String()
+	if strings.HasSuffix(s, "m0s") {
+		s = strings.TrimSuffix(s, "0s")
+	}
+	if strings.HasSuffix(s, "h0m") {
+		s = strings.TrimSuffix(s, "0m")
+	}
+	return s
+}
+
+// isText reports whether seed message data is text that reads well in YAML:
+// valid UTF-8 without control characters other than whitespace.
+func isText(data []byte) bool {
+	if !utf8.Valid(data) {
+		return false
+	}
+	for _, r := range string(data) {
+		if unicode.IsControl(r) && r != '\n' && r != '\t' && r != '\r' {
+			return false
+		}
+	}
+	return true
+}
+
+// MarshalYAML prints a subscription with nothing but an ID as just its ID,
+// as it can be given, and marks one autoSub creates with a comment.
+func (s fileSubscription) MarshalYAML() (interface{}, error) {
+	node := &yaml.Node{}
+	type plain fileSubscription
+	if reflect.DeepEqual(s, fileSubscription{ID: s.ID, auto: s.auto}) {
+		node.SetString(s.ID)
+	} else if err := node.Encode(plain(s)); err != nil {
+		return nil, err
+	}
+	if s.auto {
+		// The comment follows the ID, the first value of a mapping.
+		commented := node
+		if node.Kind == yaml.MappingNode {
+			commented = node.Content[1]
+		}
+		commented.LineComment = "auto-generated by autoSub"
+	}
+	return node, nil
+}
+
+// MarshalYAML prints a hook with nothing but a command as just its command,
+// as it can be given.
+func (h fileHook) MarshalYAML() (interface{}, error) {
+	if h.Timeout == "" {
+		return h.Command, nil
+	}
+	type plain fileHook
+	return plain(h), nil
+}
+
+// MarshalYAML prints a seed message with nothing but text data as just its
+// data, as it can be given.
+func (s fileSeed) MarshalYAML() (interface{}, error) {
+	if s.Base64 == "" && len(s.Attributes) == 0 {
+		return s.Data, nil
+	}
+	type plain fileSeed
+	return plain(s), nil
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+)
+
+func TestPrintedProject(t *testing.T) {
+	config := &projectConfig{projectID: "p", autoSub: true, topics: Topics{
+		{ID: "orders", Subscriptions: []subscriptionSpec{{ID: "orders-worker"}}},
+		{ID: "events"},
+	}}
+	tests := []struct {
+		omit bool
+		want string
+	}{
+		{false, "- id: events\n        subscriptions:\n          - events-sub # auto-generated by autoSub\n"},
+		{true, "- id: events\n"},
+	}
+	for _, test := range tests {
+		*omitAutoSub = test.omit
+		project, _ := printedProject(config)
+		var out strings.Builder
+		encoder := yaml.NewEncoder(&out)
+		encoder.SetIndent(2)
+		if err := encoder.Encode(fileConfig{Projects: []fileProject{project}}); err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(out.String(), "autoSub: true") || !strings.Contains(out.String(), "- orders-worker\n") {
+			t.Errorf("printedProject() with -omit-auto-sub %v printed\n%s\nwant autoSub and the declared subscription", test.omit, &out)
+		}
+		if !strings.HasSuffix(out.String(), test.want) {
+			t.Errorf("printedProject() with -omit-auto-sub %v printed\n%s\nwant it to end with\n%s", test.omit, &out, test.want)
+		}
+	}
+	*omitAutoSub = false
+}
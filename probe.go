@@ -0,0 +1,116 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"sync"
+	"syscall"
+)
+
+// probeResult records the reachability of a single push endpoint.
+type probeResult struct {
+	projectID      string
+	subscriptionID string
+	endpoint       string
+	status         string
+}
+
+var (
+	probeWG      sync.WaitGroup
+	probeMu      sync.Mutex
+	probeCtx     context.Context
+	probeCancel  context.CancelFunc
+	probeResults []probeResult
+)
+
+// probeEndpoint checks in the background whether anything is listening on a
+// push endpoint. It never blocks the caller; results are collected by
+// reportProbes.
+func probeEndpoint(projectID, subscriptionID, endpoint string) {
+	if !*probePush {
+		return
+	}
+
+	// The budget is shared by every probe until they are reported, and starts
+	// with the first.
+	probeMu.Lock()
+	if probeCtx == nil {
+		probeCtx, probeCancel = context.WithTimeout(context.Background(), *probeBudget)
+	}
+	ctx := probeCtx
+	probeMu.Unlock()
+
+	probeWG.Add(1)
+	go func() {
+		defer probeWG.Done()
+		status := probe(ctx, endpoint)
+		debugf("    Probed push endpoint %q: %s", endpoint, status)
+
+		probeMu.Lock()
+		probeResults = append(probeResults, probeResult{projectID, subscriptionID, endpoint, status})
+		probeMu.Unlock()
+	}()
+}
+
+// probe issues a single request to the endpoint and describes the outcome.
+// Any HTTP response, whatever its status code, counts as reachable.
+func probe(ctx context.Context, endpoint string) string {
+	req, err := http.NewRequestWithContext(ctx, *probeMethod, endpoint, nil)
+	if err != nil {
+		return fmt.Sprintf("invalid endpoint: %s", err)
+	}
+
+	client := &http.Client{
+		Timeout: *probeTimeout,
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+	resp, err := client.Do(req)
+	if err == nil {
+		resp.Body.Close()
+		return fmt.Sprintf("reachable (HTTP %d)", resp.StatusCode)
+	}
+
+	var dnsErr *net.DNSError
+	var netErr net.Error
+	switch {
+	case errors.Is(err, syscall.ECONNREFUSED):
+		return "connection refused"
+	case errors.As(err, &dnsErr):
+		return "DNS failure"
+	case errors.Is(err, context.DeadlineExceeded), errors.As(err, &netErr) && netErr.Timeout():
+		return "timeout"
+	default:
+		return fmt.Sprintf("unreachable: %s", err)
+	}
+}
+
+// reportProbes waits for outstanding probes and prints their results. They
+// are forgotten, and the budget released, so that the probes of a later pass
+// get a budget of their own.
+func reportProbes() {
+	if !*probePush {
+		return
+	}
+
+	probeWG.Wait()
+	probeMu.Lock()
+	results := probeResults
+	if probeCancel != nil {
+		probeCancel()
+	}
+	probeCtx, probeCancel, probeResults = nil, nil, nil
+	probeMu.Unlock()
+	if len(results) == 0 {
+		return
+	}
+
+	fmt.Println("Push endpoint probes:")
+	for _, result := range results {
+		fmt.Printf("  %s/%s -> %s: %s\n", result.projectID, result.subscriptionID, result.endpoint, result.status)
+	}
+}
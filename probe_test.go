@@ -0,0 +1,29 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// TestProbeBudgetPerPass checks that probes after a report, as in a later
+// -watch, -listen or -reconcile-interval pass, get a budget of their own.
+func TestProbeBudgetPerPass(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+	*probePush = true
+	defer func() { *probePush = false }()
+
+	for pass := 1; pass <= 3; pass++ {
+		probeEndpoint("p", "push", server.URL)
+		probeWG.Wait()
+		if len(probeResults) != 1 || !strings.HasPrefix(probeResults[0].status, "reachable") {
+			t.Errorf("Pass %d probed %+v, want a single reachable endpoint", pass, probeResults)
+		}
+		reportProbes()
+		if probeResults != nil || probeCtx != nil {
+			t.Errorf("Pass %d left results %+v, want them forgotten once reported", pass, probeResults)
+		}
+	}
+}
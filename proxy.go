@@ -0,0 +1,257 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/signal"
+	"path"
+	"strings"
+	"sync/atomic"
+	"syscall"
+
+	"cloud.google.com/go/pubsub/apiv1/pubsubpb"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+)
+
+const (
+	methodPublish            = "/google.pubsub.v1.Publisher/Publish"
+	methodGetTopic           = "/google.pubsub.v1.Publisher/GetTopic"
+	methodCreateTopic        = "/google.pubsub.v1.Publisher/CreateTopic"
+	methodCreateSubscription = "/google.pubsub.v1.Subscriber/CreateSubscription"
+)
+
+// rawFrame carries an undecoded gRPC message through the proxy.
+type rawFrame struct {
+	payload []byte
+}
+
+// rawCodec passes messages through without decoding them, so the proxy can
+// forward every method without knowing its types.
+type rawCodec struct{}
+
+func (rawCodec) Marshal(v interface{}) ([]byte, error) {
+	return v.(*rawFrame).payload, nil
+}
+
+func (rawCodec) Unmarshal(data []byte, v interface{}) error {
+	frame := v.(*rawFrame)
+	frame.payload = append(frame.payload[:0], data...)
+	return nil
+}
+
+func (rawCodec) Name() string {
+	return "proto"
+}
+
+// topicProxy forwards Pub/Sub API calls to the emulator, creating topics the
+// calls need when they are missing and allowed.
+type topicProxy struct {
+	backend *grpc.ClientConn
+	allow   []string
+	created atomic.Int64
+}
+
+// runProxy serves the Pub/Sub API on a local port, forwarding to the emulator
+// and creating missing topics on demand, until interrupted.
+func runProxy(args []string) {
+	var allow stringList
+	flags := flag.NewFlagSet("proxy", flag.ExitOnError)
+	listen := flags.String("listen", ":8086", "Address to serve the proxied Pub/Sub API on")
+	backend := flags.String("backend", os.Getenv("PUBSUB_EMULATOR_HOST"), "Emulator to forward calls to")
+	flags.Var(&allow, "allow", "Topic ID pattern that may be created on demand, e.g. 'orders-*' (may be repeated)")
+	flags.Parse(args)
+
+	if *backend == "" {
+		flags.Usage()
+		fatalf("-backend or PUBSUB_EMULATOR_HOST is required")
+	}
+	if len(allow) == 0 {
+		flags.Usage()
+		fatalf("At least one -allow pattern is required; use '*' to allow any topic")
+	}
+	for _, pattern := range allow {
+		if _, err := path.Match(pattern, ""); err != nil {
+			fatalf("Invalid -allow pattern %q: %s", pattern, err)
+		}
+	}
+
+	conn, err := grpc.Dial(*backend, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		fatalf("Unable to connect to emulator %s: %s", *backend, err)
+	}
+	defer conn.Close()
+
+	listener, err := net.Listen("tcp", *listen)
+	if err != nil {
+		fatalf("Unable to listen on %s: %s", *listen, err)
+	}
+
+	proxy := &topicProxy{backend: conn, allow: allow}
+	server := grpc.NewServer(
+		grpc.ForceServerCodec(rawCodec{}),
+		grpc.UnknownServiceHandler(proxy.handle),
+	)
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	go func() {
+		<-ctx.Done()
+		server.Stop()
+	}()
+
+	fmt.Printf("Proxying Pub/Sub on %s to %s, creating topics matching %s\n", listener.Addr(), *backend, strings.Join(allow, ", "))
+	if err := server.Serve(listener); err != nil {
+		fatalf("Proxy stopped: %s", err)
+	}
+	fmt.Printf("Proxy stopped after creating %d missing topics\n", proxy.created.Load())
+}
+
+// handle forwards a single call of any method to the emulator.
+func (p *topicProxy) handle(_ interface{}, serverStream grpc.ServerStream) error {
+	method, ok := grpc.MethodFromServerStream(serverStream)
+	if !ok {
+		return status.Error(codes.Internal, "Unable to determine method")
+	}
+	md, _ := metadata.FromIncomingContext(serverStream.Context())
+	ctx := metadata.NewOutgoingContext(serverStream.Context(), md.Copy())
+
+	switch method {
+	case methodPublish, methodGetTopic, methodCreateSubscription:
+		return p.handleTopicCall(ctx, method, serverStream)
+	}
+
+	clientStream, err := p.backend.NewStream(ctx, &grpc.StreamDesc{ServerStreams: true, ClientStreams: true}, method, grpc.ForceCodec(rawCodec{}))
+	if err != nil {
+		return err
+	}
+
+	// Requests flow to the emulator in the background. A failure there also
+	// surfaces when receiving the emulator's response below.
+	go func() {
+		for {
+			frame := &rawFrame{}
+			if err := serverStream.RecvMsg(frame); err != nil {
+				clientStream.CloseSend()
+				return
+			}
+			if err := clientStream.SendMsg(frame); err != nil {
+				return
+			}
+		}
+	}()
+
+	for first := true; ; first = false {
+		frame := &rawFrame{}
+		if err := clientStream.RecvMsg(frame); err != nil {
+			serverStream.SetTrailer(clientStream.Trailer())
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+		if first {
+			if header, err := clientStream.Header(); err == nil {
+				serverStream.SendHeader(header)
+			}
+		}
+		if err := serverStream.SendMsg(frame); err != nil {
+			return err
+		}
+	}
+}
+
+// handleTopicCall forwards a unary call that needs a topic to exist. If the
+// emulator responds NotFound and the topic is allowed, it is created and the
+// call retried once.
+func (p *topicProxy) handleTopicCall(ctx context.Context, method string, serverStream grpc.ServerStream) error {
+	req := &rawFrame{}
+	if err := serverStream.RecvMsg(req); err != nil {
+		return err
+	}
+
+	resp := &rawFrame{}
+	var header, trailer metadata.MD
+	call := func() error {
+		return p.backend.Invoke(ctx, method, req, resp, grpc.ForceCodec(rawCodec{}), grpc.Header(&header), grpc.Trailer(&trailer))
+	}
+
+	err := call()
+	if status.Code(err) == codes.NotFound {
+		if topic := requestTopic(method, req.payload); topic != "" && p.createTopic(ctx, topic, method) {
+			err = call()
+		}
+	}
+
+	serverStream.SetHeader(header)
+	serverStream.SetTrailer(trailer)
+	if err != nil {
+		return err
+	}
+	return serverStream.SendMsg(resp)
+}
+
+// requestTopic returns the full topic name a request refers to.
+func requestTopic(method string, payload []byte) string {
+	switch method {
+	case methodPublish:
+		req := &pubsubpb.PublishRequest{}
+		if proto.Unmarshal(payload, req) == nil {
+			return req.GetTopic()
+		}
+	case methodGetTopic:
+		req := &pubsubpb.GetTopicRequest{}
+		if proto.Unmarshal(payload, req) == nil {
+			return req.GetTopic()
+		}
+	case methodCreateSubscription:
+		req := &pubsubpb.Subscription{}
+		if proto.Unmarshal(payload, req) == nil {
+			return req.GetTopic()
+		}
+	}
+	return ""
+}
+
+// createTopic creates a missing topic if its ID matches the allowlist,
+// reporting whether the original call is worth retrying.
+func (p *topicProxy) createTopic(ctx context.Context, topic string, method string) bool {
+	topicID := topic[strings.LastIndex(topic, "/")+1:]
+	allowed := false
+	for _, pattern := range p.allow {
+		if ok, _ := path.Match(pattern, topicID); ok {
+			allowed = true
+			break
+		}
+	}
+	if !allowed {
+		warnf("Not creating topic %s for %s: it doesn't match any -allow pattern", topic, method)
+		return false
+	}
+
+	payload, err := proto.Marshal(&pubsubpb.Topic{Name: topic})
+	if err != nil {
+		warnf("Unable to create topic %s: %s", topic, err)
+		return false
+	}
+	err = p.backend.Invoke(ctx, methodCreateTopic, &rawFrame{payload}, &rawFrame{}, grpc.ForceCodec(rawCodec{}))
+	switch status.Code(err) {
+	case codes.OK:
+		fmt.Printf("Created missing topic %s for %s\n", topic, method)
+		p.created.Add(1)
+	case codes.AlreadyExists:
+		debugf("Topic %s was created concurrently", topic)
+	default:
+		warnf("Unable to create topic %s for %s: %s", topic, method, err)
+		return false
+	}
+	return true
+}
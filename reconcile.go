@@ -0,0 +1,198 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"cloud.google.com/go/pubsub"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// reconciling is set while configs already applied are being applied again,
+// by -reconcile-interval or -watch-reconcile-interval.
+var reconciling bool
+
+// reconcileConfigs re-applies the discovered configs every
+// -reconcile-interval, so that an emulator that restarts and loses its state
+// gets it back, until interrupted. A signal received during a pass stops the
+// loop once the pass has finished.
+func reconcileConfigs(configs []*projectConfig) {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	reconciling = true
+	fmt.Printf("Re-applying %d Pub/Sub configurations every %s\n", len(configs), *reconcileEvery)
+	for {
+		select {
+		case <-ctx.Done():
+			sdNotify("STOPPING=1")
+			fmt.Println("Stopped re-applying Pub/Sub configurations")
+			return
+		case <-time.After(*reconcileEvery):
+		}
+
+		before := currentApplyTotals()
+
+		debugf("Re-applying %d Pub/Sub configurations", len(configs))
+		resetChecks()
+		var recreated []orphan
+		if *fixOrphans {
+			recreated = fixOrphanedSubscriptions(ctx, configs)
+		}
+		pendingConfigs = append(pendingConfigs, configs...)
+		applyConfigs()
+		relabelOrphans(ctx, recreated)
+		pushRunMetrics()
+		flushAudit()
+		reportProbes()
+		reportDeliveries()
+		reportSmokeTests()
+
+		delta := currentApplyTotals().minus(before)
+		fmt.Printf("Reconciled %d Pub/Sub configurations: %d created, %d already present, %d updated, %d failed\n",
+			len(configs), delta.created, delta.present, delta.updated, delta.failed)
+		notifyApplied(appliedStatus(len(configs), before))
+	}
+}
+
+// applyTotals are the topics and subscriptions created, already present,
+// updated and failed, over every apply so far.
+type applyTotals struct {
+	created, present, updated, failed int64
+}
+
+// currentApplyTotals returns the totals so far.
+func currentApplyTotals() applyTotals {
+	return applyTotals{
+		created: topicCounts.created.Load() + subscriptionCounts.created.Load(),
+		present: topicCounts.skipped.Load() + subscriptionCounts.skipped.Load(),
+		updated: subscriptionCounts.updated.Load(),
+		failed:  topicCounts.failed.Load() + subscriptionCounts.failed.Load(),
+	}
+}
+
+// minus returns the totals since before.
+func (t applyTotals) minus(before applyTotals) applyTotals {
+	return applyTotals{
+		created: t.created - before.created,
+		present: t.present - before.present,
+		updated: t.updated - before.updated,
+		failed:  t.failed - before.failed,
+	}
+}
+
+// orphan is a subscription whose topic was deleted.
+type orphan struct {
+	host, projectID, subscriptionID string
+}
+
+// fixOrphanedSubscriptions deletes the subscriptions of the configs' projects
+// whose topic was deleted and that carry the managed-by label, so that the
+// pass about to run recreates those the configs declare, on their topic. It
+// returns those, to be labelled again once recreated. Orphans that pubsubc
+// doesn't manage are only reported.
+func fixOrphanedSubscriptions(ctx context.Context, configs []*projectConfig) []orphan {
+	pendingConfigs = configs
+	hosts, byHost := pendingTargets()
+
+	var recreated []orphan
+	clients := make(planClients)
+	checked := make(map[string]bool)
+	for _, host := range hosts {
+		for _, target := range byHost[host] {
+			projectID := target.config.projectID
+			if checked[host+" "+projectID] {
+				continue
+			}
+			checked[host+" "+projectID] = true
+			client, err := clients.get(ctx, host, projectID)
+			if err != nil {
+				target.log().warnf("%s: %s", target.config.sourceHint, err)
+				continue
+			}
+			orphans, err := listOrphans(ctx, client)
+			if err != nil {
+				target.log().warnf("%s: Unable to list subscriptions for project %q: %s", target.config.sourceHint, projectID, err)
+				continue
+			}
+
+			topics := declaredTopics(byHost[host], projectID)
+			for _, config := range orphans {
+				subscriptionID := config.ID()
+				log := target.log("subscription", subscriptionID)
+				topicID, declared := topics[subscriptionID]
+				lastTopic := "unknown"
+				if declared {
+					lastTopic = fmt.Sprintf("%q", topicID)
+				}
+				if config.Labels[managedByLabel] != managedByValue {
+					log.warnf("Subscription %q in project %q is orphaned (last known topic %s), but isn't managed by pubsubc, leaving it", subscriptionID, projectID, lastTopic)
+					continue
+				}
+				if err := client.Subscription(subscriptionID).Delete(ctx); err != nil && status.Code(err) != codes.NotFound {
+					log.warnf("Unable to delete orphaned subscription %q in project %q: %s", subscriptionID, projectID, err)
+					continue
+				}
+				target.audit("deleted", "subscription", subscriptionID)
+				if declared {
+					fmt.Printf("Deleted orphaned subscription %q in project %q, to recreate it on topic %q\n", subscriptionID, projectID, topicID)
+					recreated = append(recreated, orphan{host, projectID, subscriptionID})
+				} else {
+					fmt.Printf("Deleted orphaned subscription %q in project %q, which the configs don't declare\n", subscriptionID, projectID)
+				}
+			}
+		}
+	}
+	return recreated
+}
+
+// relabelOrphans labels the orphans fixOrphanedSubscriptions had recreated as
+// managed by pubsubc again.
+func relabelOrphans(ctx context.Context, recreated []orphan) {
+	clients := make(planClients)
+	for _, o := range recreated {
+		client, err := clients.get(ctx, o.host, o.projectID)
+		if err != nil {
+			warnf("%s", err)
+			continue
+		}
+		subscription := client.Subscription(o.subscriptionID)
+		config, err := subscription.Config(ctx)
+		if err == nil {
+			_, err = subscription.Update(ctx, pubsub.SubscriptionConfigToUpdate{Labels: withManagedByLabel(config.Labels)})
+		}
+		if err != nil {
+			warnf("Unable to label recreated subscription %q in project %q as managed by pubsubc: %s", o.subscriptionID, o.projectID, err)
+		}
+	}
+}
+
+// createdTargets returns the targets of each host that created a topic or
+// subscription.
+func createdTargets(hosts []string, byHost map[string][]*applyTarget) map[string][]*applyTarget {
+	created := make(map[string][]*applyTarget)
+	for _, host := range hosts {
+		for _, target := range byHost[host] {
+			if target.topics.created.Load()+target.subscriptions.created.Load() > 0 {
+				created[host] = append(created[host], target)
+			}
+		}
+	}
+	return created
+}
+
+// resetChecks forgets the delivery checks and push smoke tests of the last
+// pass, so that each pass reports only its own.
+func resetChecks() {
+	deliveryMu.Lock()
+	deliveryResults = nil
+	deliveryMu.Unlock()
+	smokeMu.Lock()
+	smokeResults = nil
+	smokeMu.Unlock()
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"cloud.google.com/go/pubsub/apiv1/pubsubpb"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// recordedMessage is a single line of a recording.
+type recordedMessage struct {
+	MessageID   string            `json:"messageId"`
+	PublishTime string            `json:"publishTime"`
+	OrderingKey string            `json:"orderingKey,omitempty"`
+	Attributes  map[string]string `json:"attributes,omitempty"`
+	Data        []byte            `json:"data"`
+}
+
+// recordLeaseSeconds is how long unacked recorded messages are held before
+// they could be redelivered, the longest the service allows.
+const recordLeaseSeconds = 600
+
+// runRecord pulls messages from a subscription and appends them to a file as
+// JSON lines, until interrupted or a -count or -duration limit is reached.
+func runRecord(args []string) {
+	flags := flag.NewFlagSet("record", flag.ExitOnError)
+	projectID := flags.String("project", "", "Project ID of the subscription")
+	subscriptionID := flags.String("subscription", "", "Subscription to record messages from")
+	output := flags.String("o", "", "File to append recorded messages to, or - for stdout")
+	ack := flags.Bool("ack", false, "Ack recorded messages instead of leaving them in the backlog")
+	count := flags.Int("count", 0, "Stop after recording this many messages, 0 for no limit")
+	duration := flags.Duration("duration", 0, "Stop after recording for this long, 0 for no limit")
+	flags.Parse(args)
+
+	if *projectID == "" || *subscriptionID == "" || *output == "" {
+		flags.Usage()
+		fatalf("-project, -subscription and -o are required")
+	}
+
+	var out io.Writer = os.Stdout
+	if *output != "-" {
+		file, err := os.OpenFile(*output, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+		if err != nil {
+			fatalf("Unable to open %s: %s", *output, err)
+		}
+		defer file.Close()
+		out = file
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	if *duration > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, *duration)
+		defer cancel()
+	}
+
+	subscriber, err := newSubscriberClient(ctx, "")
+	if err != nil {
+		fatalf("Unable to create subscriber client: %s", err)
+	}
+	defer subscriber.Close()
+
+	subscriptionName := fmt.Sprintf("projects/%s/subscriptions/%s", *projectID, *subscriptionID)
+
+	// Messages left in the backlog stay leased until the recording ends, so
+	// they aren't recorded twice, and are then released for redelivery.
+	var leased []string
+	seen := make(map[string]bool)
+	defer func() {
+		if len(leased) > 0 {
+			subscriber.ModifyAckDeadline(context.Background(), &pubsubpb.ModifyAckDeadlineRequest{
+				Subscription:       subscriptionName,
+				AckIds:             leased,
+				AckDeadlineSeconds: 0,
+			})
+		}
+	}()
+
+	fmt.Fprintf(os.Stderr, "Recording subscription %q in project %q to %s\n", *subscriptionID, *projectID, *output)
+	encoder := json.NewEncoder(out)
+	recorded := 0
+	for ctx.Err() == nil && (*count <= 0 || recorded < *count) {
+		maxMessages := int32(100)
+		if *count > 0 && *count-recorded < int(maxMessages) {
+			maxMessages = int32(*count - recorded)
+		}
+		pullCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
+		resp, err := subscriber.Pull(pullCtx, &pubsubpb.PullRequest{
+			Subscription: subscriptionName,
+			MaxMessages:  maxMessages,
+		})
+		cancel()
+		if err != nil {
+			if ctx.Err() != nil {
+				break
+			}
+			if status.Code(err) == codes.DeadlineExceeded {
+				continue
+			}
+			fatalf("Unable to pull from subscription %q for project %q: %s", *subscriptionID, *projectID, err)
+		}
+		if len(resp.ReceivedMessages) == 0 {
+			select {
+			case <-ctx.Done():
+			case <-time.After(time.Second):
+			}
+			continue
+		}
+
+		var ackIDs []string
+		for _, received := range resp.ReceivedMessages {
+			ackIDs = append(ackIDs, received.AckId)
+			msg := received.Message
+			if seen[msg.MessageId] {
+				continue
+			}
+			seen[msg.MessageId] = true
+			if err := encoder.Encode(recordedMessage{
+				MessageID:   msg.MessageId,
+				PublishTime: msg.GetPublishTime().AsTime().UTC().Format(time.RFC3339Nano),
+				OrderingKey: msg.OrderingKey,
+				Attributes:  msg.Attributes,
+				Data:        msg.Data,
+			}); err != nil {
+				fatalf("Unable to write to %s: %s", *output, err)
+			}
+			recorded++
+		}
+
+		if *ack {
+			err = subscriber.Acknowledge(context.Background(), &pubsubpb.AcknowledgeRequest{Subscription: subscriptionName, AckIds: ackIDs})
+		} else {
+			leased = append(leased, ackIDs...)
+			err = subscriber.ModifyAckDeadline(context.Background(), &pubsubpb.ModifyAckDeadlineRequest{
+				Subscription:       subscriptionName,
+				AckIds:             ackIDs,
+				AckDeadlineSeconds: recordLeaseSeconds,
+			})
+		}
+		if err != nil {
+			warnf("Unable to update %d recorded messages: %s", len(ackIDs), err)
+		}
+	}
+
+	fmt.Fprintf(os.Stderr, "Recorded %d messages to %s\n", recorded, *output)
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"cloud.google.com/go/pubsub"
+)
+
+// pushMessage is the message part of a push delivery request body. Both
+// spellings of the ID and publish time fields are sent, as the service does.
+type pushMessage struct {
+	Attributes        map[string]string `json:"attributes,omitempty"`
+	Data              []byte            `json:"data"`
+	MessageID         string            `json:"messageId"`
+	LegacyMessageID   string            `json:"message_id"`
+	PublishTime       string            `json:"publishTime"`
+	LegacyPublishTime string            `json:"publish_time"`
+	OrderingKey       string            `json:"orderingKey,omitempty"`
+}
+
+// pushEnvelope is the body of a push delivery request.
+type pushEnvelope struct {
+	Message      pushMessage `json:"message"`
+	Subscription string      `json:"subscription"`
+}
+
+// relayStats counts what a relay has done with the messages it pulled.
+type relayStats struct {
+	forwarded atomic.Int64
+	acked     atomic.Int64
+	nacked    atomic.Int64
+}
+
+func (s *relayStats) String() string {
+	return fmt.Sprintf("forwarded=%d acked=%d nacked=%d", s.forwarded.Load(), s.acked.Load(), s.nacked.Load())
+}
+
+// runRelay pulls messages from a subscription and POSTs them to an HTTP
+// endpoint in the push delivery format, until interrupted.
+func runRelay(args []string) {
+	flags := flag.NewFlagSet("relay", flag.ExitOnError)
+	projectID := flags.String("project", "", "Project ID of the subscription")
+	subscriptionID := flags.String("subscription", "", "Pull subscription to relay messages from")
+	target := flags.String("target", "", "HTTP endpoint to POST messages to")
+	concurrency := flags.Int("concurrency", 8, "Maximum number of messages forwarded at once")
+	timeout := flags.Duration("timeout", 30*time.Second, "Timeout for each forwarded request")
+	topicID := flags.String("topic", "", "Topic for the subscription, used with -create")
+	createSubscription := flags.Bool("create", false, "Create the pull subscription if it doesn't exist")
+	statsInterval := flags.Duration("stats-interval", 30*time.Second, "How often to print relay statistics, 0 to only print on shutdown")
+	flags.Parse(args)
+
+	if *projectID == "" || *subscriptionID == "" || *target == "" {
+		flags.Usage()
+		fatalf("-project, -subscription and -target are required")
+	}
+	if *createSubscription && *topicID == "" {
+		fatalf("-create requires -topic")
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	client, err := newClient(ctx, *projectID, "")
+	if err != nil {
+		fatalf("Unable to create client to project %q: %s", *projectID, err)
+	}
+
+	subscription := client.Subscription(*subscriptionID)
+	if *createSubscription {
+		exists, err := subscription.Exists(ctx)
+		if err != nil {
+			fatalf("Failed to check existence of subscription %q for project %q: %s", *subscriptionID, *projectID, err)
+		}
+		if !exists {
+			debugf("Creating pull subscription %q on topic %q", *subscriptionID, *topicID)
+			subscription, err = client.CreateSubscription(ctx, *subscriptionID, pubsub.SubscriptionConfig{Topic: client.Topic(*topicID)})
+			if err != nil {
+				fatalf("Unable to create subscription %q on topic %q for project %q: %s", *subscriptionID, *topicID, *projectID, err)
+			}
+			audit(auditEvent{Action: "created", Kind: "subscription", Project: *projectID, Name: *subscriptionID, Source: "relay"})
+		}
+	}
+	subscription.ReceiveSettings.MaxOutstandingMessages = *concurrency
+
+	stats := &relayStats{}
+	if *statsInterval > 0 {
+		go func() {
+			ticker := time.NewTicker(*statsInterval)
+			defer ticker.Stop()
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case <-ticker.C:
+					fmt.Printf("Relay %s -> %s: %s\n", *subscriptionID, *target, stats)
+				}
+			}
+		}()
+	}
+
+	fmt.Printf("Relaying subscription %q in project %q to %s\n", *subscriptionID, *projectID, *target)
+	httpClient := &http.Client{Timeout: *timeout}
+	subscriptionName := fmt.Sprintf("projects/%s/subscriptions/%s", *projectID, *subscriptionID)
+	err = subscription.Receive(ctx, func(ctx context.Context, msg *pubsub.Message) {
+		stats.forwarded.Add(1)
+		if err := relayMessage(ctx, httpClient, *target, subscriptionName, msg); err != nil {
+			debugf("  Nacking message %s: %s", msg.ID, err)
+			stats.nacked.Add(1)
+			msg.Nack()
+			return
+		}
+		stats.acked.Add(1)
+		msg.Ack()
+	})
+	if err != nil {
+		fatalf("Unable to receive from subscription %q for project %q: %s", *subscriptionID, *projectID, err)
+	}
+
+	fmt.Printf("Relay %s -> %s stopped: %s\n", *subscriptionID, *target, stats)
+}
+
+// relayMessage POSTs a message to target, returning an error unless the
+// response has a 2xx status.
+func relayMessage(ctx context.Context, httpClient *http.Client, target, subscriptionName string, msg *pubsub.Message) error {
+	publishTime := msg.PublishTime.UTC().Format(time.RFC3339Nano)
+	body, err := json.Marshal(pushEnvelope{
+		Message: pushMessage{
+			Attributes:        msg.Attributes,
+			Data:              msg.Data,
+			MessageID:         msg.ID,
+			LegacyMessageID:   msg.ID,
+			PublishTime:       publishTime,
+			LegacyPublishTime: publishTime,
+			OrderingKey:       msg.OrderingKey,
+		},
+		Subscription: subscriptionName,
+	})
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, target, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("%s responded with HTTP %d", target, resp.StatusCode)
+	}
+	return nil
+}
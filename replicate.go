@@ -0,0 +1,50 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// replicaResult tallies the configs applied to a single replica host.
+type replicaResult struct {
+	applied  int
+	failed   int
+	timedOut int
+}
+
+var (
+	replicaHosts   []string
+	replicaResults = make(map[string]*replicaResult)
+)
+
+// configureReplicas parses the -replicate host list.
+func configureReplicas() {
+	if *replicate == "" {
+		return
+	}
+	for _, host := range strings.Split(*replicate, ",") {
+		host = strings.TrimSpace(host)
+		if host == "" {
+			continue
+		}
+		if _, ok := replicaResults[host]; ok {
+			continue
+		}
+		replicaHosts = append(replicaHosts, host)
+		replicaResults[host] = &replicaResult{}
+	}
+	debugf("Replicating configs to %s", strings.Join(replicaHosts, ", "))
+}
+
+// reportReplicas prints how many configs were applied to each replica host.
+func reportReplicas() {
+	if len(replicaHosts) == 0 {
+		return
+	}
+
+	fmt.Println("Replica hosts:")
+	for _, host := range replicaHosts {
+		result := replicaResults[host]
+		fmt.Printf("  %s: %d applied, %d failed, %d timed out\n", host, result.applied, result.failed, result.timedOut)
+	}
+}
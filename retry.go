@@ -0,0 +1,41 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/thinkfluent/pubsubc/pkg/pubsubc"
+)
+
+// retryingTopology retries the schema calls of a topology that fail with a
+// transient error, such as the emulator being unavailable or slow to answer,
+// up to -retries times with exponential backoff. The pubsubc package retries
+// the calls creating topics and subscriptions itself. Publishing isn't
+// retried, as a message that timed out may have been published anyway.
+type retryingTopology struct {
+	topologyClient
+	target *applyTarget
+}
+
+// retry retries call as pubsubc.Retry does. what names the resource the call
+// is about, for the debug output, and fields are its log fields.
+func (t retryingTopology) retry(ctx context.Context, what string, fields []any, call func() error) error {
+	return pubsubc.Retry(ctx, *retries, call, func(attempt int, backoff time.Duration, err error) {
+		t.target.log(fields...).debugf("  Request for %s failed (attempt %d), retrying in %s: %s", what, attempt, backoff, err)
+	})
+}
+
+func (t retryingTopology) topicSchema(ctx context.Context, topicID string) (schemaID, encoding string, err error) {
+	err = t.retry(ctx, fmt.Sprintf("topic %q", topicID), []any{"topic", topicID}, func() error {
+		schemaID, encoding, err = t.topologyClient.topicSchema(ctx, topicID)
+		return err
+	})
+	return schemaID, encoding, err
+}
+
+func (t retryingTopology) createSchema(ctx context.Context, schema schemaDefinition) error {
+	return t.retry(ctx, fmt.Sprintf("schema %q", schema.id), []any{"schema", schema.id}, func() error {
+		return t.topologyClient.createSchema(ctx, schema)
+	})
+}
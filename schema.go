@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"cloud.google.com/go/pubsub"
+)
+
+// schemaDefinition is a schema a config file declares for its topics, created
+// before them, with the definition read from the file at path.
+type schemaDefinition struct {
+	path       string
+	id         string
+	schemaType pubsub.SchemaType
+	definition string
+	sourceHint string
+}
+
+// newSchemaClient creates a schema API client. Like the low-level subscriber
+// client, it doesn't read PUBSUB_EMULATOR_HOST itself.
+func newSchemaClient(ctx context.Context, projectID string, host string) (*pubsub.SchemaClient, error) {
+	if host == "" {
+		host = os.Getenv("PUBSUB_EMULATOR_HOST")
+	}
+	if host == "" {
+		return pubsub.NewSchemaClient(ctx, projectID, productionOptions...)
+	}
+	return pubsub.NewSchemaClient(ctx, projectID, emulatorOptions(host)...)
+}
+
+// createSchemas creates the schemas of a config that don't already exist, in
+// the order they were declared. A schema that fails doesn't stop the rest;
+// every failure is returned together, and the topics using it fail in turn.
+func createSchemas(ctx context.Context, target *applyTarget) error {
+	projectID := target.config.projectID
+	var errs []error
+	for _, schema := range target.config.schemas {
+		log := target.log("schema", schema.id)
+		log.debugf("  Creating %s schema %q from %s", describeSchemaType(schema.schemaType), schema.id, schema.sourceHint)
+		err := target.topology.createSchema(ctx, schema)
+		if isAlreadyExists(err) {
+			// Schemas can't be changed in place, so an existing one is left
+			// as it is.
+			log.debugf("  Schema %q already exists", schema.id)
+			continue
+		}
+		if err != nil {
+			errs = append(errs, withFields(fmt.Errorf("Unable to create schema %q for project %q from %s: %s", schema.id, projectID, schema.sourceHint, err), "schema", schema.id))
+			continue
+		}
+		target.audit("created", "schema", schema.id)
+	}
+	return errors.Join(errs...)
+}
+
+// checkTopicSchema warns if an existing topic doesn't validate messages
+// against the schema, or with the encoding, that the config declares for it.
+// The topic is left as it is, so drift never fails the config.
+func checkTopicSchema(ctx context.Context, target *applyTarget, entry topicEntry) {
+	if entry.Schema == "" {
+		return
+	}
+	projectID := target.config.projectID
+	log := target.log("topic", entry.ID)
+	schemaID, encoding, err := target.topology.topicSchema(ctx, entry.ID)
+	if err != nil {
+		log.warnf("%s: Failed to check the schema of topic %q for project %q: %s", target.config.sourceHint, entry.ID, projectID, err)
+		return
+	}
+	want := entry.Encoding()
+	if schemaID != entry.Schema || encoding != want {
+		have := "no schema"
+		if schemaID != "" {
+			have = fmt.Sprintf("schema %q with %s encoding", schemaID, encoding)
+		}
+		log.warnf("%s: Topic %q in project %q already exists with %s instead of schema %q with %s encoding, leaving it as is",
+			target.config.sourceHint, entry.ID, projectID, have, entry.Schema, want)
+	}
+}
+
+// parseSchemaType parses the type of a schema definition, avro or protobuf.
+func parseSchemaType(value string) (pubsub.SchemaType, error) {
+	switch strings.ToLower(value) {
+	case "avro":
+		return pubsub.SchemaAvro, nil
+	case "protobuf", "protocol_buffer":
+		return pubsub.SchemaProtocolBuffer, nil
+	default:
+		return 0, fmt.Errorf("unknown schema type %q, expected avro or protobuf", value)
+	}
+}
+
+func describeSchemaType(schemaType pubsub.SchemaType) string {
+	if schemaType == pubsub.SchemaProtocolBuffer {
+		return "protobuf"
+	}
+	return "Avro"
+}
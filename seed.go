@@ -0,0 +1,157 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+// seedMessage is a message published to a topic once every subscription has
+// been created, so that subscribers have something to process straight away.
+type seedMessage struct {
+	topicID    string
+	data       []byte
+	attributes map[string]string
+	sourceHint string
+}
+
+// parseSeed parses a PUBSUB_SEED variable: the project, the topic and the
+// base64 encoded payload, then any comma separated key=value attributes.
+func parseSeed(value string) (string, seedMessage, error) {
+	parts := strings.Split(value, ",")
+	if len(parts) < 3 {
+		return "", seedMessage{}, fmt.Errorf("Expected project,topic,<base64 payload>")
+	}
+	if parts[1] == "" {
+		return "", seedMessage{}, fmt.Errorf("Empty topic name")
+	}
+	data, err := base64.StdEncoding.DecodeString(parts[2])
+	if err != nil {
+		return "", seedMessage{}, fmt.Errorf("Payload isn't valid base64: %s", err)
+	}
+	seed := seedMessage{topicID: parts[1], data: data}
+	for _, attribute := range parts[3:] {
+		key, val, ok := strings.Cut(attribute, "=")
+		if !ok || key == "" {
+			return "", seedMessage{}, fmt.Errorf("Expected attribute key=value, not %q", attribute)
+		}
+		if seed.attributes == nil {
+			seed.attributes = make(map[string]string)
+		}
+		seed.attributes[key] = val
+	}
+	if len(seed.data) == 0 && len(seed.attributes) == 0 {
+		return "", seedMessage{}, fmt.Errorf("A seed message needs a payload or attributes")
+	}
+	return parts[0], seed, nil
+}
+
+// skippedSeeds counts the PUBSUB_SEED variables that can't be published.
+var skippedSeeds = 0
+
+// processEnvSeeds gives the messages of the numbered PUBSUB_SEED variables to
+// a discovered config for their project, which publishes them: the first to
+// declare the topic, or else the first for the project.
+func processEnvSeeds() {
+	for i := 1; ; i++ {
+		currentEnv := fmt.Sprintf("PUBSUB_SEED%d", i)
+		env := os.Getenv(currentEnv)
+		if env == "" {
+			break
+		}
+		projectID, seed, err := parseSeed(env)
+		if err != nil {
+			warnf("%s: %s, not seeding", currentEnv, err)
+			skippedSeeds++
+			continue
+		}
+		seed.sourceHint = currentEnv
+		if projectID == "" || projectID == "$DEFAULT" {
+			if projectID, _, err = resolveDefaultProject(); err != nil {
+				warnf("%s: %s, not seeding", currentEnv, err)
+				skippedSeeds++
+				continue
+			}
+		}
+
+		var config *projectConfig
+		for _, pending := range pendingConfigs {
+			if pending.projectID != projectID {
+				continue
+			}
+			if config == nil {
+				config = pending
+			}
+			if declaresTopic(pending, seed.topicID) {
+				config = pending
+				break
+			}
+		}
+		if config == nil {
+			warnf("%s: No config declares project %q, not seeding topic %q", currentEnv, projectID, seed.topicID)
+			skippedSeeds++
+			continue
+		}
+		debugf("Seeding topic %q of project %q from %s", seed.topicID, projectID, currentEnv)
+		config.seeds = append(config.seeds, seed)
+	}
+}
+
+// declaresTopic reports whether a config declares a topic.
+func declaresTopic(config *projectConfig, topicID string) bool {
+	for _, entry := range config.topics {
+		if entry.ID == topicID {
+			return true
+		}
+	}
+	return false
+}
+
+// seedTopics publishes the seed messages of a config in the order they were
+// declared, waiting for each to be accepted. A message that fails doesn't
+// stop the rest; every failure is returned together. Reconcile passes only
+// seed the topics they had to create.
+func seedTopics(ctx context.Context, target *applyTarget) error {
+	projectID := target.config.projectID
+	var errs []error
+	for _, seed := range target.config.seeds {
+		fields := []any{"topic", seed.topicID, "seed", seed.sourceHint}
+		if reconciling && !target.applier.TopicCreated(seed.topicID) {
+			// The topic, and so its seed messages, survived since the last
+			// pass.
+			continue
+		}
+		if target.applier.TopicFailed(seed.topicID) {
+			target.seeds.failed.Add(1)
+			errs = append(errs, withFields(fmt.Errorf("%s: Not seeding topic %q for project %q, which couldn't be created", seed.sourceHint, seed.topicID, projectID), fields...))
+			continue
+		}
+		target.log(fields...).debugf("  Publishing seed message from %s to topic %q", seed.sourceHint, seed.topicID)
+		if err := target.topology.publish(ctx, seed.topicID, seed); err != nil {
+			target.seeds.failed.Add(1)
+			errs = append(errs, withFields(fmt.Errorf("%s: Unable to publish seed message to topic %q for project %q: %s", seed.sourceHint, seed.topicID, projectID, err), fields...))
+			continue
+		}
+		target.seeds.created.Add(1)
+		target.audit("seeded", "topic", seed.topicID)
+	}
+	return errors.Join(errs...)
+}
+
+// describeSeed summarizes a seed message for printing after its topic.
+func describeSeed(seed seedMessage) string {
+	description := fmt.Sprintf("%d bytes", len(seed.data))
+	if len(seed.attributes) > 0 {
+		keys := make([]string, 0, len(seed.attributes))
+		for key := range seed.attributes {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		description += ", attributes " + strings.Join(keys, ", ")
+	}
+	return description
+}
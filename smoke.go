@@ -0,0 +1,213 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"cloud.google.com/go/pubsub"
+)
+
+// smokeSubscriptionPrefix starts the name of every throwaway push
+// subscription created by -smoke-test-push.
+const smokeSubscriptionPrefix = "pubsubc-smoke-"
+
+// pushListener receives the push requests of smoke test subscriptions,
+// handing each to whoever is waiting on its path.
+type pushListener struct {
+	port    string
+	mu      sync.Mutex
+	waiting map[string]chan pushEnvelope
+}
+
+var (
+	smokeMu      sync.Mutex
+	smokeResults []deliveryResult
+)
+
+// smokeTestPushes checks push delivery to a temporary listener for every topic
+// of the applied targets. Hosts are checked in parallel. Results are
+// collected by reportSmokeTests.
+func smokeTestPushes(hosts []string, byHost map[string][]*applyTarget) {
+	if !*smokeTestPush {
+		return
+	}
+
+	listener, err := net.Listen("tcp", *smokeListen)
+	if err != nil {
+		fatalf("Unable to listen for smoke test pushes on %s: %s", *smokeListen, err)
+	}
+	_, port, _ := net.SplitHostPort(listener.Addr().String())
+	pushes := &pushListener{port: port, waiting: make(map[string]chan pushEnvelope)}
+	server := &http.Server{Handler: pushes}
+	go server.Serve(listener)
+	defer server.Close()
+	debugf("Listening for smoke test pushes on %s", listener.Addr())
+
+	var wg sync.WaitGroup
+	for _, host := range hosts {
+		targets := byHost[host]
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for _, target := range targets {
+				if target.failed {
+					continue
+				}
+				smokeTestTarget(pushes, target)
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func (l *pushListener) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var envelope pushEnvelope
+	if r.Method != http.MethodPost || json.NewDecoder(r.Body).Decode(&envelope) != nil {
+		http.Error(w, "expected a Pub/Sub push request", http.StatusBadRequest)
+		return
+	}
+	l.mu.Lock()
+	received, ok := l.waiting[r.URL.Path]
+	l.mu.Unlock()
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	select {
+	case received <- envelope:
+	default:
+		// Only the first push is checked; redeliveries are just acked.
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// await registers path and returns the channel its pushes arrive on, along
+// with a function to unregister it.
+func (l *pushListener) await(path string) (<-chan pushEnvelope, func()) {
+	received := make(chan pushEnvelope, 1)
+	l.mu.Lock()
+	l.waiting[path] = received
+	l.mu.Unlock()
+	return received, func() {
+		l.mu.Lock()
+		delete(l.waiting, path)
+		l.mu.Unlock()
+	}
+}
+
+// callbackURL returns the base URL the emulator at host can reach the
+// listener on: -smoke-callback-url if set, otherwise the address of this
+// machine on the route to the emulator, which is what other containers on a
+// shared compose network see.
+func (l *pushListener) callbackURL(host string) (string, error) {
+	if *smokeCallbackURL != "" {
+		return strings.TrimSuffix(*smokeCallbackURL, "/"), nil
+	}
+	if host == "" {
+		host = os.Getenv("PUBSUB_EMULATOR_HOST")
+	}
+	if host == "" {
+		return "", fmt.Errorf("-smoke-callback-url is required without an emulator")
+	}
+	conn, err := net.Dial("udp", host)
+	if err != nil {
+		return "", fmt.Errorf("Unable to find the route to %s, set -smoke-callback-url: %s", host, err)
+	}
+	defer conn.Close()
+	ip := conn.LocalAddr().(*net.UDPAddr).IP
+	return "http://" + net.JoinHostPort(ip.String(), l.port), nil
+}
+
+// smokeTestTarget checks push delivery on every topic of a single applied
+// config, through a throwaway push subscription per topic.
+func smokeTestTarget(pushes *pushListener, target *applyTarget) {
+	ctx, cancel := context.WithTimeout(context.Background(), *verifyTimeout)
+	defer cancel()
+
+	record := func(topicID string, latency time.Duration, err error) {
+		smokeMu.Lock()
+		smokeResults = append(smokeResults, deliveryResult{target.host, target.config.projectID, topicID, latency, err})
+		smokeMu.Unlock()
+	}
+
+	base, err := pushes.callbackURL(target.host)
+	if err != nil {
+		record("*", 0, err)
+		return
+	}
+
+	for _, topicID := range target.config.topics.IDs() {
+		latency, err := smokeTestTopic(ctx, pushes, base, target.client, topicID)
+		record(topicID, latency, err)
+	}
+}
+
+// smokeTestTopic publishes a canary to a topic and waits for it to be pushed
+// to the listener, returning how long that took.
+func smokeTestTopic(ctx context.Context, pushes *pushListener, base string, client *pubsub.Client, topicID string) (time.Duration, error) {
+	subscriptionID := smokeSubscriptionPrefix + newCanaryID()
+	received, done := pushes.await("/" + subscriptionID)
+	defer done()
+
+	endpoint := base + "/" + subscriptionID
+	debugf("  Creating smoke test subscription %q on topic %q with target %q", subscriptionID, topicID, endpoint)
+	topic := client.Topic(topicID)
+	subscription, err := client.CreateSubscription(ctx, subscriptionID, pubsub.SubscriptionConfig{
+		Topic:      topic,
+		PushConfig: pubsub.PushConfig{Endpoint: endpoint},
+	})
+	if err != nil {
+		return 0, fmt.Errorf("Unable to create smoke test subscription: %s", err)
+	}
+	defer func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		if err := subscription.Delete(ctx); err != nil {
+			warnf("Unable to delete smoke test subscription %q: %s", subscriptionID, err)
+		}
+	}()
+
+	canaryID := newCanaryID()
+	data := "pubsubc push smoke test"
+	published := time.Now()
+	_, err = topic.Publish(ctx, &pubsub.Message{
+		Data:       []byte(data),
+		Attributes: map[string]string{canaryAttribute: "true", canaryIDAttribute: canaryID},
+	}).Get(ctx)
+	topic.Stop()
+	if err != nil {
+		return 0, fmt.Errorf("Unable to publish canary: %s", err)
+	}
+
+	select {
+	case <-ctx.Done():
+		return 0, fmt.Errorf("canary not pushed to %s within %s", base, *verifyTimeout)
+	case envelope := <-received:
+		latency := time.Since(published)
+		switch {
+		case envelope.Subscription != subscription.String():
+			return 0, fmt.Errorf("push names subscription %q instead of %q", envelope.Subscription, subscription.String())
+		case envelope.Message.Attributes[canaryIDAttribute] != canaryID:
+			return 0, fmt.Errorf("pushed message isn't the canary")
+		case string(envelope.Message.Data) != data || envelope.Message.MessageID == "":
+			return 0, fmt.Errorf("pushed message is malformed")
+		}
+		return latency, nil
+	}
+}
+
+// reportSmokeTests prints the outcome of every push smoke test, reporting
+// whether all canaries were pushed.
+func reportSmokeTests() bool {
+	if !*smokeTestPush || len(smokeResults) == 0 {
+		return true
+	}
+	return printDeliveryResults("Push smoke tests:", smokeResults)
+}
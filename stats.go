@@ -0,0 +1,256 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"text/tabwriter"
+	"text/template"
+	"time"
+
+	"cloud.google.com/go/pubsub"
+	vkit "cloud.google.com/go/pubsub/apiv1"
+	"google.golang.org/api/iterator"
+)
+
+// projectStats describes every topic in a project and its subscriptions.
+type projectStats struct {
+	Project   string       `json:"project"`
+	SampledAt time.Time    `json:"sampledAt"`
+	Topics    []topicStats `json:"topics"`
+	Orphans   []string     `json:"orphanedSubscriptions"`
+}
+
+type topicStats struct {
+	Topic         string              `json:"topic"`
+	Subscriptions []subscriptionStats `json:"subscriptions"`
+}
+
+// subscriptionStats holds a subscription's settings and sampled backlog.
+// Backlog figures are approximate and absent for push subscriptions, which
+// aren't sampled.
+type subscriptionStats struct {
+	Subscription     string   `json:"subscription"`
+	PushEndpoint     string   `json:"pushEndpoint,omitempty"`
+	Backlog          *int     `json:"backlog,omitempty"`
+	OldestAgeSeconds *float64 `json:"oldestUnackedAgeSeconds,omitempty"`
+	Approximate      bool     `json:"approximate"`
+}
+
+// deletedTopic is the topic name the service reports for subscriptions whose
+// topic has been deleted.
+const deletedTopic = "_deleted-topic_"
+
+// subscriptionTopic returns the ID of a subscription's topic, or deletedTopic
+// if the subscription is orphaned.
+func subscriptionTopic(topic *pubsub.Topic) string {
+	if topic == nil || topic.String() == deletedTopic {
+		return deletedTopic
+	}
+	return topic.ID()
+}
+
+// runStats prints the subscriptions of every topic in a project with their
+// approximate backlog, optionally refreshing until interrupted.
+func runStats(args []string) {
+	flags := flag.NewFlagSet("stats", flag.ExitOnError)
+	projectID := flags.String("project", "", "Project ID to describe")
+	refresh := flags.Duration("refresh", 0, "Refresh every interval until interrupted, like watch")
+	jsonOutput := flags.Bool("json", false, "Print JSON instead of a table")
+	templateText := flags.String("template", "", "Print with this Go template instead of a table, evaluated against the fields of the JSON document")
+	flags.Parse(args)
+
+	if *projectID == "" {
+		flags.Usage()
+		fatalf("-project is required")
+	}
+	var tmpl *template.Template
+	if *templateText != "" {
+		var err error
+		if tmpl, err = parseOutputTemplate(*templateText); err != nil {
+			fatalf("%s", err)
+		}
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	client, err := newClient(ctx, *projectID, "")
+	if err != nil {
+		fatalf("Unable to create client to project %q: %s", *projectID, err)
+	}
+	subscriber, err := newSubscriberClient(ctx, "")
+	if err != nil {
+		fatalf("Unable to create subscriber client: %s", err)
+	}
+	defer subscriber.Close()
+
+	for {
+		stats, err := collectStats(ctx, client, subscriber, *projectID)
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			fatalf("%s", err)
+		}
+
+		switch {
+		case tmpl != nil:
+			if err := printDocument(os.Stdout, stats, tmpl); err != nil {
+				fatalf("Unable to print the stats: %s", err)
+			}
+		case *jsonOutput:
+			json.NewEncoder(os.Stdout).Encode(stats)
+		default:
+			if *refresh > 0 {
+				// Clear the screen between refreshes.
+				fmt.Print("\033[H\033[2J")
+			}
+			printStats(stats)
+		}
+
+		if *refresh <= 0 {
+			return
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(*refresh):
+		}
+	}
+}
+
+// collectStats lists a project's topics and subscriptions and samples the
+// backlog of each pull subscription.
+func collectStats(ctx context.Context, client *pubsub.Client, subscriber *vkit.SubscriberClient, projectID string) (projectStats, error) {
+	stats := projectStats{Project: projectID, SampledAt: time.Now().UTC(), Topics: []topicStats{}, Orphans: []string{}}
+
+	topics := client.Topics(ctx)
+	for {
+		topic, err := topics.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return stats, fmt.Errorf("Unable to list topics for project %q: %s", projectID, err)
+		}
+
+		entry := topicStats{Topic: topic.ID(), Subscriptions: []subscriptionStats{}}
+		subscriptions := topic.Subscriptions(ctx)
+		for {
+			subscription, err := subscriptions.Next()
+			if err == iterator.Done {
+				break
+			}
+			if err != nil {
+				return stats, fmt.Errorf("Unable to list subscriptions of topic %q for project %q: %s", topic.ID(), projectID, err)
+			}
+
+			config, err := subscription.Config(ctx)
+			if err != nil {
+				return stats, fmt.Errorf("Unable to fetch subscription %q for project %q: %s", subscription.ID(), projectID, err)
+			}
+
+			subscriptionEntry := subscriptionStats{Subscription: subscription.ID(), PushEndpoint: config.PushConfig.Endpoint}
+			if subscriptionEntry.PushEndpoint == "" {
+				sample, err := sampleBacklog(ctx, subscriber, subscription.String())
+				if err != nil {
+					return stats, fmt.Errorf("Unable to sample subscription %q for project %q: %s", subscription.ID(), projectID, err)
+				}
+				subscriptionEntry.Backlog = &sample.messages
+				subscriptionEntry.Approximate = true
+				if !sample.oldest.IsZero() {
+					age := stats.SampledAt.Sub(sample.oldest).Seconds()
+					subscriptionEntry.OldestAgeSeconds = &age
+				}
+			}
+			entry.Subscriptions = append(entry.Subscriptions, subscriptionEntry)
+		}
+		stats.Topics = append(stats.Topics, entry)
+	}
+
+	// Subscriptions whose topic was deleted aren't listed under any topic.
+	orphans, err := listOrphans(ctx, client)
+	if err != nil {
+		return stats, fmt.Errorf("Unable to list subscriptions for project %q: %s", projectID, err)
+	}
+	for _, orphan := range orphans {
+		stats.Orphans = append(stats.Orphans, orphan.ID())
+	}
+	return stats, nil
+}
+
+// listOrphans lists the subscriptions of a project whose topic was deleted.
+func listOrphans(ctx context.Context, client *pubsub.Client) ([]*pubsub.SubscriptionConfig, error) {
+	var orphans []*pubsub.SubscriptionConfig
+	subscriptions := client.Subscriptions(ctx)
+	for {
+		config, err := subscriptions.NextConfig()
+		if err == iterator.Done {
+			return orphans, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if subscriptionTopic(config.Topic) == deletedTopic {
+			orphans = append(orphans, config)
+		}
+	}
+}
+
+// declaredTopics returns the topic the configs of targets declare each
+// subscription of a project on, which is the last known topic of an orphan.
+func declaredTopics(targets []*applyTarget, projectID string) map[string]string {
+	topics := make(map[string]string)
+	for _, target := range targets {
+		if target.config.projectID != projectID {
+			continue
+		}
+		for _, entry := range target.config.topics {
+			for _, subscription := range target.config.withAutoSub(entry) {
+				topics[subscription.ID] = entry.ID
+			}
+		}
+	}
+	return topics
+}
+
+// printStats prints project stats as a table.
+func printStats(stats projectStats) {
+	fmt.Printf("Project %q at %s\n\n", stats.Project, stats.SampledAt.Format(time.RFC3339))
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "TOPIC\tSUBSCRIPTION\tBACKLOG\tOLDEST\tPUSH ENDPOINT")
+	for _, topic := range stats.Topics {
+		if len(topic.Subscriptions) == 0 {
+			fmt.Fprintf(w, "%s\t-\t-\t-\t-\n", topic.Topic)
+		}
+		for _, subscription := range topic.Subscriptions {
+			backlog, oldest, endpoint := "-", "-", "-"
+			if subscription.Backlog != nil {
+				backlog = fmt.Sprintf("~%d", *subscription.Backlog)
+			}
+			if subscription.OldestAgeSeconds != nil {
+				oldest = (time.Duration(*subscription.OldestAgeSeconds) * time.Second).String()
+			}
+			if subscription.PushEndpoint != "" {
+				endpoint = subscription.PushEndpoint
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", topic.Topic, subscription.Subscription, backlog, oldest, endpoint)
+		}
+	}
+	w.Flush()
+
+	if len(stats.Orphans) > 0 {
+		fmt.Println()
+		fmt.Printf("Orphaned subscriptions (their topic was deleted, so they receive nothing): %s\n", strings.Join(stats.Orphans, ", "))
+	}
+
+	fmt.Println()
+	fmt.Println("~ approximate: sampled by pulling without acking; push subscriptions are not sampled")
+}
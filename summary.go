@@ -0,0 +1,249 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"text/template"
+)
+
+var (
+	output         = flag.String("output", "text", "Format of the summary printed after applying or verifying: text for a table, json for a document on stdout, or template for -template")
+	outputTemplate = flag.String("template", "", "Go template to print the summary with, for -output=template, evaluated against the fields of the JSON document")
+)
+
+// summaryOutput is where the summary is printed. With -output=json or
+// -output=template it is the only thing printed to stdout, so it can be piped
+// straight into a file or jq; everything else goes to stderr.
+var summaryOutput io.Writer = os.Stdout
+
+// summaryTemplate is the compiled -template.
+var summaryTemplate *template.Template
+
+// configureOutput checks the -output flag, compiling any -template so that a
+// mistake in it stops pubsubc before it does anything. It must be called
+// before anything keeps hold of os.Stdout.
+func configureOutput() {
+	if *outputTemplate != "" && *output != "template" {
+		fatalf("-template needs -output=template")
+	}
+	switch *output {
+	case "text":
+	case "json":
+		os.Stdout = os.Stderr
+	case "template":
+		var err error
+		if summaryTemplate, err = parseOutputTemplate(*outputTemplate); err != nil {
+			fatalf("%s", err)
+		}
+		os.Stdout = os.Stderr
+	default:
+		fatalf("Unknown -output %q, expected text, json or template", *output)
+	}
+}
+
+// parseOutputTemplate compiles a template for printing a JSON document with.
+func parseOutputTemplate(text string) (*template.Template, error) {
+	if text == "" {
+		return nil, fmt.Errorf("-output=template needs a -template")
+	}
+	parsed, err := template.New("template").Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid -template: %s", strings.Replace(err.Error(), "template: template:", "line ", 1))
+	}
+	return parsed, nil
+}
+
+// printDocument prints v to w as an indented JSON document or, if tmpl is
+// set, with tmpl. The template is given the JSON document decoded, so its
+// fields are those of the document, such as .projects.
+func printDocument(w io.Writer, v any, tmpl *template.Template) error {
+	if tmpl == nil {
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(v)
+	}
+	encoded, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	var document any
+	decoder := json.NewDecoder(bytes.NewReader(encoded))
+	decoder.UseNumber()
+	if err := decoder.Decode(&document); err != nil {
+		return err
+	}
+	return tmpl.Execute(w, document)
+}
+
+// runSummary is what a run did, for printing once the configs are applied.
+type runSummary struct {
+	Configs        int              `json:"configs"`
+	SkippedConfigs int              `json:"skippedConfigs"`
+	FailedConfigs  int              `json:"failedConfigs"`
+	Projects       []projectSummary `json:"projects"`
+}
+
+// projectSummary is what was done with the resources of one config on one
+// emulator host.
+type projectSummary struct {
+	Project       string              `json:"project"`
+	Source        string              `json:"source"`
+	Host          string              `json:"host,omitempty"`
+	Topics        topicSummary        `json:"topics"`
+	Subscriptions subscriptionSummary `json:"subscriptions"`
+	SeedMessages  seedSummary         `json:"seedMessages"`
+	Failed        bool                `json:"failed"`
+	TimedOut      bool                `json:"timedOut"`
+	autoSub       bool
+}
+
+type topicSummary struct {
+	Created  int64 `json:"created"`
+	Existing int64 `json:"existing"`
+	Failed   int64 `json:"failed"`
+}
+
+// subscriptionSummary counts subscriptions. Those created are split into
+// push, pull and BigQuery subscriptions, and Auto counts the pull
+// subscriptions -auto-sub or autoSub created.
+type subscriptionSummary struct {
+	Created  int64 `json:"created"`
+	Push     int64 `json:"push"`
+	Pull     int64 `json:"pull"`
+	Auto     int64 `json:"auto"`
+	BigQuery int64 `json:"bigQuery"`
+	Existing int64 `json:"existing"`
+	Updated  int64 `json:"updated"`
+	Failed   int64 `json:"failed"`
+}
+
+type seedSummary struct {
+	Published int64 `json:"published"`
+	Failed    int64 `json:"failed"`
+}
+
+// projectSummaries are the summaries of every target applied before the
+// configs were first reported. Re-applying them with -reconcile-interval
+// doesn't add to them.
+var projectSummaries []projectSummary
+
+// summarizeTargets adds what was done with the resources of every target to
+// the run's totals, and records a summary of each.
+func summarizeTargets(hosts []string, byHost map[string][]*applyTarget) {
+	for _, host := range hosts {
+		for _, target := range byHost[host] {
+			addCounts(&topicCounts, &target.topics)
+			addCounts(&subscriptionCounts, &target.subscriptions)
+			addCounts(&seedCounts, &target.seeds)
+			if reconciling {
+				continue
+			}
+
+			created, push, bigQuery := target.subscriptions.created.Load(), target.pushSubscriptions.Load(), target.bigQuerySubscriptions.Load()
+			projectSummaries = append(projectSummaries, projectSummary{
+				Project: target.config.projectID,
+				Source:  target.config.sourceHint,
+				Host:    host,
+				Topics: topicSummary{
+					Created:  target.topics.created.Load(),
+					Existing: target.topics.skipped.Load(),
+					Failed:   target.topics.failed.Load(),
+				},
+				Subscriptions: subscriptionSummary{
+					Created:  created,
+					Push:     push,
+					Pull:     created - push - bigQuery,
+					Auto:     target.autoSubscriptions.Load(),
+					BigQuery: bigQuery,
+					Existing: target.subscriptions.skipped.Load(),
+					Updated:  target.subscriptions.updated.Load(),
+					Failed:   target.subscriptions.failed.Load(),
+				},
+				SeedMessages: seedSummary{
+					Published: target.seeds.created.Load(),
+					Failed:    target.seeds.failed.Load(),
+				},
+				Failed:   target.failed,
+				TimedOut: target.timedOut,
+				autoSub:  target.config.autoSub,
+			})
+		}
+	}
+}
+
+// addCounts adds the counts of a target to the run's totals.
+func addCounts(totals, counts *resourceCounts) {
+	totals.created.Add(counts.created.Load())
+	totals.skipped.Add(counts.skipped.Load())
+	totals.updated.Add(counts.updated.Load())
+	totals.failed.Add(counts.failed.Load())
+}
+
+// reportSummary prints what was done with the resources of every config, as
+// a table or, with -output=json or -output=template, a JSON document.
+func reportSummary() {
+	summary := runSummary{
+		Configs:        configCount,
+		SkippedConfigs: skippedConfigs,
+		FailedConfigs:  skippedConfigs + unappliedConfigs,
+		Projects:       projectSummaries,
+	}
+	if summary.Projects == nil {
+		summary.Projects = []projectSummary{}
+	}
+
+	if *output != "text" {
+		if err := printDocument(summaryOutput, summary, summaryTemplate); err != nil {
+			warnf("Unable to print the summary: %s", err)
+		}
+		return
+	}
+	if len(summary.Projects) == 0 {
+		return
+	}
+
+	withHosts, withAuto := false, false
+	for _, project := range summary.Projects {
+		withHosts = withHosts || project.Host != ""
+		withAuto = withAuto || project.autoSub
+	}
+	w := tabwriter.NewWriter(summaryOutput, 0, 0, 2, ' ', 0)
+	if withHosts {
+		fmt.Fprint(w, "HOST\t")
+	}
+	fmt.Fprint(w, "PROJECT\tSOURCE\tTOPICS CREATED\tEXISTING\tSUBSCRIPTIONS CREATED\tPUSH\tPULL\t")
+	if withAuto {
+		fmt.Fprint(w, "AUTO\t")
+	}
+	fmt.Fprintln(w, "EXISTING\tUPDATED\tFAILED\tRESULT")
+	for _, project := range summary.Projects {
+		if withHosts {
+			fmt.Fprintf(w, "%s\t", project.Host)
+		}
+		result := "applied"
+		switch {
+		case project.TimedOut:
+			result = "timed out"
+		case project.Failed:
+			result = "failed"
+		}
+		failed := project.Topics.Failed + project.Subscriptions.Failed + project.SeedMessages.Failed
+		fmt.Fprintf(w, "%s\t%s\t%d\t%d\t%d\t%d\t%d\t",
+			project.Project, project.Source,
+			project.Topics.Created, project.Topics.Existing,
+			project.Subscriptions.Created, project.Subscriptions.Push, project.Subscriptions.Pull)
+		if withAuto {
+			fmt.Fprintf(w, "%d\t", project.Subscriptions.Auto)
+		}
+		fmt.Fprintf(w, "%d\t%d\t%d\t%s\n",
+			project.Subscriptions.Existing, project.Subscriptions.Updated,
+			failed, result)
+	}
+	w.Flush()
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestPrintDocumentTemplate(t *testing.T) {
+	tmpl, err := parseOutputTemplate(`{{range .projects}}{{.project}} {{.subscriptions.created}} {{.failed}}{{"\n"}}{{end}}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	summary := runSummary{Projects: []projectSummary{
+		{Project: "a", Subscriptions: subscriptionSummary{Created: 12}},
+		{Project: "b", Failed: true},
+	}}
+	var out strings.Builder
+	if err := printDocument(&out, summary, tmpl); err != nil {
+		t.Fatal(err)
+	}
+	if want := "a 12 false\nb 0 true\n"; out.String() != want {
+		t.Errorf("printDocument() printed %q, want %q", out.String(), want)
+	}
+}
+
+func TestParseOutputTemplateErrors(t *testing.T) {
+	for text, want := range map[string]string{
+		"":                   "-output=template needs a -template",
+		"{{range .projects}": "Invalid -template: line 1:",
+	} {
+		if _, err := parseOutputTemplate(text); err == nil || !strings.HasPrefix(err.Error(), want) {
+			t.Errorf("parseOutputTemplate(%q) = %v, want %s", text, err, want)
+		}
+	}
+}
@@ -0,0 +1,219 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/pubsub"
+)
+
+// isProductionHost reports whether host is a Google API endpoint rather than
+// an emulator.
+func isProductionHost(host string) bool {
+	hostname := host
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		hostname = h
+	}
+	return hostname == "googleapis.com" || strings.HasSuffix(hostname, ".googleapis.com")
+}
+
+// syncCounts tallies the actions taken by a sync.
+type syncCounts struct {
+	created, updated, deleted, failed int
+}
+
+// runSync copies the topics and subscriptions of a project from one emulator
+// to another, optionally deleting any the source doesn't have.
+func runSync(args []string) {
+	flags := flag.NewFlagSet("sync", flag.ExitOnError)
+	from := flags.String("from", "", "Emulator host to copy the topology from")
+	to := flags.String("to", "", "Emulator host to copy the topology to")
+	projectID := flags.String("project", "", "Project ID to sync")
+	prune := flags.Bool("prune", false, "Delete topics and subscriptions that don't exist on the source")
+	yes := flags.Bool("yes", false, "Don't ask for confirmation before -prune deletes anything")
+	mappingFile := flags.String("endpoint-map", "", "File of from=to push endpoint prefix rewrites")
+	allowProduction := flags.Bool("allow-production", false, "Allow -from to be the real Pub/Sub service, which is only read from")
+	timeout := flags.Duration("timeout", time.Minute, "How long the whole sync may take")
+	flags.Parse(args)
+
+	if *from == "" || *to == "" || *projectID == "" {
+		flags.Usage()
+		fatalf("-from, -to and -project are required")
+	}
+	if isProductionHost(*to) {
+		fatalf("Refusing to sync to %s: the destination must be an emulator", *to)
+	}
+	if isProductionHost(*from) && !*allowProduction {
+		fatalf("Refusing to sync from %s without -allow-production", *from)
+	}
+
+	mapping, err := loadEndpointMapping(*mappingFile)
+	if err != nil {
+		fatalf("%s", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	defer cancel()
+
+	var source *pubsub.Client
+	if isProductionHost(*from) {
+		// pubsub.NewClient always prefers PUBSUB_EMULATOR_HOST, and both sides
+		// of a sync are named explicitly anyway.
+		os.Unsetenv("PUBSUB_EMULATOR_HOST")
+		source, err = newClient(ctx, *projectID, "")
+	} else {
+		source, err = newClient(ctx, *projectID, *from)
+	}
+	if err != nil {
+		fatalf("Unable to create client to project %q on %s: %s", *projectID, *from, err)
+	}
+	destination, err := newClient(ctx, *projectID, *to)
+	if err != nil {
+		fatalf("Unable to create client to project %q on %s: %s", *projectID, *to, err)
+	}
+
+	want, err := listTopology(ctx, source)
+	if err != nil {
+		fatalf("%s: %s", *from, err)
+	}
+	have, err := listTopology(ctx, destination)
+	if err != nil {
+		fatalf("%s: %s", *to, err)
+	}
+	for id, state := range want.subscriptions {
+		state.pushEndpoint = mapping.rewrite(state.pushEndpoint)
+		want.subscriptions[id] = state
+	}
+
+	if pruned := prunedResources(*projectID, want, have); *prune && len(pruned) > 0 {
+		if err := confirmDeletion("sync -prune", *yes, describeEmulators([]string{*to}), pruned); err != nil {
+			fatalf("%s", err)
+		}
+	}
+
+	fmt.Printf("Syncing project %q from %s to %s\n", *projectID, *from, *to)
+	counts := syncTopology(ctx, destination, want, have, *prune)
+	fmt.Printf("Sync complete: %d created, %d updated, %d deleted, %d failed\n", counts.created, counts.updated, counts.deleted, counts.failed)
+	if counts.failed > 0 {
+		flushAudit()
+		os.Exit(1)
+	}
+}
+
+// prunedResources lists the names of the resources the destination has and
+// the source doesn't, in the order -prune deletes them.
+func prunedResources(projectID string, want, have topologySnapshot) []string {
+	var pruned []string
+	for _, subscriptionID := range sortedKeys(have.subscriptions) {
+		if _, ok := want.subscriptions[subscriptionID]; !ok {
+			pruned = append(pruned, "projects/"+projectID+"/subscriptions/"+subscriptionID)
+		}
+	}
+	for _, topicID := range sortedKeys(have.topics) {
+		if !want.topics[topicID] {
+			pruned = append(pruned, "projects/"+projectID+"/topics/"+topicID)
+		}
+	}
+	return pruned
+}
+
+// syncTopology makes the destination topology match want, printing each action
+// in a diff-like form as it is taken.
+func syncTopology(ctx context.Context, client *pubsub.Client, want, have topologySnapshot, prune bool) syncCounts {
+	var counts syncCounts
+	audited := func(action, kind, name string) {
+		audit(auditEvent{Action: action, Kind: kind, Project: client.Project(), Name: name, Source: "sync"})
+	}
+	failed := func(format string, args ...interface{}) {
+		warnf(format, args...)
+		counts.failed++
+	}
+
+	for _, topicID := range sortedKeys(want.topics) {
+		if have.topics[topicID] {
+			continue
+		}
+		fmt.Printf("+ topic %s\n", topicID)
+		if _, err := client.CreateTopic(ctx, topicID); err != nil {
+			failed("Unable to create topic %q: %s", topicID, err)
+			continue
+		}
+		audited("created", "topic", topicID)
+		counts.created++
+	}
+
+	for _, subscriptionID := range sortedKeys(want.subscriptions) {
+		state := want.subscriptions[subscriptionID]
+		if state.topic == deletedTopic {
+			fmt.Printf("! subscription %s is orphaned on the source (its topic was deleted), skipping\n", subscriptionID)
+			continue
+		}
+		existing, ok := have.subscriptions[subscriptionID]
+		switch {
+		case !ok:
+			if state.pushEndpoint != "" {
+				fmt.Printf("+ subscription %s (topic %s, push %s)\n", subscriptionID, state.topic, state.pushEndpoint)
+			} else {
+				fmt.Printf("+ subscription %s (topic %s)\n", subscriptionID, state.topic)
+			}
+			_, err := client.CreateSubscription(ctx, subscriptionID, pubsub.SubscriptionConfig{
+				Topic:      client.Topic(state.topic),
+				PushConfig: pubsub.PushConfig{Endpoint: state.pushEndpoint},
+			})
+			if err != nil {
+				failed("Unable to create subscription %q on topic %q: %s", subscriptionID, state.topic, err)
+				continue
+			}
+			audited("created", "subscription", subscriptionID)
+			counts.created++
+		case existing.topic != state.topic:
+			failed("Subscription %q is on topic %q instead of %q and can't be moved; delete it to sync", subscriptionID, existing.topic, state.topic)
+		case existing.pushEndpoint != state.pushEndpoint:
+			fmt.Printf("~ subscription %s push endpoint %q -> %q\n", subscriptionID, existing.pushEndpoint, state.pushEndpoint)
+			pushConfig := pubsub.PushConfig{Endpoint: state.pushEndpoint}
+			_, err := client.Subscription(subscriptionID).Update(ctx, pubsub.SubscriptionConfigToUpdate{PushConfig: &pushConfig})
+			if err != nil {
+				failed("Unable to update subscription %q: %s", subscriptionID, err)
+				continue
+			}
+			audited("updated", "subscription", subscriptionID)
+			counts.updated++
+		}
+	}
+
+	if !prune {
+		return counts
+	}
+
+	// Subscriptions go before topics, so none are left detached.
+	for _, subscriptionID := range sortedKeys(have.subscriptions) {
+		if _, ok := want.subscriptions[subscriptionID]; ok {
+			continue
+		}
+		fmt.Printf("- subscription %s\n", subscriptionID)
+		if err := client.Subscription(subscriptionID).Delete(ctx); err != nil {
+			failed("Unable to delete subscription %q: %s", subscriptionID, err)
+			continue
+		}
+		audited("deleted", "subscription", subscriptionID)
+		counts.deleted++
+	}
+	for _, topicID := range sortedKeys(have.topics) {
+		if want.topics[topicID] {
+			continue
+		}
+		fmt.Printf("- topic %s\n", topicID)
+		if err := client.Topic(topicID).Delete(ctx); err != nil {
+			failed("Unable to delete topic %q: %s", topicID, err)
+			continue
+		}
+		audited("deleted", "topic", topicID)
+		counts.deleted++
+	}
+	return counts
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// teardownCounts tallies what -delete and -recreate removed.
+var teardownCounts struct {
+	subscriptions, topics, missing, failed int
+}
+
+// teardownConfigs deletes the resources declared by the pending configs,
+// leaving them pending to be applied again. They are listed and confirmed
+// first, unless -yes is set. Every subscription is deleted before any topic,
+// so none are left detached. The topics of
+// subscriptions-only configs belong to someone else and are left alone.
+// Resources that don't exist are skipped.
+func teardownConfigs() {
+	configs := pendingConfigs
+	hosts, byHost := pendingTargets()
+	pendingConfigs = configs
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	clients := make(planClients)
+	deleted := make(map[string]bool)
+	remove := func(target *applyTarget, kind, id string, del func(context.Context) error) {
+		key := target.host + " " + target.config.projectID + " " + kind + " " + id
+		if deleted[key] {
+			return
+		}
+		deleted[key] = true
+		err := del(ctx)
+		switch {
+		case status.Code(err) == codes.NotFound:
+			debugf("  %s %q doesn't exist in project %q, nothing to delete", kind, id, target.config.projectID)
+			teardownCounts.missing++
+		case err != nil:
+			warnf("%s: Unable to delete %s %q for project %q: %s", target.config.sourceHint, kind, id, target.config.projectID, err)
+			teardownCounts.failed++
+		default:
+			debugf("  Deleted %s %q in project %q", kind, id, target.config.projectID)
+			if kind == "topic" {
+				teardownCounts.topics++
+			} else {
+				teardownCounts.subscriptions++
+			}
+			target.audit("deleted", kind, id)
+		}
+	}
+
+	command := "-delete"
+	if *recreate {
+		command = "-recreate"
+	}
+	for _, host := range hosts {
+		if host == "" {
+			requireEmulator(command)
+		} else if isProductionHost(host) {
+			fatalf("Refusing to run %s against %s: it must be an emulator", command, host)
+		}
+	}
+	if err := confirmDeletion(command, *yes, describeEmulators(hosts), teardownResources(hosts, byHost)); err != nil {
+		fatalf("%s", err)
+	}
+
+	debugf("Deleting subscriptions")
+	var connected []*applyTarget
+	for _, host := range hosts {
+		for _, target := range byHost[host] {
+			client, err := clients.get(ctx, host, target.config.projectID)
+			if err != nil {
+				warnf("%s: %s", target.config.sourceHint, err)
+				teardownCounts.failed++
+				continue
+			}
+			target.client = client
+			connected = append(connected, target)
+			for _, entry := range target.config.topics {
+				for _, subscription := range target.config.withAutoSub(entry) {
+					remove(target, "subscription", subscription.ID, client.Subscription(subscription.ID).Delete)
+				}
+			}
+		}
+	}
+
+	debugf("Deleting topics")
+	for _, target := range connected {
+		if target.config.subscriptionsOnly {
+			continue
+		}
+		for _, topicID := range target.config.topics.IDs() {
+			remove(target, "topic", topicID, target.client.Topic(topicID).Delete)
+		}
+	}
+
+	fmt.Printf("Deleted %d subscriptions and %d topics, %d already gone\n", teardownCounts.subscriptions, teardownCounts.topics, teardownCounts.missing)
+	if teardownCounts.failed > 0 {
+		fatalf("Unable to delete %d resources", teardownCounts.failed)
+	}
+}
+
+// teardownResources lists the names of the resources teardownConfigs deletes,
+// in the order it deletes them, each prefixed by its host if there are several.
+func teardownResources(hosts []string, byHost map[string][]*applyTarget) []string {
+	var subscriptions, topics []string
+	seen := make(map[string]bool)
+	add := func(list *[]string, host, name string) {
+		if len(hosts) > 1 {
+			name = emulatorName(host) + " " + name
+		}
+		if !seen[name] {
+			seen[name] = true
+			*list = append(*list, name)
+		}
+	}
+	for _, host := range hosts {
+		for _, target := range byHost[host] {
+			prefix := "projects/" + target.config.projectID
+			for _, entry := range target.config.topics {
+				for _, subscription := range target.config.withAutoSub(entry) {
+					add(&subscriptions, host, prefix+"/subscriptions/"+subscription.ID)
+				}
+			}
+			if target.config.subscriptionsOnly {
+				continue
+			}
+			for _, topicID := range target.config.topics.IDs() {
+				add(&topics, host, prefix+"/topics/"+topicID)
+			}
+		}
+	}
+	return append(subscriptions, topics...)
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// templatePlaceholder finds the ${name} placeholders of a template.
+var templatePlaceholder = regexp.MustCompile(`\$\{([^}]*)\}`)
+
+// expandTemplates instantiates the templates of a config file document where
+// its projects use them, before the document is decoded, so that the topics
+// they add are checked like any other. A template is a fragment of a project
+// holding topics, with ${name} placeholders:
+//
+//	templates:
+//	  service-standard:
+//	    topics:
+//	      - id: ${name}-commands
+//	        subscriptions:
+//	          - ${name}-worker
+//	projects:
+//	  - id: my-project
+//	    use:
+//	      - template: service-standard
+//	        vars: {name: billing}
+//
+// The topics of each use are added, in order, before the project's own. The
+// templates and uses are removed from the document.
+func expandTemplates(doc *yaml.Node) error {
+	if doc.Kind != yaml.DocumentNode || len(doc.Content) == 0 || doc.Content[0].Kind != yaml.MappingNode {
+		return nil
+	}
+	root := doc.Content[0]
+	templates := make(map[string]*yaml.Node)
+	if node := removeKey(root, "templates"); node != nil {
+		if node.Kind != yaml.MappingNode {
+			return fmt.Errorf("line %d: expected a mapping of template names to templates", node.Line)
+		}
+		for i := 0; i < len(node.Content); i += 2 {
+			name, template := node.Content[i], node.Content[i+1]
+			if err := decodeMapping(template, "template", &struct {
+				Topics []yaml.Node `yaml:"topics"`
+			}{}, "topics"); err != nil {
+				return fmt.Errorf("template %q: %s", name.Value, err)
+			}
+			templates[name.Value] = template
+		}
+	}
+
+	projects := mappingValue(root, "projects")
+	if projects == nil || projects.Kind != yaml.SequenceNode {
+		return nil
+	}
+	for _, project := range projects.Content {
+		if project.Kind != yaml.MappingNode {
+			continue
+		}
+		uses := removeKey(project, "use")
+		if uses == nil {
+			continue
+		}
+		if uses.Kind == yaml.MappingNode {
+			uses = &yaml.Node{Kind: yaml.SequenceNode, Content: []*yaml.Node{uses}}
+		}
+		if uses.Kind != yaml.SequenceNode {
+			return fmt.Errorf("line %d: expected use to be a template use or a list of them", uses.Line)
+		}
+		var added []*yaml.Node
+		for _, use := range uses.Content {
+			topics, err := instantiateTemplate(use, templates)
+			if err != nil {
+				return err
+			}
+			added = append(added, topics...)
+		}
+		own := mappingValue(project, "topics")
+		if own == nil {
+			key := &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: "topics"}
+			own = &yaml.Node{Kind: yaml.SequenceNode, Tag: "!!seq"}
+			project.Content = append(project.Content, key, own)
+		}
+		if own.Kind == yaml.SequenceNode {
+			own.Content = append(added, own.Content...)
+		}
+	}
+	return nil
+}
+
+// instantiateTemplate returns the topics of the template a project's use
+// names, with its vars in place of the placeholders. Errors name the template
+// and the line of the use.
+func instantiateTemplate(use *yaml.Node, templates map[string]*yaml.Node) ([]*yaml.Node, error) {
+	var spec struct {
+		Template string            `yaml:"template"`
+		Vars     map[string]string `yaml:"vars"`
+	}
+	if err := decodeMapping(use, "template use", &spec, "template", "vars"); err != nil {
+		return nil, err
+	}
+	template, ok := templates[spec.Template]
+	if !ok {
+		if len(templates) == 0 {
+			return nil, fmt.Errorf("line %d: template %q isn't defined, as there are no templates", use.Line, spec.Template)
+		}
+		return nil, fmt.Errorf("line %d: template %q isn't defined, expected one of %s", use.Line, spec.Template, strings.Join(sortedKeys(templates), ", "))
+	}
+	fail := func(format string, args ...interface{}) error {
+		return fmt.Errorf("line %d: template %q: %s", use.Line, spec.Template, fmt.Sprintf(format, args...))
+	}
+
+	used := make(map[string]bool)
+	var missing []string
+	topics := mappingValue(template, "topics")
+	if topics == nil {
+		return nil, nil
+	}
+	expanded := substituteNode(topics, func(name string) string {
+		used[name] = true
+		value, ok := spec.Vars[name]
+		if !ok {
+			missing = append(missing, name)
+		}
+		return value
+	})
+	if len(missing) > 0 {
+		return nil, fail("no value for ${%s} in vars", missing[0])
+	}
+	for _, name := range sortedKeys(spec.Vars) {
+		if !used[name] {
+			return nil, fail("var %q isn't used", name)
+		}
+	}
+
+	// Check the expansion here, so that its errors name the use.
+	var check []fileTopic
+	if err := expanded.Decode(&check); err != nil {
+		return nil, fail("%s", err)
+	}
+	return expanded.Content, nil
+}
+
+// substituteNode returns a deep copy of node with the ${name} placeholders of
+// its scalars replaced by value(name).
+func substituteNode(node *yaml.Node, value func(string) string) *yaml.Node {
+	copied := *node
+	if node.Kind == yaml.ScalarNode && templatePlaceholder.MatchString(node.Value) {
+		copied.Value = templatePlaceholder.ReplaceAllStringFunc(node.Value, func(placeholder string) string {
+			return value(placeholder[2 : len(placeholder)-1])
+		})
+		// Resolve the type of a plain scalar again, as a number may now be one.
+		if node.Style == 0 {
+			copied.Tag = ""
+		}
+	}
+	copied.Content = nil
+	for _, child := range node.Content {
+		copied.Content = append(copied.Content, substituteNode(child, value))
+	}
+	return &copied
+}
+
+// mappingValue returns the value of key in a mapping node, or nil.
+func mappingValue(node *yaml.Node, key string) *yaml.Node {
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value == key {
+			return node.Content[i+1]
+		}
+	}
+	return nil
+}
+
+// removeKey removes key from a mapping node, returning its value, or nil.
+func removeKey(node *yaml.Node, key string) *yaml.Node {
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value == key {
+			value := node.Content[i+1]
+			node.Content = append(node.Content[:i], node.Content[i+2:]...)
+			return value
+		}
+	}
+	return nil
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+const templateConfig = `
+templates:
+  service:
+    topics:
+      - id: ${name}-commands
+        subscriptions:
+          - id: ${name}-worker
+            ackDeadlineSeconds: ${ack}
+projects:
+  - id: p
+    use:
+      template: service
+      vars: {name: billing, ack: 30}
+    topics:
+      - id: own
+`
+
+func TestExpandTemplates(t *testing.T) {
+	config, err := parseConfigFile([]byte(templateConfig), "c.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	topics := config.Projects[0].Topics
+	if len(topics) != 2 || topics[0].ID != "billing-commands" || topics[1].ID != "own" {
+		t.Fatalf("parseConfigFile() read topics %+v, want billing-commands and then own", topics)
+	}
+	if subscription := topics[0].Subscriptions[0]; subscription.ID != "billing-worker" || subscription.AckDeadlineSeconds != 30 {
+		t.Errorf("parseConfigFile() read subscription %+v, want billing-worker with a 30s ack deadline", subscription)
+	}
+}
+
+func TestExpandTemplatesErrors(t *testing.T) {
+	tests := []struct {
+		from, to, want string
+	}{
+		{"template: service", "template: svc", `c.yaml: line 12: template "svc" isn't defined, expected one of service`},
+		{"ack: 30}", "ack: 5}", `c.yaml: line 12: template "service": line 7: subscription "billing-worker": ackDeadlineSeconds must be between 10 and 600`},
+		{", ack: 30", "", `c.yaml: line 12: template "service": no value for ${ack} in vars`},
+		{"ack: 30}", "ack: 30, team: x}", `c.yaml: line 12: template "service": var "team" isn't used`},
+	}
+	for _, test := range tests {
+		_, err := parseConfigFile([]byte(strings.Replace(templateConfig, test.from, test.to, 1)), "c.yaml")
+		if err == nil || !strings.HasPrefix(err.Error(), test.want) {
+			t.Errorf("parseConfigFile() with %q = %v, want %s", test.to, err, test.want)
+		}
+	}
+}
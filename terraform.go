@@ -0,0 +1,326 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+
+	"github.com/hashicorp/hcl/v2"
+	"github.com/hashicorp/hcl/v2/hclparse"
+	"github.com/hashicorp/hcl/v2/hclsyntax"
+	"github.com/zclconf/go-cty/cty"
+	"github.com/zclconf/go-cty/cty/function"
+)
+
+const (
+	tfTopicType        = "google_pubsub_topic"
+	tfSubscriptionType = "google_pubsub_subscription"
+)
+
+// tfMetaArguments are resource arguments that are either handled when
+// expanding instances or don't describe topology, so are never reported as
+// unsupported attributes.
+var tfMetaArguments = map[string]bool{
+	"count":      true,
+	"for_each":   true,
+	"depends_on": true,
+	"provider":   true,
+	"lifecycle":  true,
+}
+
+// tfToSet stands in for Terraform's toset() so that for_each over a literal
+// list can be evaluated. Values are passed through unchanged.
+var tfToSet = function.New(&function.Spec{
+	Params: []function.Parameter{{Name: "value", Type: cty.DynamicPseudoType}},
+	Type: func(args []cty.Value) (cty.Type, error) {
+		return args[0].Type(), nil
+	},
+	Impl: func(args []cty.Value, _ cty.Type) (cty.Value, error) {
+		return args[0], nil
+	},
+})
+
+// tfResource is a single google_pubsub_* resource block.
+type tfResource struct {
+	name string
+	body *hclsyntax.Body
+}
+
+// label identifies the resource in messages, including where it was declared.
+func (r tfResource) label(kind string) string {
+	return fmt.Sprintf("%s:%d: %s.%s", r.body.SrcRange.Filename, r.body.SrcRange.Start.Line, kind, r.name)
+}
+
+// importTerraform reads google_pubsub_topic and google_pubsub_subscription
+// resources from the .tf files in dir.
+func importTerraform(dir string, defaultProject string, mapping endpointMapping, topology *importedTopology) error {
+	files, err := filepath.Glob(filepath.Join(dir, "*.tf"))
+	if err != nil {
+		return fmt.Errorf("Unable to list Terraform files in %s: %s", dir, err)
+	}
+	if len(files) == 0 {
+		return fmt.Errorf("No Terraform files found in %s", dir)
+	}
+	sort.Strings(files)
+
+	parser := hclparse.NewParser()
+	var topics, subscriptions []tfResource
+	for _, path := range files {
+		debugf("Parsing Terraform file %s", path)
+		file, diags := parser.ParseHCLFile(path)
+		if diags.HasErrors() {
+			return fmt.Errorf("Unable to parse Terraform file: %s", diags.Error())
+		}
+
+		for _, block := range file.Body.(*hclsyntax.Body).Blocks {
+			if block.Type != "resource" || len(block.Labels) != 2 {
+				continue
+			}
+			resource := tfResource{name: block.Labels[1], body: block.Body}
+			switch block.Labels[0] {
+			case tfTopicType:
+				topics = append(topics, resource)
+			case tfSubscriptionType:
+				subscriptions = append(subscriptions, resource)
+			}
+		}
+	}
+
+	ctx := &hcl.EvalContext{
+		Variables: make(map[string]cty.Value),
+		Functions: map[string]function.Function{"toset": tfToSet},
+	}
+
+	// Topics are imported first so subscriptions can refer to them as
+	// google_pubsub_topic.<name>.name or .id.
+	topicValues := make(map[string]cty.Value)
+	topicProjects := make(map[string]string)
+	for _, resource := range topics {
+		tfReportUnsupported(resource.body, resource.label(tfTopicType), []string{"name", "project"}, nil, topology)
+		instances, err := tfInstances(resource, ctx)
+		if err != nil {
+			topology.unsupportedf("%s: %s", resource.label(tfTopicType), err)
+			continue
+		}
+
+		values := make(map[string]cty.Value)
+		for _, instance := range instances {
+			projectID, topicID, err := tfTopic(resource, instance.ctx, defaultProject)
+			if err != nil {
+				topology.unsupportedf("%s: %s", resource.label(tfTopicType), err)
+				continue
+			}
+			debugf("  Found topic %q in project %q", topicID, projectID)
+			topology.addTopic(projectID, topicID)
+			if existing, ok := topicProjects[topicID]; ok && existing != projectID {
+				// Ambiguous, so subscriptions must name the project.
+				projectID = ""
+			}
+			topicProjects[topicID] = projectID
+			values[instance.key] = cty.ObjectVal(map[string]cty.Value{
+				"name": cty.StringVal(topicID),
+				"id":   cty.StringVal(fmt.Sprintf("projects/%s/topics/%s", projectID, topicID)),
+			})
+		}
+		if _, ok := resource.body.Attributes["for_each"]; ok {
+			if len(values) > 0 {
+				topicValues[resource.name] = cty.MapVal(values)
+			}
+		} else if value, ok := values[""]; ok {
+			topicValues[resource.name] = value
+		}
+	}
+	if len(topicValues) > 0 {
+		ctx.Variables[tfTopicType] = cty.ObjectVal(topicValues)
+	}
+
+	for _, resource := range subscriptions {
+		tfReportUnsupported(resource.body, resource.label(tfSubscriptionType), []string{"name", "project", "topic"}, []string{"push_config"}, topology)
+		for _, block := range resource.body.Blocks {
+			if block.Type == "push_config" {
+				tfReportUnsupported(block.Body, resource.label(tfSubscriptionType)+" push_config", []string{"push_endpoint"}, nil, topology)
+			}
+		}
+		instances, err := tfInstances(resource, ctx)
+		if err != nil {
+			topology.unsupportedf("%s: %s", resource.label(tfSubscriptionType), err)
+			continue
+		}
+		for _, instance := range instances {
+			if err := tfSubscription(resource, instance.ctx, topicProjects, defaultProject, mapping, topology); err != nil {
+				topology.unsupportedf("%s: %s", resource.label(tfSubscriptionType), err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// tfInstance is one instance of a resource with its each.key and the context
+// to evaluate its attributes in.
+type tfInstance struct {
+	key string
+	ctx *hcl.EvalContext
+}
+
+// tfInstances expands a resource's for_each meta-argument into one instance
+// per element, ordered by key. Only for_each over literal maps and sets is
+// supported. Resources without for_each have a single instance with an empty
+// key.
+func tfInstances(resource tfResource, ctx *hcl.EvalContext) ([]tfInstance, error) {
+	if _, ok := resource.body.Attributes["count"]; ok {
+		return nil, fmt.Errorf("count is not supported")
+	}
+
+	forEach, ok := resource.body.Attributes["for_each"]
+	if !ok {
+		return []tfInstance{{"", ctx}}, nil
+	}
+
+	value, diags := forEach.Expr.Value(ctx)
+	if diags.HasErrors() || !value.IsWhollyKnown() || value.IsNull() {
+		return nil, fmt.Errorf("for_each is only supported over literal maps and sets")
+	}
+
+	var instances []tfInstance
+	for it := value.ElementIterator(); it.Next(); {
+		key, element := it.Element()
+		if !value.Type().IsObjectType() && !value.Type().IsMapType() {
+			key = element
+		}
+		if key.Type() != cty.String {
+			return nil, fmt.Errorf("for_each keys must be strings")
+		}
+
+		instanceCtx := ctx.NewChild()
+		instanceCtx.Variables = map[string]cty.Value{
+			"each": cty.ObjectVal(map[string]cty.Value{"key": key, "value": element}),
+		}
+		instances = append(instances, tfInstance{key.AsString(), instanceCtx})
+	}
+	return instances, nil
+}
+
+// tfReportUnsupported records every attribute and nested block of body that
+// isn't in the supported lists or a meta-argument, in source order.
+func tfReportUnsupported(body *hclsyntax.Body, label string, attributes, blocks []string, topology *importedTopology) {
+	supported := make(map[string]bool)
+	for _, name := range append(attributes, blocks...) {
+		supported[name] = true
+	}
+
+	var names []string
+	for name := range body.Attributes {
+		if !supported[name] && !tfMetaArguments[name] {
+			names = append(names, name)
+		}
+	}
+	sort.Slice(names, func(i, j int) bool {
+		return body.Attributes[names[i]].SrcRange.Start.Line < body.Attributes[names[j]].SrcRange.Start.Line
+	})
+	for _, name := range names {
+		topology.unsupportedf("%s: attribute %q at line %d is not supported", label, name, body.Attributes[name].SrcRange.Start.Line)
+	}
+
+	for _, block := range body.Blocks {
+		if !supported[block.Type] && !tfMetaArguments[block.Type] {
+			topology.unsupportedf("%s: block %q at line %d is not supported", label, block.Type, block.TypeRange.Start.Line)
+		}
+	}
+}
+
+// tfTopic evaluates a topic instance into its project and topic IDs.
+func tfTopic(resource tfResource, ctx *hcl.EvalContext, defaultProject string) (string, string, error) {
+	topicID, err := tfString(resource.body, "name", ctx)
+	if err != nil {
+		return "", "", err
+	}
+	if topicID == "" {
+		return "", "", fmt.Errorf("name is required")
+	}
+
+	projectID, err := tfString(resource.body, "project", ctx)
+	if err != nil {
+		return "", "", err
+	}
+	if projectID == "" {
+		projectID = defaultProject
+	}
+	if projectID == "" {
+		return "", "", fmt.Errorf("no project set; pass -project")
+	}
+	return projectID, topicID, nil
+}
+
+// tfSubscription evaluates a subscription instance and adds it to the topology.
+// Without an explicit project, a subscription takes the project of the
+// imported topic it refers to.
+func tfSubscription(resource tfResource, ctx *hcl.EvalContext, topicProjects map[string]string, defaultProject string, mapping endpointMapping, topology *importedTopology) error {
+	var pushEndpoint string
+	for _, block := range resource.body.Blocks {
+		if block.Type == "push_config" {
+			endpoint, err := tfString(block.Body, "push_endpoint", ctx)
+			if err != nil {
+				return err
+			}
+			pushEndpoint = mapping.rewrite(endpoint)
+		}
+	}
+
+	subscriptionID, err := tfString(resource.body, "name", ctx)
+	if err != nil {
+		return err
+	}
+	topicID, err := tfString(resource.body, "topic", ctx)
+	if err != nil {
+		return err
+	}
+	if subscriptionID == "" || topicID == "" {
+		return fmt.Errorf("name and topic are required")
+	}
+
+	projectID, err := tfString(resource.body, "project", ctx)
+	if err != nil {
+		return err
+	}
+
+	// Topics may be referenced by their full resource name, which also
+	// carries the project.
+	if topicProjectID, shortID, ok := splitResourceName(topicID, "topics"); ok {
+		if projectID == "" {
+			projectID = topicProjectID
+		}
+		topicID = shortID
+	}
+	if projectID == "" {
+		projectID = topicProjects[topicID]
+	}
+	if projectID == "" {
+		projectID = defaultProject
+	}
+	if projectID == "" {
+		return fmt.Errorf("no project set; pass -project")
+	}
+
+	debugf("  Found subscription %q on topic %q in project %q", subscriptionID, topicID, projectID)
+	topology.addSubscription(projectID, topicID, subscriptionID, pushEndpoint)
+	return nil
+}
+
+// tfString evaluates an optional string attribute, returning "" when it is
+// absent.
+func tfString(body *hclsyntax.Body, name string, ctx *hcl.EvalContext) (string, error) {
+	attribute, ok := body.Attributes[name]
+	if !ok {
+		return "", nil
+	}
+
+	value, diags := attribute.Expr.Value(ctx)
+	if diags.HasErrors() {
+		return "", fmt.Errorf("%s at line %d must be a literal or refer to an imported topic", name, attribute.SrcRange.Start.Line)
+	}
+	if value.IsNull() || !value.IsKnown() || value.Type() != cty.String {
+		return "", fmt.Errorf("%s at line %d must be a string", name, attribute.SrcRange.Start.Line)
+	}
+	return value.AsString(), nil
+}
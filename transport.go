@@ -0,0 +1,377 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/pubsub"
+	"github.com/thinkfluent/pubsubc/pkg/pubsubc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// topologyClient is the part of the Pub/Sub API used to apply configs: what
+// the pubsubc package creates topics and subscriptions through, and the
+// schemas and seed messages the command adds.
+type topologyClient interface {
+	pubsubc.Topology
+	// topicSchema returns the ID of the schema a topic validates messages
+	// against, which is empty if there is none, and their encoding.
+	topicSchema(ctx context.Context, topicID string) (string, string, error)
+	// createSchema creates a schema, returning an AlreadyExists error if
+	// there is one with the same ID.
+	createSchema(ctx context.Context, schema schemaDefinition) error
+	// publish publishes a message and waits for it to be accepted.
+	publish(ctx context.Context, topicID string, message seedMessage) error
+}
+
+// configureTransport checks the -transport flag against the options that
+// don't work with it.
+func configureTransport() {
+	switch *transport {
+	case "grpc":
+	case "rest":
+		if *verifyDelivery {
+			fatalf("-verify-delivery isn't supported with -transport rest")
+		}
+		if *smokeTestPush {
+			fatalf("-smoke-test-push isn't supported with -transport rest")
+		}
+		if *verify {
+			fatalf("-verify isn't supported with -transport rest")
+		}
+		// Deleting and listing resources is only implemented with gRPC.
+		if *deleteConfigs || *recreate || *fresh != "" {
+			fatalf("-delete, -recreate and -fresh aren't supported with -transport rest")
+		}
+		if command := flag.Arg(0); command != "" && command != "import" {
+			warnf("-transport rest only applies to creating configs; %s uses gRPC", command)
+		}
+	default:
+		fatalf("Unknown -transport %q, expected grpc or rest", *transport)
+	}
+}
+
+// connectTopology sets up the client a target is applied with.
+func connectTopology(ctx context.Context, target *applyTarget) error {
+	if *transport == "rest" {
+		host := target.host
+		if host == "" {
+			host = os.Getenv("PUBSUB_EMULATOR_HOST")
+		}
+		if host == "" {
+			return fmt.Errorf("-transport rest requires an emulator host")
+		}
+		target.topology = &restTopology{
+			base:       fmt.Sprintf("http://%s/v1/projects/%s", host, target.config.projectID),
+			projectID:  target.config.projectID,
+			httpClient: &http.Client{Timeout: 30 * time.Second},
+		}
+		debugf("Using the REST API of %s for project %q", host, target.config.projectID)
+		return nil
+	}
+
+	client, err := connect(ctx, target.config.projectID, target.host)
+	if err != nil {
+		return err
+	}
+	target.client = client
+	topology := grpcTopology{Topology: pubsubc.NewTopology(client), client: client}
+	if len(target.config.schemas) > 0 {
+		if topology.schemas, err = newSchemaClient(ctx, target.config.projectID, target.host); err != nil {
+			return fmt.Errorf("Unable to create schema client to project %q: %s", target.config.projectID, err)
+		}
+	}
+	target.topology = topology
+	return nil
+}
+
+// grpcTopology applies configs through the Pub/Sub client library. schemas is
+// only set for configs that declare schemas.
+type grpcTopology struct {
+	pubsubc.Topology
+	client  *pubsub.Client
+	schemas *pubsub.SchemaClient
+}
+
+func (t grpcTopology) topicSchema(ctx context.Context, topicID string) (string, string, error) {
+	config, err := t.client.Topic(topicID).Config(ctx)
+	if err != nil || config.SchemaSettings == nil {
+		return "", "", err
+	}
+	encoding := "json"
+	if config.SchemaSettings.Encoding == pubsub.EncodingBinary {
+		encoding = "binary"
+	}
+	name := config.SchemaSettings.Schema
+	return name[strings.LastIndex(name, "/")+1:], encoding, nil
+}
+
+func (t grpcTopology) createSchema(ctx context.Context, schema schemaDefinition) error {
+	_, err := t.schemas.CreateSchema(ctx, schema.id, pubsub.SchemaConfig{
+		Type:       schema.schemaType,
+		Definition: schema.definition,
+	})
+	return err
+}
+
+func (t grpcTopology) publish(ctx context.Context, topicID string, message seedMessage) error {
+	topic := t.client.Topic(topicID)
+	defer topic.Stop()
+	_, err := topic.Publish(ctx, &pubsub.Message{Data: message.data, Attributes: message.attributes}).Get(ctx)
+	return err
+}
+
+// restTopology applies configs through the emulator's HTTP/JSON API, for
+// networks that break gRPC.
+type restTopology struct {
+	base       string
+	projectID  string
+	httpClient *http.Client
+}
+
+// restError is the error body returned by the HTTP/JSON API.
+type restError struct {
+	Error struct {
+		Message string `json:"message"`
+		Status  string `json:"status"`
+	} `json:"error"`
+}
+
+// do sends a request to the API, returning the response status code. Any
+// status outside 2xx other than allowed is an error.
+func (t *restTopology) do(ctx context.Context, method, path string, body interface{}, allowed int) (int, error) {
+	var reader io.Reader
+	if body != nil {
+		encoded, err := json.Marshal(body)
+		if err != nil {
+			return 0, err
+		}
+		reader = bytes.NewReader(encoded)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, t.base+path, reader)
+	if err != nil {
+		return 0, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := t.httpClient.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	if (resp.StatusCode >= 200 && resp.StatusCode <= 299) || resp.StatusCode == allowed {
+		return resp.StatusCode, nil
+	}
+
+	data, _ := io.ReadAll(io.LimitReader(resp.Body, 64*1024))
+	var apiErr restError
+	if json.Unmarshal(data, &apiErr) == nil && apiErr.Error.Message != "" {
+		return resp.StatusCode, fmt.Errorf("%s %s: HTTP %d %s: %s", method, path, resp.StatusCode, apiErr.Error.Status, apiErr.Error.Message)
+	}
+	return resp.StatusCode, fmt.Errorf("%s %s: HTTP %d: %s", method, path, resp.StatusCode, bytes.TrimSpace(data))
+}
+
+func (t *restTopology) TopicExists(ctx context.Context, topicID string) (bool, error) {
+	code, err := t.do(ctx, http.MethodGet, "/topics/"+topicID, nil, http.StatusNotFound)
+	return code != http.StatusNotFound, err
+}
+
+func (t *restTopology) CreateTopic(ctx context.Context, topic topicEntry) error {
+	body := map[string]interface{}{}
+	if len(topic.Labels) > 0 {
+		body["labels"] = topic.Labels
+	}
+	if topic.Retention > 0 {
+		body["messageRetentionDuration"] = fmt.Sprintf("%ds", int(topic.Retention.Seconds()))
+	}
+	if topic.Schema != "" {
+		body["schemaSettings"] = map[string]string{
+			"schema":   fmt.Sprintf("projects/%s/schemas/%s", t.projectID, topic.Schema),
+			"encoding": strings.ToUpper(topic.Encoding()),
+		}
+	}
+	code, err := t.do(ctx, http.MethodPut, "/topics/"+topic.ID, body, http.StatusConflict)
+	if code == http.StatusConflict {
+		return status.Errorf(codes.AlreadyExists, "Topic %q already exists", topic.ID)
+	}
+	return err
+}
+
+func (t *restTopology) topicSchema(ctx context.Context, topicID string) (string, string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, t.base+"/topics/"+topicID, nil)
+	if err != nil {
+		return "", "", err
+	}
+	resp, err := t.httpClient.Do(req)
+	if err != nil {
+		return "", "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return "", "", fmt.Errorf("GET /topics/%s: HTTP %d", topicID, resp.StatusCode)
+	}
+	var topic struct {
+		SchemaSettings struct {
+			Schema   string `json:"schema"`
+			Encoding string `json:"encoding"`
+		} `json:"schemaSettings"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&topic); err != nil {
+		return "", "", err
+	}
+	name := topic.SchemaSettings.Schema
+	if name == "" {
+		return "", "", nil
+	}
+	encoding := "json"
+	if topic.SchemaSettings.Encoding == "BINARY" {
+		encoding = "binary"
+	}
+	return name[strings.LastIndex(name, "/")+1:], encoding, nil
+}
+
+func (t *restTopology) createSchema(ctx context.Context, schema schemaDefinition) error {
+	schemaType := "AVRO"
+	if schema.schemaType == pubsub.SchemaProtocolBuffer {
+		schemaType = "PROTOCOL_BUFFER"
+	}
+	body := map[string]string{"type": schemaType, "definition": schema.definition}
+	code, err := t.do(ctx, http.MethodPost, "/schemas?schemaId="+url.QueryEscape(schema.id), body, http.StatusConflict)
+	if code == http.StatusConflict {
+		return status.Errorf(codes.AlreadyExists, "Schema %q already exists", schema.id)
+	}
+	return err
+}
+
+func (t *restTopology) CreateSubscription(ctx context.Context, topicID string, subscription subscriptionSpec) error {
+	body := map[string]interface{}{
+		"topic": fmt.Sprintf("projects/%s/topics/%s", t.projectID, topicID),
+	}
+	if subscription.PushEndpoint != "" {
+		body["pushConfig"] = restPushConfig(subscription)
+	}
+	if subscription.BigQueryTable != "" {
+		body["bigqueryConfig"] = map[string]interface{}{
+			"table":          subscription.BigQueryTable,
+			"useTopicSchema": subscription.BigQueryUseTopicSchema,
+			"writeMetadata":  subscription.BigQueryWriteMetadata,
+		}
+	}
+	if subscription.AckDeadline > 0 {
+		body["ackDeadlineSeconds"] = int(subscription.AckDeadline.Seconds())
+	}
+	if subscription.Retention > 0 {
+		body["messageRetentionDuration"] = fmt.Sprintf("%ds", int(subscription.Retention.Seconds()))
+	}
+	if subscription.RetainAcked {
+		body["retainAckedMessages"] = true
+	}
+	if subscription.Ordered {
+		body["enableMessageOrdering"] = true
+	}
+	if subscription.Filter != "" {
+		body["filter"] = subscription.Filter
+	}
+	if len(subscription.Labels) > 0 {
+		body["labels"] = subscription.Labels
+	}
+	if subscription.MinBackoff > 0 || subscription.MaxBackoff > 0 {
+		policy := map[string]string{}
+		if subscription.MinBackoff > 0 {
+			policy["minimumBackoff"] = fmt.Sprintf("%gs", subscription.MinBackoff.Seconds())
+		}
+		if subscription.MaxBackoff > 0 {
+			policy["maximumBackoff"] = fmt.Sprintf("%gs", subscription.MaxBackoff.Seconds())
+		}
+		body["retryPolicy"] = policy
+	}
+	if subscription.DeadLetterTopic != "" {
+		policy := map[string]interface{}{
+			"deadLetterTopic": fmt.Sprintf("projects/%s/topics/%s", t.projectID, subscription.DeadLetterTopic),
+		}
+		if subscription.MaxDeliveryAttempts > 0 {
+			policy["maxDeliveryAttempts"] = subscription.MaxDeliveryAttempts
+		}
+		body["deadLetterPolicy"] = policy
+	}
+	code, err := t.do(ctx, http.MethodPut, "/subscriptions/"+subscription.ID, body, http.StatusConflict)
+	if code == http.StatusConflict {
+		return status.Errorf(codes.AlreadyExists, "Subscription %q already exists", subscription.ID)
+	}
+	return err
+}
+
+func (t *restTopology) SubscriptionPushEndpoint(ctx context.Context, subscriptionID string) (string, bool, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, t.base+"/subscriptions/"+subscriptionID, nil)
+	if err != nil {
+		return "", false, err
+	}
+	resp, err := t.httpClient.Do(req)
+	if err != nil {
+		return "", false, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return "", false, nil
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return "", false, fmt.Errorf("GET /subscriptions/%s: HTTP %d", subscriptionID, resp.StatusCode)
+	}
+	var subscription struct {
+		PushConfig struct {
+			PushEndpoint string `json:"pushEndpoint"`
+		} `json:"pushConfig"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&subscription); err != nil {
+		return "", false, err
+	}
+	return subscription.PushConfig.PushEndpoint, true, nil
+}
+
+func (t *restTopology) UpdatePushConfig(ctx context.Context, subscription subscriptionSpec) error {
+	body := map[string]interface{}{
+		"subscription": map[string]interface{}{
+			"pushConfig": restPushConfig(subscription),
+		},
+		"updateMask": "pushConfig",
+	}
+	_, err := t.do(ctx, http.MethodPatch, "/subscriptions/"+subscription.ID, body, 0)
+	return err
+}
+
+// restPushConfig returns the pushConfig of a subscription in the HTTP/JSON
+// API, which is empty for a pull subscription.
+func restPushConfig(subscription subscriptionSpec) map[string]interface{} {
+	config := map[string]interface{}{"pushEndpoint": subscription.PushEndpoint}
+	if subscription.PushEndpoint != "" && subscription.PushServiceAccount != "" {
+		token := map[string]string{"serviceAccountEmail": subscription.PushServiceAccount}
+		if subscription.PushAudience != "" {
+			token["audience"] = subscription.PushAudience
+		}
+		config["oidcToken"] = token
+	}
+	return config
+}
+
+func (t *restTopology) publish(ctx context.Context, topicID string, message seedMessage) error {
+	encoded := map[string]interface{}{"data": base64.StdEncoding.EncodeToString(message.data)}
+	if len(message.attributes) > 0 {
+		encoded["attributes"] = message.attributes
+	}
+	body := map[string]interface{}{"messages": []interface{}{encoded}}
+	_, err := t.do(ctx, http.MethodPost, "/topics/"+topicID+":publish", body, 0)
+	return err
+}
@@ -0,0 +1,212 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"html/template"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"cloud.google.com/go/pubsub"
+	"google.golang.org/api/iterator"
+)
+
+// uiPage is rendered by uiTemplate.
+type uiPage struct {
+	Emulator  string
+	Refresh   int
+	Generated time.Time
+	Projects  []uiProject
+}
+
+type uiProject struct {
+	ID      string
+	Error   string
+	Topics  []uiTopic
+	Orphans []uiSubscription
+}
+
+type uiTopic struct {
+	ID            string
+	Subscriptions []uiSubscription
+}
+
+// uiSubscription holds the settings of a subscription shown by the UI.
+type uiSubscription struct {
+	ID                  string
+	PushEndpoint        string
+	AckDeadline         time.Duration
+	RetentionDuration   time.Duration
+	Filter              string
+	Ordering            bool
+	DeadLetterTopic     string
+	MaxDeliveryAttempts int
+}
+
+var uiTemplate = template.Must(template.New("ui").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+{{if .Refresh}}<meta http-equiv="refresh" content="{{.Refresh}}">{{end}}
+<title>pubsubc</title>
+<style>
+body { font-family: sans-serif; margin: 2em; }
+table { border-collapse: collapse; margin-bottom: 2em; }
+th, td { border: 1px solid #ccc; padding: 0.3em 0.6em; text-align: left; vertical-align: top; }
+th { background: #f4f4f4; }
+.muted { color: #888; }
+.error { color: #b00; }
+</style>
+</head>
+<body>
+<h1>Pub/Sub emulator {{.Emulator}}</h1>
+<p class="muted">As of {{.Generated.Format "15:04:05"}}{{if .Refresh}}, refreshing every {{.Refresh}}s{{end}}</p>
+{{range .Projects}}
+<h2>Project {{.ID}}</h2>
+{{if .Error}}<p class="error">{{.Error}}</p>{{else}}
+<table>
+<tr><th>Topic</th><th>Subscription</th><th>Delivery</th><th>Ack deadline</th><th>Retention</th><th>Filter</th><th>Ordering</th><th>Dead letter</th></tr>
+{{range $topic := .Topics}}
+{{range .Subscriptions}}<tr><td>{{$topic.ID}}</td>{{template "subscription" .}}</tr>
+{{else}}<tr><td>{{$topic.ID}}</td><td colspan="7" class="muted">no subscriptions</td></tr>
+{{end}}
+{{else}}<tr><td colspan="8" class="muted">no topics</td></tr>
+{{end}}
+{{range .Orphans}}<tr><td class="error">deleted topic</td>{{template "subscription" .}}</tr>
+{{end}}
+</table>
+{{end}}
+{{end}}
+</body>
+</html>
+{{define "subscription"}}<td>{{.ID}}</td>
+<td>{{if .PushEndpoint}}push to {{.PushEndpoint}}{{else}}pull{{end}}</td>
+<td>{{.AckDeadline}}</td>
+<td>{{.RetentionDuration}}</td>
+<td>{{.Filter}}</td>
+<td>{{if .Ordering}}yes{{end}}</td>
+<td>{{if .DeadLetterTopic}}{{.DeadLetterTopic}} after {{.MaxDeliveryAttempts}} attempts{{end}}</td>{{end}}
+`))
+
+// runUI serves a read-only web page listing the topics and subscriptions of
+// one or more projects, until interrupted.
+func runUI(args []string) {
+	var projectIDs stringList
+	flags := flag.NewFlagSet("ui", flag.ExitOnError)
+	listen := flags.String("listen", ":8082", "Address to serve the web UI on")
+	flags.Var(&projectIDs, "project", "Project ID to show (may be repeated)")
+	refresh := flags.Duration("refresh", 5*time.Second, "How often the page reloads itself, 0 to disable")
+	flags.Parse(args)
+
+	if len(projectIDs) == 0 {
+		flags.Usage()
+		fatalf("At least one -project is required")
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	clients := make(map[string]*pubsub.Client)
+	for _, projectID := range projectIDs {
+		client, err := newClient(ctx, projectID, "")
+		if err != nil {
+			fatalf("Unable to create client to project %q: %s", projectID, err)
+		}
+		clients[projectID] = client
+	}
+
+	emulator := os.Getenv("PUBSUB_EMULATOR_HOST")
+	server := &http.Server{Addr: *listen, Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/" {
+			http.NotFound(w, r)
+			return
+		}
+		page := uiPage{Emulator: emulator, Refresh: int(refresh.Seconds()), Generated: time.Now()}
+		for _, projectID := range projectIDs {
+			page.Projects = append(page.Projects, listUIProject(r.Context(), clients[projectID], projectID))
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		if err := uiTemplate.Execute(w, page); err != nil {
+			debugf("Unable to render UI: %s", err)
+		}
+	})}
+	go func() {
+		<-ctx.Done()
+		server.Close()
+	}()
+
+	fmt.Printf("Serving the web UI on %s\n", *listen)
+	if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		fatalf("Unable to serve the web UI on %s: %s", *listen, err)
+	}
+}
+
+// listUIProject lists a project's topics and subscriptions with their
+// settings. Errors are shown on the page rather than failing it.
+func listUIProject(ctx context.Context, client *pubsub.Client, projectID string) uiProject {
+	project := uiProject{ID: projectID}
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	topics := make(map[string]*uiTopic)
+	it := client.Topics(ctx)
+	for {
+		topic, err := it.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			project.Error = fmt.Sprintf("Unable to list topics: %s", err)
+			return project
+		}
+		topics[topic.ID()] = &uiTopic{ID: topic.ID()}
+	}
+
+	subscriptions := client.Subscriptions(ctx)
+	for {
+		config, err := subscriptions.NextConfig()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			project.Error = fmt.Sprintf("Unable to list subscriptions: %s", err)
+			return project
+		}
+
+		subscription := uiSubscription{
+			ID:                config.ID(),
+			PushEndpoint:      config.PushConfig.Endpoint,
+			AckDeadline:       config.AckDeadline,
+			RetentionDuration: config.RetentionDuration,
+			Filter:            config.Filter,
+			Ordering:          config.EnableMessageOrdering,
+		}
+		if config.DeadLetterPolicy != nil {
+			subscription.DeadLetterTopic = config.DeadLetterPolicy.DeadLetterTopic
+			subscription.MaxDeliveryAttempts = config.DeadLetterPolicy.MaxDeliveryAttempts
+		}
+
+		topicID := subscriptionTopic(config.Topic)
+		if topicID == deletedTopic {
+			project.Orphans = append(project.Orphans, subscription)
+			continue
+		}
+		if _, ok := topics[topicID]; !ok {
+			// A topic in another project is shown by its full name.
+			topicID = config.Topic.String()
+			if _, ok := topics[topicID]; !ok {
+				topics[topicID] = &uiTopic{ID: topicID}
+			}
+		}
+		topics[topicID].Subscriptions = append(topics[topicID].Subscriptions, subscription)
+	}
+
+	for _, topicID := range sortedKeys(topics) {
+		project.Topics = append(project.Topics, *topics[topicID])
+	}
+	return project
+}
@@ -0,0 +1,227 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"sync"
+	"time"
+
+	"cloud.google.com/go/pubsub"
+	vkit "cloud.google.com/go/pubsub/apiv1"
+	"cloud.google.com/go/pubsub/apiv1/pubsubpb"
+	"google.golang.org/api/iterator"
+)
+
+// Canary messages carry these attributes so consumers can recognise and
+// ignore them.
+const (
+	canaryAttribute   = "pubsubc-canary"
+	canaryIDAttribute = "pubsubc-canary-id"
+)
+
+// deliveryResult records whether a canary reached a single pull subscription.
+type deliveryResult struct {
+	host           string
+	projectID      string
+	subscriptionID string
+	latency        time.Duration
+	err            error
+}
+
+var (
+	deliveryMu      sync.Mutex
+	deliveryResults []deliveryResult
+)
+
+// verifyDeliveries publishes a canary to every topic of the applied targets
+// and waits for it on each of the topic's pull subscriptions. Hosts are
+// checked in parallel. Results are collected by reportDeliveries.
+func verifyDeliveries(hosts []string, byHost map[string][]*applyTarget) {
+	if !*verifyDelivery {
+		return
+	}
+
+	var wg sync.WaitGroup
+	for _, host := range hosts {
+		targets := byHost[host]
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for _, target := range targets {
+				if target.failed {
+					continue
+				}
+				verifyTarget(target)
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+// verifyTarget checks delivery on every topic of a single applied config.
+func verifyTarget(target *applyTarget) {
+	ctx, cancel := context.WithTimeout(context.Background(), *verifyTimeout)
+	defer cancel()
+
+	record := func(subscriptionID string, latency time.Duration, err error) {
+		deliveryMu.Lock()
+		deliveryResults = append(deliveryResults, deliveryResult{target.host, target.config.projectID, subscriptionID, latency, err})
+		deliveryMu.Unlock()
+	}
+
+	subscriber, err := newSubscriberClient(ctx, target.host)
+	if err != nil {
+		record("*", 0, fmt.Errorf("Unable to create subscriber client: %s", err))
+		return
+	}
+	defer subscriber.Close()
+
+	for _, topicID := range target.config.topics.IDs() {
+		topic := target.client.Topic(topicID)
+		var subscriptions []*pubsub.Subscription
+		it := topic.Subscriptions(ctx)
+		for {
+			subscription, err := it.Next()
+			if err == iterator.Done {
+				break
+			}
+			if err != nil {
+				record(topicID+"/*", 0, fmt.Errorf("Unable to list subscriptions: %s", err))
+				break
+			}
+			config, err := subscription.Config(ctx)
+			if err != nil {
+				record(subscription.ID(), 0, fmt.Errorf("Unable to fetch subscription: %s", err))
+				continue
+			}
+			if config.PushConfig.Endpoint != "" {
+				debugf("  Not checking delivery to push subscription %q", subscription.ID())
+				continue
+			}
+			subscriptions = append(subscriptions, subscription)
+		}
+		if len(subscriptions) == 0 {
+			continue
+		}
+
+		canaryID := newCanaryID()
+		published := time.Now()
+		_, err := topic.Publish(ctx, &pubsub.Message{
+			Data:       []byte("pubsubc delivery check"),
+			Attributes: map[string]string{canaryAttribute: "true", canaryIDAttribute: canaryID},
+		}).Get(ctx)
+		topic.Stop()
+		if err != nil {
+			for _, subscription := range subscriptions {
+				record(subscription.ID(), 0, fmt.Errorf("Unable to publish canary to topic %q: %s", topicID, err))
+			}
+			continue
+		}
+		debugf("  Published canary %s to topic %q", canaryID, topicID)
+
+		// Wait on the subscriptions together, so each latency is its own.
+		var wg sync.WaitGroup
+		for _, subscription := range subscriptions {
+			subscription := subscription
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				received, err := awaitCanary(ctx, subscriber, subscription.String(), canaryID)
+				if err != nil {
+					record(subscription.ID(), 0, err)
+					return
+				}
+				record(subscription.ID(), received.Sub(published), nil)
+			}()
+		}
+		wg.Wait()
+	}
+}
+
+// awaitCanary pulls from a subscription until the canary arrives, returning
+// when it did. The canary is acked; every other message is released for
+// redelivery.
+func awaitCanary(ctx context.Context, subscriber *vkit.SubscriberClient, subscriptionName, canaryID string) (time.Time, error) {
+	for {
+		resp, err := subscriber.Pull(ctx, &pubsubpb.PullRequest{
+			Subscription:      subscriptionName,
+			ReturnImmediately: true,
+			MaxMessages:       100,
+		})
+		if err != nil {
+			if ctx.Err() != nil {
+				return time.Time{}, fmt.Errorf("canary not received within %s", *verifyTimeout)
+			}
+			return time.Time{}, err
+		}
+
+		var found string
+		var others []string
+		for _, received := range resp.ReceivedMessages {
+			if received.Message.Attributes[canaryIDAttribute] == canaryID {
+				found = received.AckId
+			} else {
+				others = append(others, received.AckId)
+			}
+		}
+		if len(others) > 0 {
+			subscriber.ModifyAckDeadline(context.Background(), &pubsubpb.ModifyAckDeadlineRequest{
+				Subscription:       subscriptionName,
+				AckIds:             others,
+				AckDeadlineSeconds: 0,
+			})
+		}
+		if found != "" {
+			received := time.Now()
+			if err := subscriber.Acknowledge(ctx, &pubsubpb.AcknowledgeRequest{Subscription: subscriptionName, AckIds: []string{found}}); err != nil {
+				warnf("Unable to ack canary on %s: %s", subscriptionName, err)
+			}
+			return received, nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return time.Time{}, fmt.Errorf("canary not received within %s", *verifyTimeout)
+		case <-time.After(100 * time.Millisecond):
+		}
+	}
+}
+
+// newCanaryID returns a random ID for a canary message.
+func newCanaryID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// reportDeliveries prints the outcome of every delivery check, reporting
+// whether all canaries arrived.
+func reportDeliveries() bool {
+	if !*verifyDelivery || len(deliveryResults) == 0 {
+		return true
+	}
+
+	return printDeliveryResults("Delivery checks:", deliveryResults)
+}
+
+// printDeliveryResults prints a list of delivery results under title,
+// reporting whether they all succeeded.
+func printDeliveryResults(title string, results []deliveryResult) bool {
+	ok := true
+	fmt.Println(title)
+	for _, result := range results {
+		name := result.projectID + "/" + result.subscriptionID
+		if result.host != "" {
+			name = result.host + " " + name
+		}
+		if result.err != nil {
+			ok = false
+			fmt.Printf("  %s: FAILED: %s\n", name, result.err)
+		} else {
+			fmt.Printf("  %s: delivered in %s\n", name, result.latency.Round(time.Millisecond))
+		}
+	}
+	return ok
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+)
+
+// verifySummary is what -verify found, printed with -output=json or
+// -output=template.
+type verifySummary struct {
+	Configs   int                `json:"configs"`
+	Problems  int                `json:"problems"`
+	Resources []verifiedResource `json:"resources"`
+}
+
+// verifiedResource is a topic or subscription -verify checked, or an orphaned
+// subscription it found. Outcome is one of ok, missing, wrong-topic,
+// wrong-push-endpoint, orphaned or error.
+type verifiedResource struct {
+	Project      string `json:"project"`
+	Host         string `json:"host,omitempty"`
+	Source       string `json:"source"`
+	Topic        string `json:"topic"`
+	Subscription string `json:"subscription"`
+	Outcome      string `json:"outcome"`
+}
+
+// runVerifyConfigs checks that every topic and subscription the discovered
+// configs declare exists, on the declared topic and with the declared push
+// endpoint, and exits 1 if any doesn't. With -probe-push, the push endpoints
+// of the subscriptions that match are probed too. Only read-only calls are
+// made to Pub/Sub, so it is safe against shared environments. Subscriptions
+// whose topic was deleted are reported too, but only fail the check if
+// declared.
+func runVerifyConfigs() {
+	if configCount == 0 {
+		fatalf("No Pub/Sub configurations found")
+	}
+	hosts, byHost := pendingTargets()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	clients := make(planClients)
+	// Configs may declare the same resource more than once.
+	checked := make(map[string]bool)
+	first := func(host, projectID, kind, id string) bool {
+		key := host + " " + projectID + " " + kind + " " + id
+		if checked[key] {
+			return false
+		}
+		checked[key] = true
+		return true
+	}
+
+	topics, subscriptions, problems := 0, 0, skippedConfigs
+	result := verifySummary{Resources: []verifiedResource{}}
+	for _, host := range hosts {
+		for _, target := range byHost[host] {
+			config := target.config
+			projectID := config.projectID
+			record := func(topicID, subscriptionID, outcome string) {
+				result.Resources = append(result.Resources, verifiedResource{
+					Project: projectID, Host: host, Source: config.sourceHint,
+					Topic: topicID, Subscription: subscriptionID, Outcome: outcome,
+				})
+			}
+			client, err := clients.get(ctx, host, projectID)
+			if err != nil {
+				target.log().warnf("%s: %s", config.sourceHint, err)
+				record("", "", "error")
+				problems++
+				continue
+			}
+
+			for _, entry := range config.topics {
+				topicID := entry.ID
+				if first(host, projectID, "topic", topicID) {
+					topics++
+					log := target.log("topic", topicID)
+					state, err := observeTopic(ctx, client, topicID)
+					switch {
+					case err != nil:
+						log.warnf("%s: %s", config.sourceHint, err)
+						record(topicID, "", "error")
+						problems++
+					case !state.Exists:
+						log.warnf("%s: Topic %q in project %q doesn't exist", config.sourceHint, topicID, projectID)
+						record(topicID, "", "missing")
+						problems++
+					default:
+						log.debugf("  Topic %q in project %q exists", topicID, projectID)
+						record(topicID, "", "ok")
+					}
+				}
+
+				for _, subscription := range config.withAutoSub(entry) {
+					subscriptionID := subscription.ID
+					// A subscription declared on two topics is wrong for one.
+					if !first(host, projectID, "subscription", topicID+" "+subscriptionID) {
+						continue
+					}
+					subscriptions++
+					log := target.log("topic", topicID, "subscription", subscriptionID)
+					state, err := observeSubscription(ctx, client, subscriptionID)
+					switch {
+					case err != nil:
+						log.warnf("%s: %s", config.sourceHint, err)
+						record(topicID, subscriptionID, "error")
+						problems++
+					case !state.Exists:
+						log.warnf("%s: Subscription %q on topic %q in project %q doesn't exist", config.sourceHint, subscriptionID, topicID, projectID)
+						record(topicID, subscriptionID, "missing")
+						problems++
+					case state.Topic == deletedTopic:
+						log.warnf("%s: Subscription %q in project %q is orphaned: its topic %q was deleted", config.sourceHint, subscriptionID, projectID, topicID)
+						record(topicID, subscriptionID, "orphaned")
+						problems++
+					case state.Topic != topicID:
+						log.warnf("%s: Subscription %q in project %q is on topic %q instead of %q", config.sourceHint, subscriptionID, projectID, state.Topic, topicID)
+						record(topicID, subscriptionID, "wrong-topic")
+						problems++
+					case state.PushEndpoint != subscription.PushEndpoint:
+						log.warnf("%s: Subscription %q on topic %q in project %q has push endpoint %q instead of %q", config.sourceHint, subscriptionID, topicID, projectID, state.PushEndpoint, subscription.PushEndpoint)
+						record(topicID, subscriptionID, "wrong-push-endpoint")
+						problems++
+					default:
+						log.debugf("    Subscription %q on topic %q in project %q matches", subscriptionID, topicID, projectID)
+						record(topicID, subscriptionID, "ok")
+						if subscription.PushEndpoint != "" {
+							probeEndpoint(projectID, subscriptionID, subscription.PushEndpoint)
+						}
+					}
+				}
+			}
+
+			if first(host, projectID, "orphans", "") {
+				declared := declaredTopics(byHost[host], projectID)
+				orphans, err := listOrphans(ctx, client)
+				if err != nil {
+					target.log().warnf("%s: Unable to list subscriptions for project %q: %s", config.sourceHint, projectID, err)
+					problems++
+				}
+				for _, orphan := range orphans {
+					if _, ok := declared[orphan.ID()]; !ok {
+						target.log("subscription", orphan.ID()).warnf("Subscription %q in project %q, which the configs don't declare, is orphaned: its topic was deleted", orphan.ID(), projectID)
+						record("", orphan.ID(), "orphaned")
+					}
+				}
+			}
+		}
+	}
+
+	fmt.Printf("Verified %d topics and %d subscriptions from %d Pub/Sub configurations\n", topics, subscriptions, configCount)
+	if *output != "text" {
+		result.Configs, result.Problems = configCount, problems
+		if err := printDocument(summaryOutput, result, summaryTemplate); err != nil {
+			warnf("Unable to print the summary: %s", err)
+		}
+	}
+	reportProbes()
+	if problems > 0 {
+		fmt.Fprintf(os.Stderr, "%s: Found %d problems\n", os.Args[0], problems)
+		os.Exit(1)
+	}
+	fmt.Println("Every topic and subscription exists and matches the configs")
+}
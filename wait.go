@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/thinkfluent/pubsubc/pkg/pubsubc"
+)
+
+// awaitEmulators waits for the emulator on every host to answer before
+// anything is applied, for up to -wait-timeout, so pubsubc can be started
+// alongside the emulator. Hosts that still don't answer are left for the
+// phases to report.
+func awaitEmulators(hosts []string, byHost map[string][]*applyTarget) {
+	if *waitTimeout <= 0 {
+		return
+	}
+	var wg sync.WaitGroup
+	for _, host := range hosts {
+		if host == "" && os.Getenv("PUBSUB_EMULATOR_HOST") == "" {
+			// The real Pub/Sub service doesn't need waiting for.
+			continue
+		}
+		target := byHost[host][0]
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := awaitEmulator(target); err != nil {
+				warnf("Emulator at %s still isn't answering after %s: %s", emulatorName(target.host), *waitTimeout, err)
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+// awaitEmulator connects as target would and checks for its first topic,
+// retrying with exponential backoff while the emulator is unavailable.
+func awaitEmulator(target *applyTarget) error {
+	ctx, cancel := context.WithTimeout(context.Background(), *waitTimeout)
+	defer cancel()
+
+	// Connecting is lazy, so only the check shows whether anything answers.
+	probe := &applyTarget{config: target.config, host: target.host}
+	if err := connectTopology(ctx, probe); err != nil {
+		// As below, that's for the phases to report.
+		return nil
+	}
+	backoff := 250 * time.Millisecond
+	for attempt := 1; ; attempt++ {
+		// The client library retries an unavailable emulator itself, so each
+		// attempt is bounded to keep the attempts visible.
+		attemptCtx, cancelAttempt := context.WithTimeout(ctx, 5*time.Second)
+		_, err := probe.topology.TopicExists(attemptCtx, target.config.topics[0].ID)
+		cancelAttempt()
+		if err == nil {
+			debugf("Emulator at %s is answering", emulatorName(target.host))
+			return nil
+		}
+		if !pubsubc.IsTransient(err) {
+			// Anything else is for the phases to report.
+			return nil
+		}
+		debugf("Emulator at %s isn't answering (attempt %d), retrying in %s: %s", emulatorName(target.host), attempt, backoff, err)
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(backoff):
+		}
+		if backoff *= 2; backoff > 5*time.Second {
+			backoff = 5 * time.Second
+		}
+	}
+}
+
+// emulatorName names the emulator at host, which is "" for
+// PUBSUB_EMULATOR_HOST.
+func emulatorName(host string) string {
+	if host == "" {
+		return os.Getenv("PUBSUB_EMULATOR_HOST")
+	}
+	return host
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/filters"
+	"github.com/docker/docker/client"
+)
+
+// watchDockerEvents applies the Docker label configs of containers as they
+// start, until interrupted. A lost connection to the Docker daemon is retried
+// with backoff.
+func watchDockerEvents() {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	cli, err := client.NewClientWithOpts(client.FromEnv)
+	if err != nil {
+		fatalf("Unable to create Docker client: %s", err.Error())
+	}
+	defer cli.Close()
+
+	fmt.Println("Watching for Docker containers starting")
+	imageLabelCache := make(map[string]map[string]string)
+	backoff := time.Second
+	for {
+		connected, err := watchDockerOnce(ctx, cli, imageLabelCache)
+		if ctx.Err() != nil {
+			sdNotify("STOPPING=1")
+			fmt.Println("Stopped watching for Docker containers")
+			return
+		}
+		if connected {
+			backoff = time.Second
+		}
+		warnf("Unable to watch Docker, retrying in %s: %s", backoff, err)
+		select {
+		case <-ctx.Done():
+			sdNotify("STOPPING=1")
+			fmt.Println("Stopped watching for Docker containers")
+			return
+		case <-time.After(backoff):
+		}
+		if backoff *= 2; backoff > 30*time.Second {
+			backoff = 30 * time.Second
+		}
+	}
+}
+
+// watchDockerOnce applies the configs of containers that start while the
+// connection to Docker lasts, returning why it ended and whether it was up.
+// Containers that started before it connected are caught up on first, and
+// every -watch-reconcile-interval the configs of all running containers are
+// applied again.
+func watchDockerOnce(ctx context.Context, cli *client.Client, imageLabelCache map[string]map[string]string) (bool, error) {
+	messages, errs := cli.Events(ctx, types.EventsOptions{
+		Filters: filters.NewArgs(filters.Arg("type", "container"), filters.Arg("event", "start")),
+	})
+
+	containers, err := cli.ContainerList(ctx, types.ContainerListOptions{})
+	if err != nil {
+		return false, err
+	}
+	queued := processContainers(cli, containers, imageLabelCache, "running containers", false)
+	applyWatchedConfigs(queued, "running containers")
+
+	var reconcile <-chan time.Time
+	if *watchReconcileEvery > 0 {
+		ticker := time.NewTicker(*watchReconcileEvery)
+		defer ticker.Stop()
+		reconcile = ticker.C
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return true, ctx.Err()
+		case err := <-errs:
+			return true, err
+		case <-reconcile:
+			containers, err := cli.ContainerList(ctx, types.ContainerListOptions{})
+			if err != nil {
+				warnf("Unable to fetch Docker containers to reconcile: %s", err.Error())
+				continue
+			}
+			forgetStoppedContainers(containers)
+			resetChecks()
+			queued := processContainers(cli, containers, imageLabelCache, "reconcile", true)
+			reconciling = true
+			applyWatchedConfigs(queued, "every running container")
+			reconciling = false
+		case message := <-messages:
+			debugf("Container [%s] started", message.Actor.ID[:10])
+			containers, err := cli.ContainerList(ctx, types.ContainerListOptions{
+				Filters: filters.NewArgs(filters.Arg("id", message.Actor.ID)),
+			})
+			if err != nil {
+				warnf("%s: Unable to fetch started container: %s", message.Actor.ID[:10], err.Error())
+				continue
+			}
+			queued := processContainers(cli, containers, imageLabelCache, "container "+message.Actor.ID[:10], false)
+			applyWatchedConfigs(queued, "container "+message.Actor.ID[:10])
+		}
+	}
+}
+
+// processContainers queues the configs of new containers, and of those whose
+// labels changed, returning how many it queued. With all, the configs of
+// unchanged containers are queued again too.
+func processContainers(cli *client.Client, containers []types.Container, imageLabelCache map[string]map[string]string, cycle string, all bool) int {
+	queued := 0
+	counts := make(map[containerChange]int)
+	for _, container := range containers {
+		n, change := processContainerLabels(cli, container, imageLabelCache)
+		counts[change]++
+		queued += n
+		if change == containerUnchanged && all {
+			configs := watchedContainers[container.ID].configs
+			pendingConfigs = append(pendingConfigs, configs...)
+			queued += len(configs)
+		}
+	}
+	debugf("Containers of %s: %d unchanged, %d changed, %d new", cycle, counts[containerUnchanged], counts[containerChanged], counts[containerNew])
+	return queued
+}
+
+// forgetStoppedContainers forgets the labels of the containers that are no
+// longer running, so they don't build up.
+func forgetStoppedContainers(running []types.Container) {
+	ids := make(map[string]bool, len(running))
+	for _, container := range running {
+		ids[container.ID] = true
+	}
+	for id := range watchedContainers {
+		if !ids[id] {
+			delete(watchedContainers, id)
+		}
+	}
+}
+
+// applyWatchedConfigs applies the configs queued from source, if there are
+// any.
+func applyWatchedConfigs(queued int, source string) {
+	if queued == 0 {
+		return
+	}
+	before := currentApplyTotals()
+	applyConfigs()
+	pushRunMetrics()
+	flushAudit()
+	fmt.Printf("Applied %d Pub/Sub configurations from %s\n", queued, source)
+	reportProbes()
+	notifyApplied(appliedStatus(queued, before))
+}